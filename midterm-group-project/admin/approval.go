@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+func runApprovals(o *options, fs *flag.FlagSet, args []string) error {
+	if _, err := o.parse(fs, args); err != nil {
+		return err
+	}
+	client, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	pending, err := client.ListApprovals(protocol.ListRequest{Token: o.token})
+	if err != nil {
+		return err
+	}
+	o.emit(pending, func(w io.Writer) {
+		if len(pending) == 0 {
+			fmt.Fprintln(w, "No commands waiting for approval")
+			return
+		}
+		fmt.Fprintln(w, "ID\tCLIENT\tREQUESTER\tWAITING\tEXPIRES IN\tDIR\tCOMMAND")
+		now := time.Now()
+		for _, p := range pending {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%v\t%v\t%s\t%s\n", p.ApprovalID, p.ClientID, p.Requester,
+				now.Sub(p.RequestedAt).Round(time.Second), p.Expires.Sub(now).Round(time.Second), p.WorkDir, p.Command)
+		}
+	})
+	return nil
+}
+
+func runApprove(o *options, fs *flag.FlagSet, args []string) error {
+	return decideApprovals(o, fs, args, false)
+}
+
+func runReject(o *options, fs *flag.FlagSet, args []string) error {
+	return decideApprovals(o, fs, args, true)
+}
+
+// decideApprovals approves or rejects the pending commands named by args
+func decideApprovals(o *options, fs *flag.FlagSet, args []string, reject bool) error {
+	reason, usage := new(string), "usage: approve <id>..."
+	if reject {
+		reason = fs.String("reason", "", "Why the command is rejected, shown to the client")
+		usage = "usage: reject [-reason text] <id>..."
+	}
+	rest, err := o.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return usageError(usage)
+	}
+	ids := make([]int64, len(rest))
+	for i, arg := range rest {
+		if ids[i], err = strconv.ParseInt(arg, 10, 64); err != nil {
+			return usageError(fmt.Sprintf("invalid approval ID %q", arg))
+		}
+	}
+	client, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	type result struct {
+		ID      int64  `json:"id"`
+		Client  string `json:"client,omitempty"`
+		Command string `json:"command,omitempty"`
+		Result  string `json:"result"`
+		OK      bool   `json:"ok"`
+	}
+	var results []result
+	failed := 0
+	for _, id := range ids {
+		decision := protocol.ApprovalDecision{Token: o.token, ApprovalID: id, Reason: *reason}
+		var p protocol.PendingApproval
+		if reject {
+			p, err = client.RejectCommand(decision)
+		} else {
+			p, err = client.ApproveCommand(decision)
+		}
+		r := result{ID: id, Client: p.ClientID, Command: p.Command, Result: "approved", OK: err == nil}
+		switch {
+		case err != nil:
+			r.Result = err.Error()
+			failed++
+		case reject:
+			r.Result = "rejected"
+		}
+		results = append(results, r)
+	}
+	o.emit(results, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tCLIENT\tRESULT\tCOMMAND")
+		for _, r := range results {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", r.ID, orDash(r.Client), r.Result, orDash(r.Command))
+		}
+	})
+	if failed > 0 {
+		return fmt.Errorf("%d of %d commands not decided", failed, len(ids))
+	}
+	return nil
+}
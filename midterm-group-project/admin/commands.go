@@ -0,0 +1,353 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+func runClients(o *options, fs *flag.FlagSet, args []string) error {
+	if _, err := o.parse(fs, args); err != nil {
+		return err
+	}
+	client, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	clients, err := client.ListClients(protocol.ListRequest{Token: o.token})
+	if err != nil {
+		return err
+	}
+	sort.Strings(clients)
+	o.emit(clients, func(w io.Writer) {
+		fmt.Fprintln(w, "CLIENT")
+		for _, id := range clients {
+			fmt.Fprintln(w, id)
+		}
+	})
+	return nil
+}
+
+func runSessions(o *options, fs *flag.FlagSet, args []string) error {
+	rest, err := o.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 1 {
+		return usageError("usage: sessions [id]")
+	}
+	client, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if len(rest) == 1 {
+		info, err := client.SessionInfo(protocol.SessionInfoRequest{ID: rest[0], Token: o.token})
+		if err != nil {
+			return err
+		}
+		o.emit(info, func(w io.Writer) { printSessionInfo(w, info) })
+		return nil
+	}
+
+	list, err := client.ListSessions(protocol.ListSessionsRequest{Token: o.token})
+	if protocol.IsMissingMethod(err) {
+		list.Sessions, err = legacySessions(client, o.token)
+	}
+	if err != nil {
+		return err
+	}
+	sessions := list.Sessions
+	o.emit(sessions, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tFROM\tTRANSPORT\tIDLE\tCMDS\tRUNNING\tENV\tWORKDIR")
+		now := time.Now()
+		for _, s := range sessions {
+			from, transport := "-", "-"
+			if len(s.Connections) > 0 {
+				from, transport = s.Connections[0].RemoteAddr, s.Connections[0].TLSIdentity
+				if n := len(s.Connections); n > 1 {
+					from += fmt.Sprintf(" (+%d)", n-1)
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%d\t%d\t%d\t%s\n",
+				s.ID, from, transport, now.Sub(s.LastActive).Round(time.Second), s.Commands, s.Running, s.EnvCount, s.WorkDir)
+		}
+	})
+	return nil
+}
+
+func printSessionInfo(w io.Writer, s protocol.SessionInfo) {
+	fmt.Fprintf(w, "ID:\t%s\n", s.ID)
+	fmt.Fprintf(w, "Owner:\t%s\n", s.Owner)
+	fmt.Fprintf(w, "Work dir:\t%s\n", s.WorkDir)
+	fmt.Fprintf(w, "Env vars:\t%d\n", s.EnvCount)
+	fmt.Fprintf(w, "Started:\t%s\n", s.ConnectedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Last active:\t%s (%v ago)\n", s.LastActive.Format(time.RFC3339), time.Since(s.LastActive).Round(time.Second))
+	fmt.Fprintf(w, "Commands:\t%d run, %d running\n", s.Commands, s.Running)
+	if len(s.Connections) == 0 {
+		fmt.Fprintf(w, "Connections:\tnone\n")
+	}
+	for _, c := range s.Connections {
+		fmt.Fprintf(w, "Connection:\t%s (%s) since %s, in=%d out=%d bytes\n",
+			c.RemoteAddr, c.TLSIdentity, c.ConnectedAt.Format(time.RFC3339), c.BytesIn, c.BytesOut)
+	}
+}
+
+// legacySessions reads sessions from servers without ListSessionsV2
+func legacySessions(client *protocol.Client, token string) ([]protocol.SessionInfo, error) {
+	maps, err := client.ListSessionsLegacy(protocol.ListSessionsRequest{Token: token})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]protocol.SessionInfo, 0, len(maps))
+	for _, m := range maps {
+		info := protocol.SessionInfo{}
+		info.ID, _ = m["id"].(string)
+		info.WorkDir, _ = m["work_dir"].(string)
+		info.EnvCount, _ = m["env_count"].(int)
+		info.Active, _ = m["is_active"].(bool)
+		if v, ok := m["connected_at"].(string); ok {
+			info.ConnectedAt, _ = time.Parse(time.RFC3339, v)
+		}
+		if v, ok := m["last_active"].(string); ok {
+			info.LastActive, _ = time.Parse(time.RFC3339, v)
+		}
+		if connected, _ := m["connected"].(bool); connected {
+			c := protocol.ConnInfo{}
+			c.RemoteAddr, _ = m["remote_addr"].(string)
+			c.TLSIdentity, _ = m["tls_identity"].(string)
+			c.BytesIn, _ = m["bytes_in"].(int64)
+			c.BytesOut, _ = m["bytes_out"].(int64)
+			info.Connections = []protocol.ConnInfo{c}
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func runKill(o *options, fs *flag.FlagSet, args []string) error {
+	ids, err := o.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return usageError("usage: kill <id>...")
+	}
+	client, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	type result struct {
+		ID     string `json:"id"`
+		Result string `json:"result"`
+		OK     bool   `json:"ok"`
+	}
+	var results []result
+	failed := 0
+	for _, id := range ids {
+		reply, err := client.KillSession(protocol.KillSessionRequest{ID: id, Token: o.token})
+		if err != nil {
+			reply = err.Error()
+		}
+		// Failures ("unauthorized", "not found") come back as the reply text
+		ok := strings.HasPrefix(reply, "killed")
+		if !ok {
+			failed++
+		}
+		results = append(results, result{ID: id, Result: reply, OK: ok})
+	}
+	o.emit(results, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tRESULT")
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%s\n", r.ID, r.Result)
+		}
+	})
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sessions not killed", failed, len(ids))
+	}
+	return nil
+}
+
+func runBan(o *options, fs *flag.FlagSet, args []string) error {
+	lift := fs.Bool("lift", false, "Remove the ban instead")
+	ids, err := o.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if *lift && len(ids) == 0 {
+		return usageError("usage: ban -lift <id>...")
+	}
+	client, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var banned []string
+	if len(ids) == 0 {
+		banned, err = client.ListBanned(protocol.ListRequest{Token: o.token})
+	} else {
+		banned, err = client.BanClient(protocol.BanRequest{Token: o.token, IDs: ids, Lift: *lift})
+	}
+	if err != nil {
+		return err
+	}
+	o.emit(banned, func(w io.Writer) {
+		fmt.Fprintln(w, "BANNED")
+		for _, id := range banned {
+			fmt.Fprintln(w, id)
+		}
+	})
+	return nil
+}
+
+func runWhitelist(o *options, fs *flag.FlagSet, args []string) error {
+	rest, err := o.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return usageError("usage: whitelist add|rm|ls [cmd...]")
+	}
+	switch rest[0] {
+	case "add", "rm", "ls":
+	default:
+		return usageError(fmt.Sprintf("unknown whitelist action %q (want add, rm or ls)", rest[0]))
+	}
+	var cmds []string
+	for _, c := range rest[1:] {
+		if c = strings.TrimSpace(c); c != "" {
+			cmds = append(cmds, c)
+		}
+	}
+	if rest[0] != "ls" && len(cmds) == 0 {
+		return usageError(fmt.Sprintf("usage: whitelist %s <cmd>...", rest[0]))
+	}
+
+	client, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var allowed []string
+	switch rest[0] {
+	case "add":
+		allowed, err = client.AddToWhitelist(protocol.UpdateWhitelistRequest{Token: o.token, Commands: cmds})
+	case "rm":
+		allowed, err = client.RemoveFromWhitelist(protocol.UpdateWhitelistRequest{Token: o.token, Commands: cmds})
+	default:
+		allowed, err = client.ListWhitelist(protocol.ListRequest{Token: o.token})
+	}
+	if err != nil {
+		return err
+	}
+	o.emit(allowed, func(w io.Writer) {
+		if len(allowed) == 0 {
+			fmt.Fprintln(w, "Whitelist is empty: all commands are allowed")
+			return
+		}
+		fmt.Fprintln(w, "ALLOWED")
+		for _, c := range allowed {
+			fmt.Fprintln(w, c)
+		}
+	})
+	return nil
+}
+
+func runHistory(o *options, fs *flag.FlagSet, args []string) error {
+	limit := fs.Int("limit", 0, "Show only the last N commands (0 = all)")
+	rest, err := o.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usageError("usage: history [-limit n] <id>")
+	}
+	client, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	entries, err := client.History(protocol.HistoryRequest{ID: rest[0], Token: o.token, Limit: *limit})
+	if err != nil {
+		return err
+	}
+	o.emit(entries, func(w io.Writer) {
+		fmt.Fprintln(w, "SEQ\tSTARTED\tEXIT\tDURATION\tCOMMAND")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%v\t%s\n", e.Seq, e.StartedAt.Format(time.RFC3339), e.ExitCode, e.Duration.Round(time.Millisecond), e.Command)
+		}
+	})
+	return nil
+}
+
+func runAudit(o *options, fs *flag.FlagSet, args []string) error {
+	clientID := fs.String("client", "", "Only entries for this client ID")
+	limit := fs.Int("limit", 50, "Show the last N entries (0 = all kept by the server)")
+	if _, err := o.parse(fs, args); err != nil {
+		return err
+	}
+	client, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	entries, err := client.AuditLog(protocol.AuditRequest{Token: o.token, ClientID: *clientID, Limit: *limit})
+	if err != nil {
+		return err
+	}
+	o.emit(entries, func(w io.Writer) {
+		fmt.Fprintln(w, "SEQ\tTIME\tIDENTITY\tCLIENT\tACTION\tDETAIL\tOUTCOME")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				e.Seq, e.Time.Format(time.RFC3339), e.Identity, orDash(e.ClientID), e.Action, orDash(e.Detail), e.Outcome)
+		}
+	})
+	return nil
+}
+
+func runJobs(o *options, fs *flag.FlagSet, args []string) error {
+	if _, err := o.parse(fs, args); err != nil {
+		return err
+	}
+	client, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	jobs, err := client.ListJobs(protocol.ListRequest{Token: o.token})
+	if err != nil {
+		return err
+	}
+	o.emit(jobs, func(w io.Writer) {
+		fmt.Fprintln(w, "JOB\tCLIENT\tELAPSED\tDIR\tCOMMAND")
+		for _, j := range jobs {
+			fmt.Fprintf(w, "%d\t%s\t%v\t%s\t%s\n", j.JobID, j.ClientID, time.Since(j.StartedAt).Round(time.Second), j.WorkDir, j.Command)
+		}
+	})
+	return nil
+}
+
+// orDash shows empty values as "-" so table columns stay aligned
+func orDash(v interface{}) string {
+	s := fmt.Sprint(v)
+	if v == nil || s == "" {
+		return "-"
+	}
+	return s
+}
@@ -1,107 +1,174 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
-	"net/rpc"
+	"io"
+	"os"
 	"strings"
+	"text/tabwriter"
+
+	"remote-shell-rpc/protocol"
 )
 
-type ListRequest struct {
-	Token string
+// options are accepted before the subcommand and after it
+type options struct {
+	server string
+	token  string
+	output string
 }
 
-type ListSessionsRequest struct {
-	Token string
+func (o *options) register(fs *flag.FlagSet) {
+	// Current values as defaults, so flags given before the subcommand are
+	// kept when its own flag set is parsed
+	fs.StringVar(&o.server, "server", o.server, "RPC server address")
+	fs.StringVar(&o.token, "token", o.token, "Auth token (if server requires)")
+	fs.StringVar(&o.output, "output", o.output, "Output format: table or json")
 }
 
-type KillSessionRequest struct {
-	ID    string
-	Token string
+// parse parses a subcommand's flags, which may come before, between or
+// after its arguments, and returns the positional arguments
+func (o *options) parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		fs.Parse(args)
+		rest := fs.Args()
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		if len(rest) == 0 {
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	if o.output != "table" && o.output != "json" {
+		return nil, usageError("-output must be table or json")
+	}
+	return positional, nil
 }
 
-type UpdateWhitelistRequest struct {
-	Token    string
-	Commands []string
+// dial connects to the server and performs the protocol handshake
+func (o *options) dial() (*protocol.Client, error) {
+	client, err := protocol.Dial(o.server, "admin")
+	if isConnError(err) {
+		return nil, fmt.Errorf("failed to connect to %s: %v", o.server, err)
+	}
+	return client, err
 }
 
-func main() {
-	var serverAddr = flag.String("server", "localhost:8080", "RPC server address")
-	var token = flag.String("token", "", "Auth token (if server requires)")
-	var killID = flag.String("kill", "", "Kill session by client ID")
-	var listSessions = flag.Bool("sessions", false, "List sessions with details")
-	var addCmds = flag.String("allow-cmds", "", "Comma-separated commands to add to server whitelist")
-	flag.Parse()
+// emit prints v as JSON, or as a table rendered by table
+func (o *options) emit(v interface{}, table func(w io.Writer)) {
+	if o.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	table(tw)
+	tw.Flush()
+}
+
+// usageError is reported with exit code 2
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(o *options, fs *flag.FlagSet, args []string) error
+}
 
-	// Connect to server
-	client, err := rpc.Dial("tcp", *serverAddr)
-	if err != nil {
-		log.Fatal("Failed to connect:", err)
+var commands []command
+
+func init() {
+	commands = []command{
+		{"clients", "", "List client IDs with a session", runClients},
+		{"sessions", "[id]", "List sessions with connection details, or show one", runSessions},
+		{"kill", "<id>...", "End sessions, close their connections and ban the IDs", runKill},
+		{"ban", "[-lift] [id...]", "Ban or unban client IDs; list bans without IDs", runBan},
+		{"whitelist", "add|rm|ls [cmd...]", "Manage the command whitelist", runWhitelist},
+		{"history", "[-limit n] <id>", "Show a session's command history", runHistory},
+		{"audit", "[-client id] [-limit n]", "Show recent audit entries", runAudit},
+		{"jobs", "", "List commands currently running", runJobs},
+		{"approvals", "", "List commands waiting for approval", runApprovals},
+		{"approve", "<id>...", "Let commands waiting for approval run", runApprove},
+		{"reject", "[-reason text] <id>...", "Refuse commands waiting for approval", runReject},
+		{"top", "[-interval d] [-n count]", "Live view of sessions, running commands and denials", runTop},
+		{"shadow", "<id>", "Follow a session's commands and output read-only", runShadow},
+		{"replay", "[-speed x] <id> | -file file.cast", "Play back a session recording", runReplay},
+		{"broadcast", "<message>", "Show a message to every interactive client", runBroadcast},
 	}
-	defer client.Close()
-
-	// Dynamic whitelist update
-	if *addCmds != "" {
-		parts := strings.Split(*addCmds, ",")
-		cmds := make([]string, 0, len(parts))
-		for _, p := range parts {
-			p = strings.TrimSpace(p)
-			if p != "" {
-				cmds = append(cmds, p)
-			}
-		}
-		var resp []string
-		req := UpdateWhitelistRequest{Token: *token, Commands: cmds}
-		if err := client.Call("RemoteShellService.AddToWhitelist", req, &resp); err != nil {
-			log.Fatal("Error updating whitelist:", err)
-		}
-		fmt.Println("Updated whitelist commands:")
-		for _, c := range resp {
-			fmt.Printf("  - %s\n", c)
-		}
-		return
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: admin [-server addr] [-token t] [-output table|json] <command> [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-10s %-26s %s\n", c.name, c.args, c.summary)
 	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
 
-	if *killID != "" {
-		var resp string
-		req := KillSessionRequest{ID: *killID, Token: *token}
-		err := client.Call("RemoteShellService.KillSession", req, &resp)
-		if err != nil {
-			log.Fatal("Error killing session:", err)
+func main() {
+	o := &options{server: "localhost:8080", output: "table"}
+	o.register(flag.CommandLine)
+
+	// Flags from before subcommands existed, mapped onto them
+	var killID = flag.String("kill", "", "Kill session by client ID (same as: kill <id>)")
+	var listSessions = flag.Bool("sessions", false, "List sessions (same as: sessions)")
+	var addCmds = flag.String("allow-cmds", "", "Comma-separated commands to whitelist (same as: whitelist add)")
+	var historyID = flag.String("history", "", "Show command history (same as: history <id>)")
+	var historyLimit = flag.Int("limit", 0, "Limit for -history")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		switch {
+		case *addCmds != "":
+			args = append([]string{"whitelist", "add"}, strings.Split(*addCmds, ",")...)
+		case *killID != "":
+			args = []string{"kill", *killID}
+		case *historyID != "":
+			args = []string{"history", fmt.Sprintf("-limit=%d", *historyLimit), *historyID}
+		case *listSessions:
+			args = []string{"sessions"}
+		default:
+			args = []string{"clients"}
 		}
-		fmt.Printf("Kill session %s: %s\n", *killID, resp)
-		return
 	}
 
-	if *listSessions {
-		var sessions []map[string]interface{}
-		req := ListSessionsRequest{Token: *token}
-		err := client.Call("RemoteShellService.ListSessions", req, &sessions)
-		if err != nil {
-			log.Fatal("Error listing sessions:", err)
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == args[0] {
+			cmd = &commands[i]
 		}
-		fmt.Printf("Sessions (%d):\n", len(sessions))
-		for i, s := range sessions {
-			fmt.Printf("  %d. id=%v workdir=%v env=%v last=%v idle=%v\n",
-				i+1,
-				s["id"], s["work_dir"], s["env_count"], s["last_active"], s["idle"])
-		}
-		return
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "admin: unknown command %q\n\n", args[0])
+		usage()
+		os.Exit(2)
 	}
 
-	// Default: list active clients
-	var clients []string
-	req := ListRequest{Token: *token}
-	if err := client.Call("RemoteShellService.ListClients", req, &clients); err != nil {
-		log.Fatal("Error listing clients:", err)
+	fs := flag.NewFlagSet("admin "+cmd.name, flag.ExitOnError)
+	o.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: admin %s %s\n  %s\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
 	}
-	fmt.Printf("Active clients (%d):\n", len(clients))
-	for i, id := range clients {
-		fmt.Printf("  %d. %s\n", i+1, id)
+
+	if err := cmd.run(o, fs, args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "admin %s: %v\n", cmd.name, err)
+		if _, ok := err.(usageError); ok {
+			os.Exit(2)
+		}
+		os.Exit(1)
 	}
 }
-
-
-
-
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+func runReplay(o *options, fs *flag.FlagSet, args []string) error {
+	speed := fs.Float64("speed", 1, "Playback speed (2 = twice as fast)")
+	idleLimit := fs.Duration("idle-limit", 0, "Shorten pauses longer than this (0 = keep them)")
+	save := fs.String("o", "", "Save the recording to this file instead of playing it")
+	file := fs.String("file", "", "Play this local .cast file (e.g. saved earlier with -o) instead of a server recording")
+	rest, err := o.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if (*file == "") != (len(rest) == 1) || len(rest) > 1 {
+		return usageError("usage: replay [-speed x] [-idle-limit d] [-o file] <id> | replay -file file.cast")
+	}
+	if *speed <= 0 {
+		return usageError("-speed must be positive")
+	}
+
+	// A local file is only read when asked for, so a file in the current
+	// directory named like a session cannot stand in for its recording
+	var data []byte
+	if *file != "" {
+		data, err = os.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+	} else {
+		client, err := o.dial()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		if data, err = fetchRecording(client, o.token, rest[0]); err != nil {
+			return err
+		}
+	}
+
+	if *save != "" {
+		if err := os.WriteFile(*save, data, 0600); err != nil {
+			return err
+		}
+		result := map[string]interface{}{"file": *save, "bytes": len(data)}
+		o.emit(result, func(w io.Writer) {
+			fmt.Fprintf(w, "Saved %d bytes to %s\n", len(data), *save)
+		})
+		return nil
+	}
+	return playCast(os.Stdout, data, *speed, *idleLimit)
+}
+
+// fetchRecording downloads a session's newest recording as far as it goes
+func fetchRecording(client *protocol.Client, token, id string) ([]byte, error) {
+	var data []byte
+	for {
+		chunk, err := client.ReadRecording(protocol.RecordingRequest{Token: token, ID: id, Offset: int64(len(data))})
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk.Data...)
+		if chunk.EOF || len(chunk.Data) == 0 {
+			return data, nil
+		}
+	}
+}
+
+// playCast writes the output events of an asciicast v2 recording to w,
+// keeping their timing divided by speed. Input ("i") events are skipped:
+// the output already echoes the command lines, and a command's stdin is
+// not shown on its terminal either.
+func playCast(w io.Writer, data []byte, speed float64, idleLimit time.Duration) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return fmt.Errorf("empty recording")
+	}
+	var header struct{ Version int }
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Version != 2 {
+		return fmt.Errorf("not an asciicast v2 recording")
+	}
+
+	var last float64
+	for n := 2; scanner.Scan(); n++ {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("line %d: malformed event", n)
+		}
+		t, _ := event[0].(float64)
+		kind, _ := event[1].(string)
+		text, _ := event[2].(string)
+		if kind != "o" {
+			continue
+		}
+		pause := time.Duration((t - last) * float64(time.Second))
+		if idleLimit > 0 && pause > idleLimit {
+			pause = idleLimit
+		}
+		last = t
+		time.Sleep(time.Duration(float64(pause) / speed))
+		io.WriteString(w, text)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// runShadow follows a session read-only: every command it runs and the
+// output as it is produced, until the session ends or the server stops
+func runShadow(o *options, fs *flag.FlagSet, args []string) error {
+	rest, err := o.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usageError("usage: shadow <id>")
+	}
+	id := rest[0]
+	client, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if o.output == "table" {
+		fmt.Fprintf(os.Stderr, "Shadowing %s (read-only), Ctrl+C to stop\n", id)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	after := int64(-1)
+	for {
+		resp, err := client.WatchSession(protocol.PollRequest{ID: id, Token: o.token, After: after, Wait: 25 * time.Second})
+		if err != nil {
+			return err
+		}
+		if resp.Missed > 0 && o.output == "table" {
+			fmt.Fprintf(os.Stderr, "\n[shadow] %d events missed, output may be incomplete\n", resp.Missed)
+		}
+		for _, e := range resp.Events {
+			if o.output == "json" {
+				enc.Encode(e)
+			} else {
+				printShadowEvent(e)
+			}
+			if e.Kind == "session-end" {
+				return nil
+			}
+		}
+		after = resp.Next
+		if resp.Closed {
+			if o.output == "table" {
+				fmt.Fprintln(os.Stderr, "[shadow] server is shutting down")
+			}
+			return nil
+		}
+	}
+}
+
+func printShadowEvent(e protocol.Event) {
+	switch e.Kind {
+	case "command":
+		fmt.Printf("[%s] %s$ %s\n", e.Time.Format("15:04:05"), e.ClientID, e.Command)
+	case "output":
+		fmt.Print(e.Output)
+	case "exit":
+		if e.Message != "" {
+			fmt.Printf("[exit %d: %s]\n", e.ExitCode, e.Message)
+		} else {
+			fmt.Printf("[exit %d]\n", e.ExitCode)
+		}
+	case "broadcast":
+		fmt.Fprintf(os.Stderr, "[broadcast %s] %s\n", e.Time.Format("15:04:05"), e.Message)
+	case "approval":
+		fmt.Fprintf(os.Stderr, "[approval] %s: %s\n", e.Message, e.Command)
+	case "session-end":
+		fmt.Fprintf(os.Stderr, "[shadow] session %s ended: %s\n", e.ClientID, e.Message)
+	}
+}
+
+func runBroadcast(o *options, fs *flag.FlagSet, args []string) error {
+	rest, err := o.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	message := strings.TrimSpace(strings.Join(rest, " "))
+	if message == "" {
+		return usageError("usage: broadcast <message>")
+	}
+	client, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	sessions, err := client.Broadcast(protocol.BroadcastRequest{Token: o.token, Message: message})
+	if err != nil {
+		return err
+	}
+	result := map[string]interface{}{"message": message, "sessions": sessions}
+	o.emit(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Broadcast sent (%d sessions)\n", sessions)
+	})
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// topRows limits each section so the view fits a terminal
+const topRows = 15
+
+func runTop(o *options, fs *flag.FlagSet, args []string) error {
+	interval := fs.Duration("interval", 2*time.Second, "Refresh interval")
+	count := fs.Int("n", 0, "Stop after n refreshes (0 = until interrupted)")
+	if _, err := o.parse(fs, args); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return usageError("-interval must be positive")
+	}
+
+	var client *protocol.Client
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+	var lastErr error
+	for i := 0; *count == 0 || i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+
+		// Keep refreshing through server restarts, redialing as needed
+		var snap protocol.TopSnapshot
+		var err error
+		lastErr = nil
+		if client == nil {
+			client, err = o.dial()
+		}
+		if err == nil {
+			snap, err = client.Top(protocol.ListRequest{Token: o.token})
+			if isConnError(err) {
+				client.Close()
+				client = nil
+			}
+		}
+		if err != nil {
+			if !isConnError(err) {
+				return err // Refused by the server, e.g. unauthorized
+			}
+			if o.output == "table" {
+				fmt.Print("\033[H\033[2J")
+			}
+			fmt.Fprintf(os.Stderr, "%s  %v (retrying every %v)\n", time.Now().Format("15:04:05"), err, *interval)
+			lastErr = err
+			continue
+		}
+
+		if o.output == "json" {
+			o.emit(snap, nil)
+			continue
+		}
+		fmt.Print("\033[H\033[2J")
+		renderTop(os.Stdout, o.server, snap, *interval)
+	}
+	return lastErr
+}
+
+// isConnError reports whether err came from the connection rather than the
+// server or a failed handshake
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var versionErr *protocol.VersionError
+	_, fromServer := err.(rpc.ServerError)
+	return !fromServer && !errors.As(err, &versionErr)
+}
+
+func renderTop(out io.Writer, server string, snap protocol.TopSnapshot, interval time.Duration) {
+	fmt.Fprintf(out, "admin top - %s  %s  sessions: %d  connections: %d  running: %d  (every %v, Ctrl+C to quit)\n",
+		server, snap.Time.Format("15:04:05"), snap.Sessions, snap.Connections, len(snap.Jobs), interval)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\nRUNNING COMMANDS\n")
+	fmt.Fprintln(w, "JOB\tCLIENT\tELAPSED\tCOMMAND")
+	for i, j := range snap.Jobs {
+		if i == topRows {
+			fmt.Fprintf(w, "...\t%d more\t\t\n", len(snap.Jobs)-topRows)
+			break
+		}
+		fmt.Fprintf(w, "%d\t%s\t%v\t%s\n", j.JobID, j.ClientID, snap.Time.Sub(j.StartedAt).Round(time.Second), truncate(j.Command, 60))
+	}
+
+	fmt.Fprintf(w, "\nCLIENTS\n")
+	fmt.Fprintln(w, "CLIENT\tREQ/MIN\tRUNNING\tFROM\tIDLE")
+	for i, c := range snap.Clients {
+		if i == topRows {
+			fmt.Fprintf(w, "...\t%d more\t\t\t\n", len(snap.Clients)-topRows)
+			break
+		}
+		from, idle := "-", "-"
+		if c.Connected {
+			from = c.RemoteAddr
+		}
+		if !c.LastActive.IsZero() {
+			idle = snap.Time.Sub(c.LastActive).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", c.ClientID, c.RequestsLastMin, c.Running, from, idle)
+	}
+
+	reasons := make([]string, 0, len(snap.DenialTotal))
+	for reason, n := range snap.DenialTotal {
+		reasons = append(reasons, fmt.Sprintf("%s=%d", reason, n))
+	}
+	sort.Strings(reasons)
+	fmt.Fprintf(w, "\nRECENT DENIALS (since start: %s)\n", orDash(strings.Join(reasons, " ")))
+	fmt.Fprintln(w, "TIME\tCLIENT\tACTION\tOUTCOME\tDETAIL")
+	for _, e := range snap.Denials {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Time.Format("15:04:05"), orDash(e.ClientID), e.Action, e.Outcome, truncate(e.Detail, 40))
+	}
+	w.Flush()
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
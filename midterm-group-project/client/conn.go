@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/rpc"
+	"os"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// errNotConnected is returned when a call is made while the connection is
+// down and reconnecting has been given up
+var errNotConnected = errors.New("not connected to server")
+
+// errSessionReset is returned instead of retrying a call after the server
+// started a fresh session on reconnect: the call was meant for the old
+// session's environment and working directory, which are gone
+var errSessionReset = errors.New("server started a new session (previous environment and directory lost); not retried")
+
+// backoffPolicy controls reconnect attempts: the delay before attempt n is
+// drawn uniformly from [0, min(Max, Initial*2^n)] ("full jitter").
+type backoffPolicy struct {
+	Initial  time.Duration
+	Max      time.Duration
+	Attempts int // Attempts per reconnect (0 = retry forever)
+}
+
+var defaultBackoff = backoffPolicy{Initial: 500 * time.Millisecond, Max: 30 * time.Second, Attempts: 8}
+
+func (b backoffPolicy) delay(attempt int) time.Duration {
+	d := b.Initial << uint(attempt)
+	if d <= 0 || d > b.Max {
+		d = b.Max
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// retryable RPCs are safe to re-send after a reconnect because running them
+// twice has the same effect as running them once
+var retryable = map[string]bool{
+	"RemoteShellService.Heartbeat":   true,
+	"RemoteShellService.Register":    true,
+	"RemoteShellService.SetEnv":      true,
+	"RemoteShellService.UnsetEnv":    true,
+	"RemoteShellService.GetEnv":      true,
+	"RemoteShellService.ListEnv":     true,
+	"RemoteShellService.ChangeDir":   true,
+	"RemoteShellService.History":     true,
+	"RemoteShellService.Download":    true,
+	"RemoteShellService.FetchOutput": true,
+	"RemoteShellService.PollEvents":  true,
+	// Execute carries a RequestID; the server replays the stored result of
+	// an attempt that already ran instead of running the command again
+	"RemoteShellService.Execute": true,
+}
+
+// isTransportError reports whether err means the connection is unusable, as
+// opposed to an error returned by the server method itself
+func isTransportError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var serverErr rpc.ServerError
+	return !errors.As(err, &serverErr)
+}
+
+// IsConnected reports whether the client currently holds a live connection
+func (c *RemoteShellClient) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+// conn returns the current RPC client, or nil when disconnected
+func (c *RemoteShellClient) conn() (*rpc.Client, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return nil, c.gen
+	}
+	return c.client, c.gen
+}
+
+// markDisconnected drops the connection with the given generation. Stale
+// generations are ignored so a late failure cannot tear down a connection
+// that another goroutine has already replaced.
+func (c *RemoteShellClient) markDisconnected(gen uint64, cause error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen || !c.connected {
+		return
+	}
+	c.connected = false
+	c.client.Close()
+	c.statusf("Connection to %s lost: %v", c.serverAddr, cause)
+}
+
+// call invokes an RPC, reconnecting with backoff when the connection is down
+// and retrying once afterwards if the method is safe to repeat
+func (c *RemoteShellClient) call(method string, args interface{}, reply interface{}) error {
+	// args carry the session secret current when they were built
+	secret := c.sessionSecret()
+
+	client, gen := c.conn()
+	if client == nil {
+		if err := c.Reconnect(); err != nil {
+			return err
+		}
+		if c.sessionSecret() != secret {
+			return errSessionReset
+		}
+		client, gen = c.conn()
+		if client == nil {
+			return errNotConnected
+		}
+	}
+
+	err := client.Call(method, args, reply)
+	if !isTransportError(err) {
+		return err
+	}
+	c.markDisconnected(gen, err)
+	if !retryable[method] {
+		return fmt.Errorf("%v (connection lost, not retried)", err)
+	}
+	if rerr := c.Reconnect(); rerr != nil {
+		return fmt.Errorf("%v (%v)", err, rerr)
+	}
+	if c.sessionSecret() != secret {
+		return errSessionReset
+	}
+	client, _ = c.conn()
+	if client == nil {
+		return errNotConnected
+	}
+	if err := client.Call(method, args, reply); err != nil {
+		return fmt.Errorf("failed after reconnect: %v", err)
+	}
+	return nil
+}
+
+// Reconnect re-dials the server with exponential backoff and jitter and, if
+// the client had registered, registers again so the server side session
+// (environment and working directory) is resumed. Concurrent callers share
+// a single reconnect.
+func (c *RemoteShellClient) Reconnect() error {
+	c.reconnectMu.Lock()
+	defer c.reconnectMu.Unlock()
+
+	c.mu.Lock()
+	connected, closed := c.connected, c.closed
+	c.mu.Unlock()
+	if closed {
+		return errNotConnected
+	}
+	if connected {
+		return nil // Another goroutine reconnected while we waited
+	}
+
+	var lastErr error
+	for attempt := 0; c.backoff.Attempts == 0 || attempt < c.backoff.Attempts; attempt++ {
+		wait := c.backoff.delay(attempt)
+		if c.backoff.Attempts > 0 {
+			c.statusf("Reconnecting to %s in %v (attempt %d/%d)", c.serverAddr, wait.Round(time.Millisecond), attempt+1, c.backoff.Attempts)
+		} else {
+			c.statusf("Reconnecting to %s in %v (attempt %d)", c.serverAddr, wait.Round(time.Millisecond), attempt+1)
+		}
+		time.Sleep(wait)
+
+		client, err := c.dial()
+		var versionErr *protocol.VersionError
+		if errors.As(err, &versionErr) {
+			return err // The server was replaced by an incompatible one
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		c.mu.Lock()
+		c.client = client
+		c.connected = true
+		c.gen++
+		registered := c.registered
+		c.mu.Unlock()
+
+		if !registered {
+			c.statusf("Reconnected to %s", c.serverAddr)
+			return nil
+		}
+		req := protocol.RegisterRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret()}
+		resp, err := protocol.NewClient(client).Register(req)
+		if err != nil {
+			lastErr = err
+			c.markDisconnected(c.currentGen(), err)
+			continue
+		}
+		if resp.Error != "" {
+			// The server is reachable but refuses us (e.g. banned); retrying will not help
+			return fmt.Errorf("reconnected but registration failed: %s", resp.Error)
+		}
+		c.mu.Lock()
+		c.secret = resp.Secret
+		c.mu.Unlock()
+		if resp.Resumed {
+			c.statusf("Reconnected to %s, session %s resumed", c.serverAddr, c.id)
+		} else {
+			c.statusf("Reconnected to %s, previous session expired; started new session %s", c.serverAddr, c.id)
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to reconnect to %s: %v", c.serverAddr, lastErr)
+}
+
+func (c *RemoteShellClient) currentGen() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// dial connects to the server and checks it speaks a compatible protocol
+func (c *RemoteShellClient) dial() (*rpc.Client, error) {
+	client, err := rpc.Dial("tcp", c.serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := protocol.NewClient(client).Hello("client"); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// statusf reports connection state changes to the user on stderr
+func (c *RemoteShellClient) statusf(format string, args ...interface{}) {
+	if c.quiet {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[connection] "+format+"\n", args...)
+}
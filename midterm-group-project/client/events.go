@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// pollWait is how long the server holds each poll open
+const pollWait = 25 * time.Second
+
+// watchEvents long-polls the server for broadcasts and session events and
+// hands them to show until the client is closed or the server turns out not
+// to support events. Polling continues across reconnects and restarts.
+func (c *RemoteShellClient) watchEvents(show func(protocol.Event)) {
+	after, gen := int64(-1), c.currentGen()
+	for {
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+
+		// Sequence numbers restart with the server, so start over after
+		// every reconnect
+		if g := c.currentGen(); g != gen {
+			after, gen = -1, g
+		}
+
+		req := protocol.PollRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret(), After: after, Wait: pollWait}
+		resp, err := c.rpc.PollEvents(req)
+		if protocol.IsMissingMethod(err) {
+			return // Older server without events
+		}
+		if err != nil || resp.Error != "" {
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, e := range resp.Events {
+			show(e)
+		}
+		after = resp.Next
+		if resp.Closed {
+			// The server is draining; wait for it to go away or come back
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// eventPrinter shows broadcasts and approval news in the interactive shell,
+// redrawing the prompt if the user was waiting at it
+type eventPrinter struct {
+	prompt  string
+	atInput atomic.Bool
+}
+
+func (p *eventPrinter) show(e protocol.Event) {
+	var msg string
+	switch e.Kind {
+	case "broadcast":
+		msg = fmt.Sprintf("[broadcast %s] %s", e.Time.Format("15:04:05"), e.Message)
+	case "approval":
+		msg = fmt.Sprintf("[approval] %s", e.Message)
+	case "session-end":
+		msg = fmt.Sprintf("[server] session ended: %s", e.Message)
+	default:
+		return
+	}
+	if p.atInput.Load() {
+		fmt.Fprintf(os.Stderr, "\r\033[K%s\n%s", msg, p.prompt)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\n%s\n", msg)
+}
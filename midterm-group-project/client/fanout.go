@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+const defaultServerPort = "8080"
+
+// inventoryHost is one entry of a host inventory file
+type inventoryHost struct {
+	Name string // Label used to prefix output
+	Addr string // host:port of the remote shell server
+}
+
+// hostResult is the outcome of running a command on one host
+type hostResult struct {
+	Host     inventoryHost
+	ExitCode int
+	Duration time.Duration
+	Err      error
+}
+
+// loadInventory reads a host inventory file. Each non-empty, non-comment
+// line is "<host[:port]> [label]"; the port defaults to 8080.
+func loadInventory(path string) ([]inventoryHost, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hosts []inventoryHost
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected <host[:port]> [label]", path, lineNo)
+		}
+		addr, err := inventoryAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+		name := fields[0]
+		if len(fields) == 2 {
+			name = fields[1]
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s:%d: duplicate host %s", path, lineNo, name)
+		}
+		seen[name] = true
+		hosts = append(hosts, inventoryHost{Name: name, Addr: addr})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("%s: no hosts", path)
+	}
+	return hosts, nil
+}
+
+// inventoryAddr adds the default port to a host without one. IPv6
+// addresses may be given bare (::1) or in brackets, with or without a port.
+func inventoryAddr(host string) (string, error) {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host, nil
+	}
+	bare := host
+	if strings.HasPrefix(bare, "[") && strings.HasSuffix(bare, "]") {
+		bare = bare[1 : len(bare)-1]
+	}
+	if bare == "" || strings.ContainsAny(bare, "[]") {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	return net.JoinHostPort(bare, defaultServerPort), nil
+}
+
+// runFanout executes command on every host with at most parallel
+// connections at a time. Output of each host is printed as one block with
+// every line prefixed by the host label. Results keep inventory order.
+func runFanout(hosts []inventoryHost, command, clientID, token string, parallel int, out io.Writer) []hostResult {
+	if parallel <= 0 {
+		parallel = len(hosts)
+	}
+	results := make([]hostResult, len(hosts))
+	sem := make(chan struct{}, parallel)
+	var printMu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i, h := range hosts {
+		wg.Add(1)
+		go func(i int, h inventoryHost) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			started := time.Now()
+			resp, err := runOnHost(h, command, clientID, token)
+			res := hostResult{Host: h, Duration: time.Since(started), Err: err, ExitCode: -1}
+			if resp != nil {
+				res.ExitCode = resp.ExitCode
+			}
+			results[i] = res
+
+			printMu.Lock()
+			defer printMu.Unlock()
+			if err != nil {
+				fmt.Fprintf(out, "[%s] error: %v\n", h.Name, err)
+				return
+			}
+			writePrefixed(out, h.Name, resp.Output)
+			if resp.OutputHandle != "" {
+				writePrefixed(out, h.Name, fmt.Sprintf("(output cut at %d of %d bytes)\n", len(resp.Output), resp.OutputSize))
+			}
+			if resp.Error != "" {
+				writePrefixed(out, h.Name, resp.Error+"\n")
+			}
+		}(i, h)
+	}
+	wg.Wait()
+	return results
+}
+
+// runOnHost opens a dedicated session on one host and runs command
+func runOnHost(h inventoryHost, command, clientID, token string) (*protocol.CommandResponse, error) {
+	c, err := NewRemoteShellClient(h.Addr, clientID, token)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	// Keep fan-out snappy: a host that drops mid-run gets one quick retry
+	c.quiet = true
+	c.backoff.Attempts = 1
+	if err := c.Register(); err != nil {
+		return nil, fmt.Errorf("register: %v", err)
+	}
+	return c.Execute(command)
+}
+
+func writePrefixed(out io.Writer, prefix, text string) {
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(out, "[%s] %s\n", prefix, line)
+	}
+}
+
+// printFanoutSummary writes a table of per-host results and returns the
+// number of hosts that failed (transport error or non-zero exit)
+func printFanoutSummary(out io.Writer, results []hostResult) int {
+	failed := 0
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tADDRESS\tEXIT\tDURATION\tSTATUS")
+	for _, r := range results {
+		status := "ok"
+		exit := fmt.Sprintf("%d", r.ExitCode)
+		switch {
+		case r.Err != nil:
+			status = r.Err.Error()
+			exit = "-"
+			failed++
+		case r.ExitCode != 0:
+			status = "failed"
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Host.Name, r.Host.Addr, exit, r.Duration.Round(time.Millisecond), status)
+	}
+	tw.Flush()
+	fmt.Fprintf(out, "%d/%d hosts succeeded\n", len(results)-failed, len(results))
+	return failed
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInventoryAddr(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"web1", "web1:" + defaultServerPort},
+		{"web1:9000", "web1:9000"},
+		{"10.0.0.5", "10.0.0.5:" + defaultServerPort},
+		{"::1", "[::1]:" + defaultServerPort},
+		{"[::1]", "[::1]:" + defaultServerPort},
+		{"[::1]:9000", "[::1]:9000"},
+		{"[fe80::1%eth0]", "[fe80::1%eth0]:" + defaultServerPort},
+	}
+	for _, tt := range tests {
+		if got, err := inventoryAddr(tt.in); err != nil || got != tt.want {
+			t.Errorf("inventoryAddr(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"[::1", "[]", "[[::1]]"} {
+		if got, err := inventoryAddr(bad); err == nil {
+			t.Errorf("inventoryAddr(%q) = %q, want an error", bad, got)
+		}
+	}
+}
+
+func TestLoadInventory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	data := "# fleet\nweb1\n[::1] local   # loopback\n10.0.0.5:9000 db\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := loadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []inventoryHost{
+		{Name: "web1", Addr: "web1:" + defaultServerPort},
+		{Name: "local", Addr: "[::1]:" + defaultServerPort},
+		{Name: "db", Addr: "10.0.0.5:9000"},
+	}
+	if len(hosts) != len(want) {
+		t.Fatalf("hosts = %+v, want %+v", hosts, want)
+	}
+	for i := range want {
+		if hosts[i] != want[i] {
+			t.Errorf("host %d = %+v, want %+v", i, hosts[i], want[i])
+		}
+	}
+}
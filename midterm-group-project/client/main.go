@@ -2,159 +2,388 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/rpc"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"remote-shell-rpc/protocol"
 )
 
-// CommandRequest and CommandResponse must match server definitions
-type CommandRequest struct {
-	Command string
-	Args    []string
-	ID      string
-	Token   string
+type RemoteShellClient struct {
+	id           string
+	serverAddr   string
+	token        string
+	backoff      backoffPolicy
+	quiet        bool             // Suppress connection status messages
+	approvalWait time.Duration    // How long Execute waits for an admin to approve a command (0 = server's limit)
+	pageOutput   bool             // Show long output a page at a time (interactive) instead of all of it
+	cut          cutOutput        // Output of the last command past what was shown
+	rpc          *protocol.Client // Typed calls through call, so they reconnect and retry
+
+	mu         sync.Mutex // Guards the fields below
+	client     *rpc.Client
+	connected  bool
+	gen        uint64 // Incremented on every successful (re)connect
+	registered bool   // Register succeeded, so reconnects re-register
+	secret     string // Session secret issued by Register
+	closed     bool
+
+	reconnectMu sync.Mutex // Serializes reconnect attempts
 }
 
-type CommandResponse struct {
-	Output   string
-	Error    string
-	ExitCode int
-	ID       string
+func NewRemoteShellClient(serverAddr string, clientID string, token string) (*RemoteShellClient, error) {
+	c := &RemoteShellClient{
+		id:         clientID,
+		serverAddr: serverAddr,
+		token:      token,
+		backoff:    defaultBackoff,
+	}
+	client, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %v", err)
+	}
+	c.client = client
+	c.connected = true
+	c.rpc = protocol.NewClient(protocol.CallerFunc(c.call))
+	return c, nil
 }
 
-type HeartbeatRequest struct {
-	ID    string
-	Token string
+// SendHeartbeat sends a heartbeat to keep the session alive
+func (c *RemoteShellClient) SendHeartbeat() error {
+	resp, err := c.rpc.Heartbeat(protocol.HeartbeatRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret()})
+	if err != nil {
+		return err
+	}
+	return protocol.ReplyError(resp)
 }
 
-type RegisterRequest struct {
-	ID    string
-	Token string
+func (c *RemoteShellClient) Execute(command string) (*protocol.CommandResponse, error) {
+	return c.ExecuteInput(command, nil)
 }
 
-type EnvRequest struct {
-	ID    string
-	Token string
-	Key   string
-	Value string
+// ExecuteInput runs command with stdin as its input (nil = none). Small
+// input is sent with the command, larger input is uploaded first.
+func (c *RemoteShellClient) ExecuteInput(command string, stdin io.Reader) (*protocol.CommandResponse, error) {
+	// The same RequestID is sent on every retry so the server runs the
+	// command at most once and replays the stored result otherwise
+	req := protocol.CommandRequest{
+		Command:   command,
+		ID:        c.id,
+		Token:     c.token,
+		Secret:    c.sessionSecret(),
+		RequestID: newRequestID(),
+		// How long to wait if the command needs an admin's approval
+		ApprovalWait: c.approvalWait,
+	}
+	if stdin != nil {
+		head, err := io.ReadAll(io.LimitReader(stdin, protocol.MaxInlineStdin+1))
+		if err != nil {
+			return nil, fmt.Errorf("read stdin: %v", err)
+		}
+		if len(head) <= protocol.MaxInlineStdin {
+			req.Stdin = head
+		} else if req.StdinHandle, err = c.UploadStdin(io.MultiReader(bytes.NewReader(head), stdin)); err != nil {
+			return nil, fmt.Errorf("upload stdin: %v", err)
+		}
+	}
+	resp, err := c.rpc.Execute(req)
+	if err != nil {
+		return nil, fmt.Errorf("execution failed: %v", err)
+	}
+
+	return &resp, nil
 }
 
-type DirRequest struct {
-	ID    string
-	Token string
-	Dir   string
+// SetEnv sets a variable on the remote session, as a secret the server
+// never shows again if sensitive is set. It reports whether the server keeps
+// the value secret, which it may also do for keys its policy names.
+func (c *RemoteShellClient) SetEnv(key, value string, sensitive bool) (bool, error) {
+	resp, err := c.rpc.SetEnv(protocol.EnvRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret(), Key: key, Value: value, Sensitive: sensitive})
+	if err != nil {
+		return false, err
+	}
+	if err := protocol.ReplyError(resp); err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(resp, "Set "+key+" (secret)"), nil
 }
 
-type RemoteShellClient struct {
-	client     *rpc.Client
-	id         string
-	serverAddr string
-	connected  bool
-	token      string
+// UnsetEnv removes a variable from the remote session environment
+func (c *RemoteShellClient) UnsetEnv(key string) error {
+	resp, err := c.rpc.UnsetEnv(protocol.EnvKeyRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret(), Key: key})
+	if err != nil {
+		return err
+	}
+	return protocol.ReplyError(resp)
 }
 
-func NewRemoteShellClient(serverAddr string, clientID string, token string) (*RemoteShellClient, error) {
-	client, err := rpc.Dial("tcp", serverAddr)
+// GetEnv returns the value of a session variable and whether it is set
+func (c *RemoteShellClient) GetEnv(key string) (string, bool, error) {
+	resp, err := c.rpc.GetEnv(protocol.EnvKeyRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret(), Key: key})
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to server: %v", err)
+		return "", false, err
 	}
+	if resp.Error != "" {
+		return "", false, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Value, resp.Exists, nil
+}
 
-	return &RemoteShellClient{
-		client:     client,
-		id:         clientID,
-		serverAddr: serverAddr,
-		connected:  true,
-		token:      token,
-	}, nil
+// ListEnv returns every variable set on the remote session
+func (c *RemoteShellClient) ListEnv() (map[string]string, error) {
+	return c.rpc.ListEnv(protocol.ListEnvRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret()})
 }
 
-// Reconnect attempts to reconnect to the server
-func (c *RemoteShellClient) Reconnect() error {
-	if c.connected {
-		c.client.Close()
+// LoadEnvFile reads a dotenv file and sets every variable on the remote session
+func (c *RemoteShellClient) LoadEnvFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
 	}
-	
-	client, err := rpc.Dial("tcp", c.serverAddr)
+	defer f.Close()
+
+	vars, err := parseDotenv(f)
 	if err != nil {
-		c.connected = false
-		return fmt.Errorf("failed to reconnect: %v", err)
+		return 0, fmt.Errorf("%s: %v", path, err)
 	}
-	
-	c.client = client
-	c.connected = true
-	return nil
+	for i, kv := range vars {
+		if _, err := c.SetEnv(kv[0], kv[1], false); err != nil {
+			return i, fmt.Errorf("set %s: %v", kv[0], err)
+		}
+	}
+	return len(vars), nil
 }
 
-// SendHeartbeat sends a heartbeat to keep the session alive
-func (c *RemoteShellClient) SendHeartbeat() error {
-	var resp string
-	req := HeartbeatRequest{ID: c.id, Token: c.token}
-	err := c.client.Call("RemoteShellService.Heartbeat", req, &resp)
+func (c *RemoteShellClient) ChangeDir(dir string) error {
+	resp, err := c.rpc.ChangeDir(protocol.DirRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret(), Dir: dir})
 	if err != nil {
-		c.connected = false
 		return err
 	}
-	return nil
+	return protocol.ReplyError(resp)
 }
 
-func (c *RemoteShellClient) Execute(command string) (*CommandResponse, error) {
-	req := CommandRequest{
-		Command: command,
-		ID:      c.id,
-		Token:   c.token,
-	}
-	var resp CommandResponse
+// History returns the last limit commands recorded for this session (0 = all)
+func (c *RemoteShellClient) History(limit int) ([]protocol.HistoryEntry, error) {
+	return c.rpc.History(protocol.HistoryRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret(), Limit: limit})
+}
 
-	err := c.client.Call("RemoteShellService.Execute", req, &resp)
+// SessionInfo describes this client's session as the server sees it
+func (c *RemoteShellClient) SessionInfo() (*protocol.SessionInfo, error) {
+	resp, err := c.rpc.SessionInfo(protocol.SessionInfoRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret()})
 	if err != nil {
-		// Try to reconnect once
-		if reconnectErr := c.Reconnect(); reconnectErr == nil {
-			// Retry the call
-			err = c.client.Call("RemoteShellService.Execute", req, &resp)
-			if err != nil {
-				return nil, fmt.Errorf("execution failed after reconnect: %v", err)
-			}
-		} else {
-			return nil, fmt.Errorf("execution failed: %v", err)
-		}
+		return nil, err
 	}
-
 	return &resp, nil
 }
 
-func (c *RemoteShellClient) SetEnv(key, value string) error {
-	req := EnvRequest{ID: c.id, Token: c.token, Key: key, Value: value}
-	var resp string
-	return c.client.Call("RemoteShellService.SetEnv", req, &resp)
+// historyRef splits a line starting with a history reference ("!!", "!n"
+// or "!prefix") into the reference without its "!" and the rest of the
+// line. As in sh, a "!" followed by a blank, "=" or "(" is not a reference,
+// so lines like "! grep foo file" reach the remote shell unchanged.
+func historyRef(line string) (string, string, bool) {
+	if len(line) < 2 || line[0] != '!' || strings.ContainsRune(" \t=(", rune(line[1])) {
+		return "", "", false
+	}
+	word, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		word, rest = line[:i], line[i:]
+	}
+	return word[1:], rest, true
 }
 
-func (c *RemoteShellClient) ChangeDir(dir string) error {
-	req := DirRequest{ID: c.id, Token: c.token, Dir: dir}
-	var resp string
-	return c.client.Call("RemoteShellService.ChangeDir", req, &resp)
+// lookupHistory resolves a history reference (see historyRef) to the
+// recorded command
+func (c *RemoteShellClient) lookupHistory(ref string) (string, error) {
+	entries, err := c.History(0)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("history is empty")
+	}
+	if ref == "!" {
+		return entries[len(entries)-1].Command, nil
+	}
+	if seq, err := strconv.Atoi(ref); err == nil {
+		for _, e := range entries {
+			if e.Seq == seq {
+				return e.Command, nil
+			}
+		}
+		return "", fmt.Errorf("!%d: event not found", seq)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if strings.HasPrefix(entries[i].Command, ref) {
+			return entries[i].Command, nil
+		}
+	}
+	return "", fmt.Errorf("!%s: event not found", ref)
 }
 
+// Register opens (or resumes) the server session and stores the session
+// secret the server requires on every later call
 func (c *RemoteShellClient) Register() error {
-	var resp string
-	req := RegisterRequest{ID: c.id, Token: c.token}
-	return c.client.Call("RemoteShellService.Register", req, &resp)
+	resp, err := c.rpc.Register(protocol.RegisterRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret()})
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	c.mu.Lock()
+	c.secret = resp.Secret
+	c.registered = true
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *RemoteShellClient) sessionSecret() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.secret
 }
 
+// Close ends the session, if one was registered, and closes the
+// connection. The session secret is not kept anywhere, so a session left
+// behind could not be resumed and would only block the client ID until it
+// expires.
 func (c *RemoteShellClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if !c.connected {
+		return nil
+	}
+	c.connected = false
+	if c.registered {
+		c.registered = false
+		protocol.NewClient(c.client).EndSession(protocol.EndSessionRequest{ID: c.id, Token: c.token, Secret: c.secret})
+	}
 	return c.client.Close()
 }
 
+// newRequestID returns a random identifier for one Execute call
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := cryptorand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// parseDotenv parses KEY=VALUE lines in dotenv format, keeping file order.
+// Blank lines and # comments are skipped, an optional "export " prefix is
+// accepted and quotes around the value are removed (see parseAssignment).
+func parseDotenv(r io.Reader) ([][2]string, error) {
+	var vars [][2]string
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		key, value, ok := parseAssignment(line)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		vars = append(vars, [2]string{key, value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// parseAssignment splits "KEY=VALUE". A value in single quotes is taken as
+// is; in double quotes \n, \t, \" and \\ are unescaped. A key with spaces
+// or a quote that is never closed is malformed.
+func parseAssignment(s string) (string, string, bool) {
+	key, value, ok := strings.Cut(s, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" || strings.ContainsAny(key, " \t") {
+		return "", "", false
+	}
+	value = strings.TrimSpace(value)
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		return key, value, true
+	}
+	quote := value[0]
+	if len(value) < 2 || value[len(value)-1] != quote || (quote == '"' && escapedQuote(value)) {
+		return "", "", false
+	}
+	value = value[1 : len(value)-1]
+	if quote == '"' {
+		value = unescapeDotenv(value)
+	}
+	return key, value, true
+}
+
+// escapedQuote reports whether the closing quote of a double quoted value
+// is escaped, i.e. preceded by an odd number of backslashes
+func escapedQuote(value string) bool {
+	n := 0
+	for i := len(value) - 2; i >= 1 && value[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// unescapeDotenv resolves the escapes allowed in double quoted values;
+// other backslashes are kept
+func unescapeDotenv(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case 't':
+				b.WriteByte('\t')
+				i++
+				continue
+			case '"', '\\':
+				b.WriteByte(s[i+1])
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
 func main() {
 	var (
-		serverAddr = flag.String("server", "localhost:8080", "RPC server address")
-		clientID   = flag.String("id", "", "Client ID (required)")
-		command    = flag.String("cmd", "", "Command to execute (optional, if not provided, enters interactive mode)")
-		token      = flag.String("token", "", "Auth token (required if server enforces auth)")
+		serverAddr  = flag.String("server", "localhost:8080", "RPC server address")
+		clientID    = flag.String("id", "", "Client ID (required)")
+		command     = flag.String("cmd", "", "Command to execute (optional, if not provided, enters interactive or batch mode)")
+		token       = flag.String("token", "", "Auth token (required if server enforces auth)")
 		allowUnsafe = flag.Bool("allow-unsafe", false, "Allow running without token (only if server allows)")
+		envFile     = flag.String("env-file", "", "Dotenv file to load into the session after connecting (optional)")
+		hostsFile   = flag.String("hosts", "", "Inventory file of servers to run -cmd on concurrently (fan-out mode)")
+		parallel    = flag.Int("parallel", 10, "Max hosts contacted at once in fan-out mode (0 = all)")
+		script      = flag.String("script", "", "Run commands from a local script file ('-' = stdin) in one session")
+		errexit     = flag.Bool("e", false, "Stop a script at the first failing command (like set -e)")
+		reconnects  = flag.Int("reconnect-attempts", defaultBackoff.Attempts, "Reconnect attempts after the connection drops (0 = retry forever)")
+		noStdin     = flag.Bool("n", false, "Do not send piped stdin to the -cmd command (like ssh -n)")
+		approval    = flag.Duration("approval-timeout", 0, "How long to wait for an admin when a command needs approval (0 = server's limit)")
 	)
 	flag.Parse()
 
@@ -168,56 +397,138 @@ func main() {
 		log.Println("Warning: token is empty; server may reject requests. Use --allow-unsafe to bypass this warning.")
 	}
 
+	// Fan-out mode: run one command on every host in the inventory
+	if *hostsFile != "" {
+		if *command == "" {
+			log.Fatal("-hosts requires -cmd")
+		}
+		hosts, err := loadInventory(*hostsFile)
+		if err != nil {
+			log.Fatal("Failed to load inventory:", err)
+		}
+		results := runFanout(hosts, *command, *clientID, *token, *parallel, os.Stdout)
+		fmt.Println()
+		if failed := printFanoutSummary(os.Stdout, results); failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Connect to server
 	shellClient, err := NewRemoteShellClient(*serverAddr, *clientID, *token)
 	if err != nil {
 		log.Fatal("Failed to connect:", err)
 	}
 	defer shellClient.Close()
+	shellClient.backoff.Attempts = *reconnects
+	shellClient.approvalWait = *approval
 
 	// Register client with server
-	err = shellClient.Register()
-	if err != nil {
-		log.Printf("Warning: Failed to register client: %v", err)
+	// The server only accepts calls carrying the session secret Register issues
+	if err := shellClient.Register(); err != nil {
+		log.Fatal("Failed to register client: ", err)
 	}
 
-	fmt.Printf("Connected to server %s as %s\n", *serverAddr, *clientID)
-	fmt.Println("Type 'exit' to quit, 'help' for commands")
+	// From here on every way out ends the session, so the next run with the
+	// same -id is not refused as a hijack of this one
+	exit := func(status int) {
+		shellClient.Close()
+		os.Exit(status)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		exit(130)
+	}()
+
+	if *envFile != "" {
+		n, err := shellClient.LoadEnvFile(*envFile)
+		if err != nil {
+			log.Print("Failed to load env file: ", err)
+			exit(1)
+		}
+		log.Printf("Loaded %d variables from %s", n, *envFile)
+	}
+
+	// Batch mode: a script file, or a script piped on stdin
+	interactive := *script == "" && *command == "" && stdinIsTerminal()
+	if interactive {
+		fmt.Printf("Connected to server %s as %s\n", *serverAddr, *clientID)
+		fmt.Println("Type 'exit' to quit, 'help' for commands")
+	}
 
 	// If command provided, execute and exit
 	if *command != "" {
-		resp, err := shellClient.Execute(*command)
+		// Piped input goes to the command, as with a local one
+		var stdin io.Reader
+		if !*noStdin && !stdinIsTerminal() {
+			stdin = os.Stdin
+		}
+		resp, err := shellClient.ExecuteInput(*command, stdin)
 		if err != nil {
-			log.Fatal("Error executing command:", err)
+			log.Print("Error executing command: ", err)
+			exit(1)
 		}
 
+		if resp.ApprovedBy != "" {
+			fmt.Fprintf(os.Stderr, "(approved by %s)\n", resp.ApprovedBy)
+		}
 		if resp.ExitCode != 0 {
 			fmt.Fprintf(os.Stderr, "%s\n", resp.Error)
-			os.Exit(resp.ExitCode)
+			exit(exitStatus(resp.ExitCode))
+		}
+		if err := shellClient.writeOutput(os.Stdout, resp); err != nil {
+			log.Print("Error fetching output: ", err)
+			exit(1)
 		}
-		fmt.Print(resp.Output)
 		return
 	}
 
-	// Start heartbeat goroutine to keep session alive
+	// Start heartbeat goroutine to keep session alive. Failures are reported
+	// once per outage; the connection manager prints reconnect progress.
 	go func() {
 		ticker := time.NewTicker(1 * time.Minute) // Send heartbeat every minute
 		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				if err := shellClient.SendHeartbeat(); err != nil {
-					log.Printf("Heartbeat failed: %v", err)
-				}
+		healthy := true
+		for range ticker.C {
+			err := shellClient.SendHeartbeat()
+			if err != nil && healthy {
+				log.Printf("Heartbeat failed: %v", err)
 			}
+			healthy = err == nil
 		}
 	}()
 
-	// Interactive mode
+	if !interactive {
+		in, name := io.Reader(os.Stdin), "stdin"
+		if *script != "" && *script != "-" {
+			f, err := os.Open(*script)
+			if err != nil {
+				log.Print("Failed to open script: ", err)
+				exit(1)
+			}
+			defer f.Close()
+			in, name = f, *script
+		}
+		if status := shellClient.runScript(in, name, *errexit); status != 0 {
+			exit(status)
+		}
+		return
+	}
+
+	// Interactive mode; admin broadcasts are shown as they arrive
+	shellClient.pageOutput = true
+	printer := &eventPrinter{prompt: fmt.Sprintf("[%s@remote]$ ", *clientID)}
+	go shellClient.watchEvents(printer.show)
+
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
-		fmt.Printf("[%s@remote]$ ", *clientID)
-		if !scanner.Scan() {
+		fmt.Print(printer.prompt)
+		printer.atInput.Store(true)
+		ok := scanner.Scan()
+		printer.atInput.Store(false)
+		if !ok {
 			break
 		}
 
@@ -231,61 +542,7 @@ func main() {
 			break
 		}
 
-		if line == "help" {
-			fmt.Println("Available commands:")
-			fmt.Println("  exit              - Exit the client")
-			fmt.Println("  help              - Show this help")
-			fmt.Println("  cd <dir>          - Change directory")
-			fmt.Println("  setenv <k> <v>    - Set environment variable")
-			fmt.Println("  <command>         - Execute shell command")
-			continue
-		}
-
-		// Handle cd command
-		if strings.HasPrefix(line, "cd ") {
-			dir := strings.TrimSpace(line[3:])
-			err := shellClient.ChangeDir(dir)
-			if err != nil {
-				fmt.Printf("Error: %v\n", err)
-			} else {
-				fmt.Println("Directory changed")
-			}
-			continue
-		}
-
-		// Handle setenv command
-		if strings.HasPrefix(line, "setenv ") {
-			parts := strings.Fields(line[7:])
-			if len(parts) != 2 {
-				fmt.Println("Usage: setenv <key> <value>")
-				continue
-			}
-			err := shellClient.SetEnv(parts[0], parts[1])
-			if err != nil {
-				fmt.Printf("Error: %v\n", err)
-			} else {
-				fmt.Printf("Set %s=%s\n", parts[0], parts[1])
-			}
-			continue
-		}
-
-		// Execute command
-		resp, err := shellClient.Execute(line)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			continue
-		}
-
-		if resp.ExitCode != 0 {
-			fmt.Fprintf(os.Stderr, "Exit code: %d\n", resp.ExitCode)
-			if resp.Error != "" {
-				fmt.Fprintf(os.Stderr, "%s\n", resp.Error)
-			}
-		}
-
-		if resp.Output != "" {
-			fmt.Print(resp.Output)
-		}
+		shellClient.runLine(line)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -294,5 +551,3 @@ func main() {
 
 	fmt.Println("Goodbye!")
 }
-
-
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHistoryRef(t *testing.T) {
+	tests := []struct {
+		line, ref, rest string
+		ok              bool
+	}{
+		{"!!", "!", "", true},
+		{"!12", "12", "", true},
+		{"!ls", "ls", "", true},
+		{"!git --no-pager", "git", " --no-pager", true},
+		{"! grep foo file", "", "", false},
+		{"!=", "", "", false},
+		{"!(x)", "", "", false},
+		{"!", "", "", false},
+		{"echo !!", "", "", false},
+	}
+	for _, tt := range tests {
+		ref, rest, ok := historyRef(tt.line)
+		if ref != tt.ref || rest != tt.rest || ok != tt.ok {
+			t.Errorf("historyRef(%q) = %q, %q, %v; want %q, %q, %v", tt.line, ref, rest, ok, tt.ref, tt.rest, tt.ok)
+		}
+	}
+}
+
+func TestParseDotenv(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     [][2]string
+		err      string
+	}{
+		{"plain", "A=1\nB=two words\n", [][2]string{{"A", "1"}, {"B", "two words"}}, ""},
+		{"empty value", "A=\nB=''\n", [][2]string{{"A", ""}, {"B", ""}}, ""},
+		{"spaces around", "  A = 1  \n", [][2]string{{"A", "1"}}, ""},
+		{"value with =", "URL=postgres://h/db?sslmode=off\n", [][2]string{{"URL", "postgres://h/db?sslmode=off"}}, ""},
+		{"comments and blanks", "# header\n\nA=1\n   # indented\n", [][2]string{{"A", "1"}}, ""},
+		{"hash in value", "A=x#y\n", [][2]string{{"A", "x#y"}}, ""},
+		{"export prefix", "export A=1\nexport   B=2\n", [][2]string{{"A", "1"}, {"B", "2"}}, ""},
+		{"double quotes", `A="  padded  "`, [][2]string{{"A", "  padded  "}}, ""},
+		{"single quotes", `A='it is $HOME'`, [][2]string{{"A", "it is $HOME"}}, ""},
+		{"mismatched quotes", `A="x'`, nil, "line 1"},
+		{"double quote escapes", `A="line1\nline2\t\"q\" \\ \d"`, [][2]string{{"A", "line1\nline2\t\"q\" \\ \\d"}}, ""},
+		{"single quotes are literal", `A='a\nb'`, [][2]string{{"A", `a\nb`}}, ""},
+		{"unquoted backslash", `A=C:\temp`, [][2]string{{"A", `C:\temp`}}, ""},
+		{"escaped closing quote", `A="abc\"`, nil, "line 1"},
+		{"escaped backslash before quote", `A="abc\\"`, [][2]string{{"A", `abc\`}}, ""},
+		{"lone quote", `A="`, nil, "line 1"},
+		{"no equals", "A=1\nJUSTAKEY\n", nil, "line 2"},
+		{"no key", "=1\n", nil, "line 1"},
+		{"space in key", "MY KEY=1\n", nil, "line 1"},
+		{"bare export", "export\n", nil, "line 1"},
+	}
+	for _, tt := range tests {
+		got, err := parseDotenv(strings.NewReader(tt.in))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: parseDotenv(%q) = %q, %v; want an error on %s", tt.name, tt.in, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseDotenv(%q) = %q, %v; want %q", tt.name, tt.in, got, err, tt.want)
+		}
+	}
+}
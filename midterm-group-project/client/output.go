@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"remote-shell-rpc/protocol"
+)
+
+// morePageSize is how much more output the more built-in shows at a time
+const morePageSize = 256 * 1024
+
+// cutOutput is the part of a command's output the server kept back
+type cutOutput struct {
+	handle string
+	shown  int64 // Bytes printed so far
+	size   int64 // Bytes the command wrote
+}
+
+// FetchOutput reads a chunk of a command's full output from offset
+func (c *RemoteShellClient) FetchOutput(handle string, offset int64, length int) (protocol.OutputChunk, error) {
+	resp, err := c.rpc.FetchOutput(protocol.FetchOutputRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret(), Handle: handle, Offset: offset, Length: length})
+	if err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("%s", resp.Error)
+	}
+	return resp, nil
+}
+
+// copyOutput writes a command's spooled output to w from offset on, at most
+// limit bytes (0 = all of it), and returns the offset it got to
+func (c *RemoteShellClient) copyOutput(w io.Writer, handle string, offset, limit int64) (int64, error) {
+	start := offset
+	for limit == 0 || offset-start < limit {
+		length := protocol.MaxChunkSize
+		if limit > 0 && int64(length) > limit-(offset-start) {
+			length = int(limit - (offset - start))
+		}
+		chunk, err := c.FetchOutput(handle, offset, length)
+		if err != nil {
+			return offset, err
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return offset, err
+		}
+		offset += int64(len(chunk.Data))
+		if chunk.EOF || len(chunk.Data) == 0 {
+			break
+		}
+	}
+	return offset, nil
+}
+
+// writeOutput writes a command's whole output to w, fetching what did not
+// fit in the response
+func (c *RemoteShellClient) writeOutput(w io.Writer, resp *protocol.CommandResponse) error {
+	if _, err := io.WriteString(w, resp.Output); err != nil {
+		return err
+	}
+	if resp.OutputHandle == "" {
+		return nil
+	}
+	n, err := c.copyOutput(w, resp.OutputHandle, int64(len(resp.Output)), 0)
+	if err != nil {
+		return err
+	}
+	if n < resp.OutputSize {
+		return fmt.Errorf("output cut at %d of %d bytes by the server's spool limit", n, resp.OutputSize)
+	}
+	return nil
+}
+
+// writeLocalFile creates path and fills it with fill
+func writeLocalFile(path string, fill func(w io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = fill(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// splitLocalRedirect splits "command <op> file" into the command and the
+// local file, for the client's own redirects (op is " => " or " <= ")
+func splitLocalRedirect(line, op string) (string, string) {
+	i := strings.LastIndex(line, op)
+	if i < 0 {
+		return line, ""
+	}
+	command, path := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+len(op):])
+	if command == "" || path == "" {
+		return line, ""
+	}
+	return command, path
+}
+
+// showMore prints the next page of the last command's cut output
+func (c *RemoteShellClient) showMore() int {
+	if c.cut.handle == "" || c.cut.shown >= c.cut.size {
+		fmt.Println("No more output")
+		return 1
+	}
+	n, err := c.copyOutput(os.Stdout, c.cut.handle, c.cut.shown, morePageSize)
+	if n == c.cut.shown && err == nil {
+		err = fmt.Errorf("output cut at %d of %d bytes by the server's spool limit", n, c.cut.size)
+	}
+	c.cut.shown = n
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+		c.cut.handle = ""
+		return 1
+	}
+	c.printCutNotice()
+	return 0
+}
+
+// printCutNotice tells how much of the last command's output is left
+func (c *RemoteShellClient) printCutNotice() {
+	if c.cut.shown < c.cut.size {
+		fmt.Fprintf(os.Stderr, "\n-- %s of %s shown; 'more' shows the next part, 'save <file>' saves all of it --\n",
+			formatBytes(c.cut.shown), formatBytes(c.cut.size))
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// stdinIsTerminal reports whether stdin is an interactive terminal
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func printHelp() {
+	fmt.Println("Available commands:")
+	fmt.Println("  exit              - Exit the client")
+	fmt.Println("  help              - Show this help")
+	fmt.Println("  cd <dir>          - Change directory")
+	fmt.Println("  setenv [-s] <k> [v] - Set environment variable (empty if v omitted, -s = secret)")
+	fmt.Println("  export [-s] <k>=<v> - Set environment variable (-s = secret)")
+	fmt.Println("  secret <k>        - Set a secret variable, typing the value without echo")
+	fmt.Println("  unset <k>         - Remove environment variable")
+	fmt.Println("  env [k]           - Show session environment (or one variable)")
+	fmt.Println("  source <file>     - Load variables from a local dotenv file")
+	fmt.Println("  put <local> [remote] - Upload a file to the session directory")
+	fmt.Println("  get <remote> [local] - Download a file from the session directory")
+	fmt.Println("  history [n]       - Show the last n commands run in this session")
+	fmt.Println("  !n / !! / !prefix - Re-run command n from history / the last command / the last one starting with prefix")
+	fmt.Println("  info              - Show this session as the server sees it")
+	fmt.Println("  <command> => <file> - Save a command's full output to a local file")
+	fmt.Println("  <command> <= <file> - Send a local file to a command as its input")
+	fmt.Println("  more              - Show more of the last command's output if it was cut")
+	fmt.Println("  save <file>       - Save all of the last command's output if it was cut")
+	fmt.Println("  <command>         - Execute shell command")
+}
+
+// setEnv sets a session variable for the setenv, export and secret
+// built-ins; a secret value is not printed
+func (c *RemoteShellClient) setEnv(key, value string, sensitive bool) int {
+	secret, err := c.SetEnv(key, value, sensitive)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if secret {
+		fmt.Printf("Set %s (secret)\n", key)
+	} else {
+		fmt.Printf("Set %s=%s\n", key, value)
+	}
+	return 0
+}
+
+// failedStatus is the exit status for a command that has no exit code of
+// its own: the server reports -1 when it timed out, was killed or could not
+// start, which os.Exit would turn into 255
+const failedStatus = 1
+
+// exitStatus maps a command's exit code to the status to exit with
+func exitStatus(code int) int {
+	if code < 0 {
+		return failedStatus
+	}
+	return code
+}
+
+// runLine handles one input line: a client built-in or a remote command.
+// It returns the exit status of the line (0 on success) the same way a
+// shell would, so callers can stop on errors.
+func (c *RemoteShellClient) runLine(line string) int {
+	if line == "help" {
+		printHelp()
+		return 0
+	}
+
+	// Resolve history references before anything else
+	if ref, rest, ok := historyRef(line); ok {
+		cmdLine, err := c.lookupHistory(ref)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		line = cmdLine + rest
+		fmt.Println(line)
+	}
+
+	// Handle history command
+	if line == "history" || strings.HasPrefix(line, "history ") {
+		limit := 0
+		if arg := strings.TrimSpace(strings.TrimPrefix(line, "history")); arg != "" {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 {
+				fmt.Println("Usage: history [n]")
+				return 2
+			}
+			limit = n
+		}
+		entries, err := c.History(limit)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		for _, e := range entries {
+			fmt.Printf("%5d  %s  exit=%-3d %8s  %s\n",
+				e.Seq, e.StartedAt.Format("15:04:05"), e.ExitCode, e.Duration.Round(time.Millisecond), e.Command)
+		}
+		return 0
+	}
+
+	// Handle info command
+	if line == "info" {
+		info, err := c.SessionInfo()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Session:     %s\n", info.ID)
+		fmt.Printf("Work dir:    %s\n", info.WorkDir)
+		fmt.Printf("Env vars:    %d\n", info.EnvCount)
+		fmt.Printf("Started:     %s\n", info.ConnectedAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("Commands:    %d run, %d running\n", info.Commands, info.Running)
+		for _, conn := range info.Connections {
+			fmt.Printf("Connection:  %s (%s), in=%s out=%s\n",
+				conn.RemoteAddr, conn.TLSIdentity, formatBytes(conn.BytesIn), formatBytes(conn.BytesOut))
+		}
+		return 0
+	}
+
+	// Handle put command
+	if strings.HasPrefix(line, "put ") {
+		local, remote, ok := transferArgs(line[4:])
+		if !ok {
+			fmt.Println("Usage: put <local> [remote]")
+			return 2
+		}
+		dest, err := c.Upload(local, remote, transferProgress("put "+local))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Uploaded %s to %s (checksum verified)\n", local, dest)
+		return 0
+	}
+
+	// Handle get command
+	if strings.HasPrefix(line, "get ") {
+		remote, local, ok := transferArgs(line[4:])
+		if !ok {
+			fmt.Println("Usage: get <remote> [local]")
+			return 2
+		}
+		if info, err := os.Stat(local); err == nil && info.IsDir() {
+			local = filepath.Join(local, filepath.Base(remote))
+		}
+		n, err := c.Download(remote, local, transferProgress("get "+remote))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Downloaded %s to %s (%s, checksum verified)\n", remote, local, formatBytes(n))
+		return 0
+	}
+
+	// Handle cd command
+	if strings.HasPrefix(line, "cd ") {
+		dir := strings.TrimSpace(line[3:])
+		if err := c.ChangeDir(dir); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		fmt.Println("Directory changed")
+		return 0
+	}
+
+	// Handle setenv command
+	if strings.HasPrefix(line, "setenv ") {
+		parts := strings.Fields(line[7:])
+		sensitive := len(parts) > 0 && parts[0] == "-s"
+		if sensitive {
+			parts = parts[1:]
+		}
+		if len(parts) < 1 || len(parts) > 2 {
+			fmt.Println("Usage: setenv [-s] <key> [value]")
+			return 2
+		}
+		value := ""
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+		return c.setEnv(parts[0], value, sensitive)
+	}
+
+	// Handle export command
+	if strings.HasPrefix(line, "export ") {
+		arg := strings.TrimSpace(line[7:])
+		sensitive := strings.HasPrefix(arg, "-s ")
+		if sensitive {
+			arg = strings.TrimSpace(arg[3:])
+		}
+		key, value, ok := parseAssignment(arg)
+		if !ok {
+			fmt.Println("Usage: export [-s] <key>=<value>")
+			return 2
+		}
+		return c.setEnv(key, value, sensitive)
+	}
+
+	// Handle secret command
+	if strings.HasPrefix(line, "secret ") {
+		key := strings.TrimSpace(line[7:])
+		if key == "" || strings.ContainsAny(key, " \t") {
+			fmt.Println("Usage: secret <key>")
+			return 2
+		}
+		if !stdinIsTerminal() {
+			fmt.Println("Error: secret reads the value from a terminal; use setenv -s in scripts")
+			return 1
+		}
+		fmt.Printf("Value for %s: ", key)
+		value, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		return c.setEnv(key, string(value), true)
+	}
+
+	// Handle unset command
+	if strings.HasPrefix(line, "unset ") {
+		code := 0
+		for _, key := range strings.Fields(line[6:]) {
+			if err := c.UnsetEnv(key); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				code = 1
+			}
+		}
+		return code
+	}
+
+	// Handle env command
+	if line == "env" || strings.HasPrefix(line, "env ") {
+		key := strings.TrimSpace(strings.TrimPrefix(line, "env"))
+		if key != "" {
+			value, ok, err := c.GetEnv(key)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return 1
+			}
+			if !ok {
+				fmt.Printf("%s is not set\n", key)
+				return 1
+			}
+			fmt.Printf("%s=%s\n", key, value)
+			return 0
+		}
+		vars, err := c.ListEnv()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		names := make([]string, 0, len(vars))
+		for k := range vars {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			fmt.Printf("%s=%s\n", k, vars[k])
+		}
+		return 0
+	}
+
+	// Handle source command
+	if strings.HasPrefix(line, "source ") {
+		path := strings.TrimSpace(line[7:])
+		n, err := c.LoadEnvFile(path)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Loaded %d variables from %s\n", n, path)
+		return 0
+	}
+
+	// Handle more and save, for the output of the last command that was cut
+	if line == "more" {
+		return c.showMore()
+	}
+	if strings.HasPrefix(line, "save ") {
+		path := strings.TrimSpace(line[5:])
+		if c.cut.handle == "" {
+			fmt.Println("No cut output to save; use <command> => <file> to save a command's output")
+			return 1
+		}
+		var n int64
+		err := writeLocalFile(path, func(w io.Writer) (err error) {
+			n, err = c.copyOutput(w, c.cut.handle, 0, 0)
+			return err
+		})
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Saved %s to %s\n", formatBytes(n), path)
+		return 0
+	}
+
+	// Execute command; "command => file" saves its output to a local file
+	// and "command <= file" feeds it a local file as input
+	command, saveTo := splitLocalRedirect(line, " => ")
+	command, readFrom := splitLocalRedirect(command, " <= ")
+	var stdin io.Reader
+	if readFrom != "" {
+		f, err := os.Open(readFrom)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		stdin = f
+	}
+	resp, err := c.ExecuteInput(command, stdin)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	c.cut = cutOutput{}
+
+	if resp.ExitCode != 0 {
+		fmt.Fprintf(os.Stderr, "Exit code: %d\n", resp.ExitCode)
+		if resp.Error != "" {
+			fmt.Fprintf(os.Stderr, "%s\n", resp.Error)
+		}
+	}
+
+	if resp.Replayed {
+		fmt.Fprintln(os.Stderr, "(command had already run before the connection dropped; showing its result)")
+	}
+	if resp.ApprovedBy != "" {
+		fmt.Fprintf(os.Stderr, "(approved by %s)\n", resp.ApprovedBy)
+	}
+	switch {
+	case saveTo != "":
+		if err := writeLocalFile(saveTo, func(w io.Writer) error { return c.writeOutput(w, resp) }); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Saved output to %s\n", saveTo)
+	case resp.OutputHandle != "" && c.pageOutput:
+		// Show the first part; more and save fetch the rest
+		fmt.Print(resp.Output)
+		c.cut = cutOutput{handle: resp.OutputHandle, shown: int64(len(resp.Output)), size: resp.OutputSize}
+		c.printCutNotice()
+	default:
+		if err := c.writeOutput(os.Stdout, resp); err != nil {
+			fmt.Printf("\nError: %v\n", err)
+			return 1
+		}
+	}
+	return exitStatus(resp.ExitCode)
+}
+
+// runScript executes a script line by line in the current session and
+// returns the exit status to finish with: the failing command's status when
+// errexit is on (flag -e or a "set -e" line), otherwise the last status.
+// Blank lines and # comments are skipped; "exit [n]" ends the script.
+func (c *RemoteShellClient) runScript(r io.Reader, name string, errexit bool) int {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	status := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		switch {
+		case line == "set -e":
+			errexit = true
+			continue
+		case line == "set +e":
+			errexit = false
+			continue
+		case line == "exit":
+			return status
+		case strings.HasPrefix(line, "exit "):
+			n, err := strconv.Atoi(strings.TrimSpace(line[5:]))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s:%d: exit: numeric argument required\n", name, lineNo)
+				return 2
+			}
+			return n
+		}
+
+		status = c.runLine(line)
+		if status != 0 && errexit {
+			fmt.Fprintf(os.Stderr, "%s:%d: %q failed with exit code %d, stopping\n", name, lineNo, line, status)
+			return status
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: read error: %v\n", name, err)
+		return 1
+	}
+	return status
+}
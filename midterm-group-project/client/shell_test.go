@@ -0,0 +1,11 @@
+package main
+
+import "testing"
+
+func TestExitStatus(t *testing.T) {
+	for code, want := range map[int]int{0: 0, 1: 1, 2: 2, 127: 127, 255: 255, -1: failedStatus, -9: failedStatus} {
+		if got := exitStatus(code); got != want {
+			t.Errorf("exitStatus(%d) = %d, want %d", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"remote-shell-rpc/protocol"
+)
+
+const transferChunkSize = 256 * 1024
+
+// UploadStdin sends a command's input to the server in chunks and returns
+// the handle to run the command with
+func (c *RemoteShellClient) UploadStdin(r io.Reader) (string, error) {
+	buf := make([]byte, transferChunkSize)
+	var handle string
+	var offset int64
+	for {
+		n, rerr := io.ReadFull(r, buf)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return "", rerr
+		}
+		if n > 0 || handle == "" {
+			resp, err := c.rpc.UploadStdin(protocol.StdinChunk{ID: c.id, Token: c.token, Secret: c.sessionSecret(), Handle: handle, Offset: offset, Data: buf[:n]})
+			if err != nil {
+				return "", err
+			}
+			if resp.Error != "" {
+				return "", fmt.Errorf("%s", resp.Error)
+			}
+			handle, offset = resp.Handle, resp.Written
+		}
+		if rerr != nil {
+			return handle, nil
+		}
+	}
+}
+
+// Upload copies a local file to remotePath (relative to the session WorkDir).
+// progress, if not nil, is called after every chunk.
+func (c *RemoteShellClient) Upload(localPath, remotePath string, progress func(done, total int64)) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", localPath)
+	}
+	sum, err := hashReader(f)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	total := info.Size()
+	buf := make([]byte, transferChunkSize)
+	var offset int64
+	for {
+		n, rerr := io.ReadFull(f, buf)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return "", rerr
+		}
+		final := offset+int64(n) >= total
+		req := protocol.UploadRequest{
+			ID:     c.id,
+			Token:  c.token,
+			Secret: c.sessionSecret(),
+			Path:   remotePath,
+			Offset: offset,
+			Data:   buf[:n],
+			Final:  final,
+			Mode:   info.Mode().Perm(),
+		}
+		if final {
+			req.Checksum = sum
+		}
+		resp, err := c.rpc.Upload(req)
+		if err != nil {
+			return "", err
+		}
+		if resp.Error != "" {
+			return "", fmt.Errorf("%s", resp.Error)
+		}
+		offset = resp.Written
+		if progress != nil {
+			progress(offset, total)
+		}
+		if final {
+			return resp.Path, nil
+		}
+	}
+}
+
+// Download copies remotePath (relative to the session WorkDir) to a local file
+// and verifies its SHA-256 checksum. progress, if not nil, is called after every chunk.
+func (c *RemoteShellClient) Download(remotePath, localPath string, progress func(done, total int64)) (int64, error) {
+	tmp := localPath + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	ok := false
+	defer func() {
+		f.Close()
+		if !ok {
+			os.Remove(tmp)
+		}
+	}()
+
+	h := sha256.New()
+	var offset int64
+	var expected string
+	var mode os.FileMode
+	for {
+		req := protocol.DownloadRequest{ID: c.id, Token: c.token, Secret: c.sessionSecret(), Path: remotePath, Offset: offset, Length: transferChunkSize}
+		resp, err := c.rpc.Download(req)
+		if err != nil {
+			return offset, err
+		}
+		if resp.Error != "" {
+			return offset, fmt.Errorf("%s", resp.Error)
+		}
+		if offset == 0 {
+			expected = resp.Checksum
+			mode = resp.Mode
+		}
+		if _, err := f.Write(resp.Data); err != nil {
+			return offset, err
+		}
+		h.Write(resp.Data)
+		offset += int64(len(resp.Data))
+		if progress != nil {
+			progress(offset, resp.Size)
+		}
+		if resp.EOF {
+			break
+		}
+		if len(resp.Data) == 0 {
+			return offset, fmt.Errorf("file shrank during download")
+		}
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, expected) {
+		return offset, fmt.Errorf("checksum mismatch: got %s, expected %s", got, expected)
+	}
+	if err := f.Close(); err != nil {
+		return offset, err
+	}
+	if mode != 0 {
+		os.Chmod(tmp, mode)
+	}
+	if err := os.Rename(tmp, localPath); err != nil {
+		return offset, err
+	}
+	ok = true
+	return offset, nil
+}
+
+// transferProgress returns a progress callback that redraws one status line on stderr
+func transferProgress(label string) func(done, total int64) {
+	return func(done, total int64) {
+		pct := 100.0
+		if total > 0 {
+			pct = float64(done) * 100 / float64(total)
+		}
+		fmt.Fprintf(os.Stderr, "\r%s: %5.1f%% (%s / %s)", label, pct, formatBytes(done), formatBytes(total))
+		if done >= total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+// transferArgs splits "put|get <src> [dst]", defaulting dst to the base name of src
+func transferArgs(args string) (string, string, bool) {
+	parts := strings.Fields(args)
+	switch len(parts) {
+	case 1:
+		return parts[0], filepath.Base(parts[0]), true
+	case 2:
+		return parts[0], parts[1], true
+	}
+	return "", "", false
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -2,6 +2,17 @@ module remote-shell-rpc
 
 go 1.21
 
+require (
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/crypto v0.24.0
+	golang.org/x/term v0.21.0
+	google.golang.org/grpc v1.66.2
+	google.golang.org/protobuf v1.34.2
+)
 
-
-
+require (
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240604185151-ef581f913117 // indirect
+)
@@ -0,0 +1,115 @@
+package protocol
+
+import "time"
+
+// ListRequest for listing clients
+type ListRequest struct {
+	Token string
+}
+
+type ListSessionsRequest struct {
+	Token string
+}
+
+type KillSessionRequest struct {
+	ID    string
+	Token string
+}
+
+// UpdateWhitelistRequest for dynamic whitelist changes
+type UpdateWhitelistRequest struct {
+	Token    string
+	Commands []string
+}
+
+// BanRequest bans client IDs, or lifts their ban if Lift is set
+type BanRequest struct {
+	Token string
+	IDs   []string
+	Lift  bool
+}
+
+// JobInfo describes a command that is currently running
+type JobInfo struct {
+	JobID     int64
+	ClientID  string
+	Command   string
+	WorkDir   string
+	StartedAt time.Time
+}
+
+// AuditEntry is one record of the audit trail: who did what to which
+// session, and how it turned out
+type AuditEntry struct {
+	Seq      int64
+	Time     time.Time
+	Identity string // Who made the request, derived from the token
+	ClientID string
+	Action   string // register, exec, kill, ban, unban, whitelist-add, whitelist-rm, approve, reject, ...
+	Detail   string
+	Outcome  string
+}
+
+// AuditRequest asks for the most recent audit entries, optionally for one client
+type AuditRequest struct {
+	Token    string
+	ClientID string
+	Limit    int
+}
+
+// TopSnapshot is everything admin top shows, gathered in one call
+type TopSnapshot struct {
+	Time        time.Time
+	Sessions    int
+	Connections int
+	Jobs        []JobInfo         // Running commands, oldest first
+	Clients     []ClientActivity  // Busiest first
+	Denials     []AuditEntry      // Most recent rejected requests, newest last
+	DenialTotal map[string]uint64 // All denials since start, by reason
+}
+
+// ClientActivity summarises what one client is doing right now
+type ClientActivity struct {
+	ClientID        string
+	RequestsLastMin int
+	Running         int
+	Connected       bool
+	RemoteAddr      string
+	LastActive      time.Time
+}
+
+// PendingApproval is a command parked until an admin approves or rejects it
+type PendingApproval struct {
+	ApprovalID  int64
+	ClientID    string
+	Requester   string // Identity of the session's caller
+	Command     string
+	WorkDir     string
+	RequestedAt time.Time
+	Expires     time.Time // Rejected automatically after this
+}
+
+// ApprovalDecision approves or rejects a pending command. Reason is shown to
+// the client on rejection.
+type ApprovalDecision struct {
+	Token      string
+	ApprovalID int64
+	Reason     string
+}
+
+// RecordingRequest asks for a chunk of the newest recording of a session
+type RecordingRequest struct {
+	Token  string
+	ID     string
+	Offset int64
+	Length int // 0 = MaxChunkSize
+}
+
+// RecordingChunk is part of an asciicast v2 recording. Recordings of live
+// sessions grow; Size is the size when the chunk was read.
+type RecordingChunk struct {
+	Name string // File name on the server
+	Data []byte
+	Size int64
+	EOF  bool
+}
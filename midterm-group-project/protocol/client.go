@@ -0,0 +1,303 @@
+package protocol
+
+import (
+	"io"
+	"net/rpc"
+)
+
+// Caller issues one RPC. *rpc.Client is a Caller, and so is anything that
+// adds reconnects and retries on top of one.
+type Caller interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+}
+
+// CallerFunc adapts a function to Caller
+type CallerFunc func(serviceMethod string, args interface{}, reply interface{}) error
+
+func (f CallerFunc) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return f(serviceMethod, args, reply)
+}
+
+// Client calls RemoteShellService with typed requests and replies.
+// Server-side failures come back either as the error or, for the older
+// RPCs, in the reply's Error field or text; both are passed through as is.
+type Client struct {
+	caller Caller
+	closer io.Closer
+}
+
+// NewClient wraps c. It does not perform the handshake.
+func NewClient(c Caller) *Client {
+	closer, _ := c.(io.Closer)
+	return &Client{caller: c, closer: closer}
+}
+
+// Dial connects to a server over TCP and performs the version handshake.
+// program names this binary in the server log.
+func Dial(addr, program string) (*Client, error) {
+	rc, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := NewClient(rc)
+	if _, err := c.Hello(program); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the underlying connection if the Caller has one
+func (c *Client) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+func (c *Client) call(method string, args interface{}, reply interface{}) error {
+	return c.caller.Call(ServiceName+"."+method, args, reply)
+}
+
+// Hello performs the version handshake and returns the server's side of it.
+// A server without Hello predates the handshake; it speaks version 0, which
+// this package no longer supports. The error is a *VersionError if the two
+// sides cannot talk.
+func (c *Client) Hello(program string) (HelloResponse, error) {
+	var resp HelloResponse
+	err := c.call("Hello", HelloRequest{Version: Version, MinVersion: MinVersion, Program: program}, &resp)
+	if IsMissingMethod(err) {
+		return HelloResponse{}, &VersionError{Local: program, Peer: "server", Version: 0, MinVersion: 0}
+	}
+	if err != nil {
+		return resp, err
+	}
+	return resp, Compatible(program, "server", resp.Version, resp.MinVersion)
+}
+
+// Session RPCs
+
+func (c *Client) Register(req RegisterRequest) (RegisterResponse, error) {
+	var resp RegisterResponse
+	err := c.call("Register", req, &resp)
+	return resp, err
+}
+
+// Heartbeat replies "OK" or "Error: ..."; see ReplyError
+func (c *Client) Heartbeat(req HeartbeatRequest) (string, error) {
+	var resp string
+	err := c.call("Heartbeat", req, &resp)
+	return resp, err
+}
+
+// EndSession replies "OK" or "Error: ..."; see ReplyError
+func (c *Client) EndSession(req EndSessionRequest) (string, error) {
+	var resp string
+	err := c.call("EndSession", req, &resp)
+	return resp, err
+}
+
+func (c *Client) Execute(req CommandRequest) (CommandResponse, error) {
+	var resp CommandResponse
+	err := c.call("Execute", req, &resp)
+	return resp, err
+}
+
+// SetEnv replies with a confirmation or "Error: ..."; see ReplyError
+func (c *Client) SetEnv(req EnvRequest) (string, error) {
+	var resp string
+	err := c.call("SetEnv", req, &resp)
+	return resp, err
+}
+
+// UnsetEnv replies with a confirmation or "Error: ..."; see ReplyError
+func (c *Client) UnsetEnv(req EnvKeyRequest) (string, error) {
+	var resp string
+	err := c.call("UnsetEnv", req, &resp)
+	return resp, err
+}
+
+func (c *Client) GetEnv(req EnvKeyRequest) (EnvValueResponse, error) {
+	var resp EnvValueResponse
+	err := c.call("GetEnv", req, &resp)
+	return resp, err
+}
+
+func (c *Client) ListEnv(req ListEnvRequest) (map[string]string, error) {
+	var resp map[string]string
+	err := c.call("ListEnv", req, &resp)
+	return resp, err
+}
+
+// ChangeDir replies with a confirmation or "Error: ..."; see ReplyError
+func (c *Client) ChangeDir(req DirRequest) (string, error) {
+	var resp string
+	err := c.call("ChangeDir", req, &resp)
+	return resp, err
+}
+
+// History returns a session's commands, to its owner or an admin
+func (c *Client) History(req HistoryRequest) ([]HistoryEntry, error) {
+	var resp []HistoryEntry
+	err := c.call("History", req, &resp)
+	return resp, err
+}
+
+func (c *Client) Upload(req UploadRequest) (UploadResponse, error) {
+	var resp UploadResponse
+	err := c.call("Upload", req, &resp)
+	return resp, err
+}
+
+func (c *Client) Download(req DownloadRequest) (DownloadResponse, error) {
+	var resp DownloadResponse
+	err := c.call("Download", req, &resp)
+	return resp, err
+}
+
+func (c *Client) UploadStdin(req StdinChunk) (StdinUploadResponse, error) {
+	var resp StdinUploadResponse
+	err := c.call("UploadStdin", req, &resp)
+	return resp, err
+}
+
+func (c *Client) FetchOutput(req FetchOutputRequest) (OutputChunk, error) {
+	var resp OutputChunk
+	err := c.call("FetchOutput", req, &resp)
+	return resp, err
+}
+
+func (c *Client) PollEvents(req PollRequest) (PollResponse, error) {
+	var resp PollResponse
+	err := c.call("PollEvents", req, &resp)
+	return resp, err
+}
+
+// SessionInfo describes one session, to its owner or an admin
+func (c *Client) SessionInfo(req SessionInfoRequest) (SessionInfo, error) {
+	var resp SessionInfo
+	err := c.call("GetSessionInfoV2", req, &resp)
+	return resp, err
+}
+
+// Admin RPCs
+
+func (c *Client) ListClients(req ListRequest) ([]string, error) {
+	var resp []string
+	err := c.call("ListClients", req, &resp)
+	return resp, err
+}
+
+func (c *Client) ListSessions(req ListSessionsRequest) (SessionList, error) {
+	var resp SessionList
+	err := c.call("ListSessionsV2", req, &resp)
+	return resp, err
+}
+
+// ListSessionsLegacy returns sessions as untyped maps, for servers that
+// predate ListSessionsV2
+func (c *Client) ListSessionsLegacy(req ListSessionsRequest) ([]map[string]interface{}, error) {
+	var resp []map[string]interface{}
+	err := c.call("ListSessions", req, &resp)
+	return resp, err
+}
+
+// KillSession replies "killed ..." on success; refusals such as
+// "unauthorized" or "not found" also come back as the reply
+func (c *Client) KillSession(req KillSessionRequest) (string, error) {
+	var resp string
+	err := c.call("KillSession", req, &resp)
+	return resp, err
+}
+
+// AddToWhitelist returns the whitelist after the change
+func (c *Client) AddToWhitelist(req UpdateWhitelistRequest) ([]string, error) {
+	var resp []string
+	err := c.call("AddToWhitelist", req, &resp)
+	return resp, err
+}
+
+// RemoveFromWhitelist returns the whitelist after the change
+func (c *Client) RemoveFromWhitelist(req UpdateWhitelistRequest) ([]string, error) {
+	var resp []string
+	err := c.call("RemoveFromWhitelist", req, &resp)
+	return resp, err
+}
+
+func (c *Client) ListWhitelist(req ListRequest) ([]string, error) {
+	var resp []string
+	err := c.call("ListWhitelist", req, &resp)
+	return resp, err
+}
+
+// BanClient returns the banned client IDs after the change
+func (c *Client) BanClient(req BanRequest) ([]string, error) {
+	var resp []string
+	err := c.call("BanClient", req, &resp)
+	return resp, err
+}
+
+func (c *Client) ListBanned(req ListRequest) ([]string, error) {
+	var resp []string
+	err := c.call("ListBanned", req, &resp)
+	return resp, err
+}
+
+func (c *Client) AuditLog(req AuditRequest) ([]AuditEntry, error) {
+	var resp []AuditEntry
+	err := c.call("AuditLog", req, &resp)
+	return resp, err
+}
+
+func (c *Client) ListJobs(req ListRequest) ([]JobInfo, error) {
+	var resp []JobInfo
+	err := c.call("ListJobs", req, &resp)
+	return resp, err
+}
+
+// ListApprovals returns the commands waiting for approval, oldest first
+func (c *Client) ListApprovals(req ListRequest) ([]PendingApproval, error) {
+	var resp []PendingApproval
+	err := c.call("ListApprovals", req, &resp)
+	return resp, err
+}
+
+// ApproveCommand lets a pending command run and returns it
+func (c *Client) ApproveCommand(req ApprovalDecision) (PendingApproval, error) {
+	var resp PendingApproval
+	err := c.call("ApproveCommand", req, &resp)
+	return resp, err
+}
+
+// RejectCommand refuses a pending command and returns it
+func (c *Client) RejectCommand(req ApprovalDecision) (PendingApproval, error) {
+	var resp PendingApproval
+	err := c.call("RejectCommand", req, &resp)
+	return resp, err
+}
+
+func (c *Client) ReadRecording(req RecordingRequest) (RecordingChunk, error) {
+	var resp RecordingChunk
+	err := c.call("ReadRecording", req, &resp)
+	return resp, err
+}
+
+func (c *Client) Top(req ListRequest) (TopSnapshot, error) {
+	var resp TopSnapshot
+	err := c.call("Top", req, &resp)
+	return resp, err
+}
+
+// WatchSession long-polls another session's commands and output
+func (c *Client) WatchSession(req PollRequest) (PollResponse, error) {
+	var resp PollResponse
+	err := c.call("WatchSession", req, &resp)
+	return resp, err
+}
+
+// Broadcast returns the number of sessions the message was sent to
+func (c *Client) Broadcast(req BroadcastRequest) (int, error) {
+	var resp int
+	err := c.call("Broadcast", req, &resp)
+	return resp, err
+}
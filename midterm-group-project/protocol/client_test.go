@@ -0,0 +1,39 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestHelloRefusesServerWithoutHandshake(t *testing.T) {
+	old := CallerFunc(func(method string, args, reply interface{}) error {
+		return fmt.Errorf("rpc: can't find method %s", method)
+	})
+	_, err := NewClient(old).Hello("client")
+	var verr *VersionError
+	if !errors.As(err, &verr) || verr.Version != 0 {
+		t.Fatalf("Hello against a server without it = %v, want a version 0 *VersionError", err)
+	}
+}
+
+func TestHelloChecksServerVersion(t *testing.T) {
+	server := func(version, minVersion int) Caller {
+		return CallerFunc(func(method string, args, reply interface{}) error {
+			*reply.(*HelloResponse) = HelloResponse{Version: version, MinVersion: minVersion}
+			return nil
+		})
+	}
+	if _, err := NewClient(server(Version, MinVersion)).Hello("client"); err != nil {
+		t.Fatalf("Hello with a matching server: %v", err)
+	}
+	var verr *VersionError
+	if _, err := NewClient(server(Version+1, Version+1)).Hello("client"); !errors.As(err, &verr) {
+		t.Fatalf("Hello with a server needing a newer client = %v, want a *VersionError", err)
+	}
+	other := errors.New("connection reset")
+	failing := CallerFunc(func(string, interface{}, interface{}) error { return other })
+	if _, err := NewClient(failing).Hello("client"); err != other {
+		t.Fatalf("Hello passed %v through as %v", other, err)
+	}
+}
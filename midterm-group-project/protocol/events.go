@@ -0,0 +1,42 @@
+package protocol
+
+import "time"
+
+// Event is something that happened on the server, delivered to pollers.
+// Kind is "broadcast", "command", "output", "exit", "approval" (a command
+// waits for or got an admin's decision) or "session-end".
+type Event struct {
+	Seq      int64
+	Time     time.Time
+	Kind     string
+	ClientID string // Session the event is about (empty for broadcasts)
+	Command  string
+	Output   string
+	ExitCode int
+	Message  string
+}
+
+// PollRequest asks for events after sequence number After, waiting up to
+// Wait for one to arrive. After < 0 starts from the current position.
+type PollRequest struct {
+	ID     string
+	Token  string
+	Secret string
+	After  int64
+	Wait   time.Duration
+}
+
+// PollResponse carries the events and the sequence number to poll after next
+type PollResponse struct {
+	Events []Event
+	Next   int64
+	Missed int64 // Events dropped from the backlog before they were read
+	Closed bool  // The server is shutting down; stop polling
+	Error  string
+}
+
+// BroadcastRequest sends a message to every interactive client
+type BroadcastRequest struct {
+	Token   string
+	Message string
+}
@@ -0,0 +1,79 @@
+// Package protocol holds the wire types of RemoteShellService and a typed
+// client for it. The server, client and admin binaries all build against
+// it, so a request type cannot drift between them.
+//
+// The transport is net/rpc with gob. Gob matches struct fields by name and
+// skips fields the other side does not have, so fields may be added freely;
+// renaming or retyping one, or changing a reply type, needs a Version bump.
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ServiceName is the name the server registers its RPC methods under
+const ServiceName = "RemoteShellService"
+
+const (
+	// Version is the protocol version spoken by this package
+	Version = 1
+
+	// MinVersion is the oldest peer version this package still works with.
+	// Peers that predate the handshake count as version 0, which is not
+	// compatible: Register replied with a string and needed no secret.
+	MinVersion = 1
+)
+
+// HelloRequest opens the version handshake
+type HelloRequest struct {
+	Version    int
+	MinVersion int
+	Program    string // "client", "admin", ... for the server log
+}
+
+// HelloResponse is the server's side of the handshake
+type HelloResponse struct {
+	Version    int
+	MinVersion int
+}
+
+// VersionError reports two sides that cannot talk to each other
+type VersionError struct {
+	Local, Peer string // Program names, e.g. "server" and "client"
+	Version     int    // The peer's protocol version
+	MinVersion  int    // The oldest version the peer accepts
+}
+
+func (e *VersionError) Error() string {
+	if e.Version < MinVersion {
+		return fmt.Sprintf("protocol mismatch: %s speaks version %d, %s needs %d or newer; upgrade the %s",
+			e.Peer, e.Version, e.Local, MinVersion, e.Peer)
+	}
+	return fmt.Sprintf("protocol mismatch: %s needs version %d or newer, %s speaks %d; upgrade the %s",
+		e.Peer, e.MinVersion, e.Local, Version, e.Local)
+}
+
+// Compatible returns a *VersionError if peer, speaking version and
+// accepting minVersion and up, cannot talk to local
+func Compatible(local, peer string, version, minVersion int) error {
+	if version < MinVersion || Version < minVersion {
+		return &VersionError{Local: local, Peer: peer, Version: version, MinVersion: minVersion}
+	}
+	return nil
+}
+
+// ReplyError turns the "Error: ..." replies of the string-valued RPCs
+// (Heartbeat, SetEnv, UnsetEnv, ChangeDir) into an error
+func ReplyError(reply string) error {
+	if strings.HasPrefix(reply, "Error: ") {
+		return fmt.Errorf("%s", strings.TrimPrefix(reply, "Error: "))
+	}
+	return nil
+}
+
+// IsMissingMethod reports whether err is net/rpc's reply for a method the
+// server does not have, i.e. an older server
+func IsMissingMethod(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "can't find method")
+}
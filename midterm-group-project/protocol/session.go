@@ -0,0 +1,176 @@
+package protocol
+
+import "time"
+
+// CommandRequest represents a command execution request
+type CommandRequest struct {
+	Command   string
+	Args      []string
+	ID        string // Client ID for tracking
+	Token     string // Auth token
+	Secret    string // Session secret issued by Register
+	RequestID string // Client generated, reused on retries so the command runs at most once
+
+	// ApprovalWait is how long to wait for an admin if the command needs
+	// approval (0 = the server's limit, which also caps it)
+	ApprovalWait time.Duration
+
+	// Input for the command: up to MaxInlineStdin bytes inline, or larger
+	// input uploaded earlier with UploadStdin. Without either the command
+	// reads an empty stdin.
+	Stdin       []byte
+	StdinHandle string
+}
+
+// CommandResponse represents the result of command execution
+type CommandResponse struct {
+	Output   string
+	Error    string
+	ExitCode int
+	ID       string
+	Replayed bool // Result of an earlier attempt with the same RequestID
+
+	ApprovedBy string // Admin who approved the command, if it needed approval
+
+	// Output holds at most the server's output limit. If the command wrote
+	// more, OutputSize is the full size and the rest can be read with
+	// FetchOutput(OutputHandle) while the session lasts.
+	OutputSize   int64
+	OutputHandle string
+}
+
+// HeartbeatRequest for keepalive
+type HeartbeatRequest struct {
+	ID     string
+	Token  string
+	Secret string
+}
+
+// EndSessionRequest ends the caller's own session
+type EndSessionRequest struct {
+	ID     string
+	Token  string
+	Secret string
+}
+
+// RegisterRequest for registering client. Secret must be the one issued
+// earlier to resume an existing session; leave it empty for a new session.
+type RegisterRequest struct {
+	ID     string
+	Token  string
+	Secret string
+}
+
+// RegisterResponse carries the session secret required on later calls
+type RegisterResponse struct {
+	Message string
+	Secret  string
+	Resumed bool // An existing session was resumed
+	Error   string
+}
+
+// EnvRequest for set env
+type EnvRequest struct {
+	ID     string
+	Token  string
+	Secret string
+	Key    string
+	Value  string
+	// Keep the value secret: it is never echoed back and is redacted from
+	// output, audit entries and recordings
+	Sensitive bool
+}
+
+// EnvKeyRequest for reading or removing a single env var
+type EnvKeyRequest struct {
+	ID     string
+	Token  string
+	Secret string
+	Key    string
+}
+
+// EnvValueResponse is the result of GetEnv
+type EnvValueResponse struct {
+	Key       string
+	Value     string
+	Exists    bool
+	Sensitive bool // Value is withheld because the variable is secret
+	Error     string
+}
+
+// ListEnvRequest for listing a session's environment
+type ListEnvRequest struct {
+	ID     string
+	Token  string
+	Secret string
+}
+
+// DirRequest for change directory
+type DirRequest struct {
+	ID     string
+	Token  string
+	Secret string
+	Dir    string
+}
+
+// HistoryRequest for reading a session's command history.
+// The owning client passes its session secret; admins may read any session.
+type HistoryRequest struct {
+	ID     string
+	Token  string
+	Secret string
+	Limit  int // Return only the last Limit entries (0 = all retained)
+}
+
+// HistoryEntry records one executed command
+type HistoryEntry struct {
+	Seq       int
+	Command   string
+	StartedAt time.Time
+	Duration  time.Duration
+	ExitCode  int
+}
+
+// SessionInfoVersion is reported in every SessionInfo. Fields are only ever
+// added (gob skips fields a peer does not know), so readers can rely on
+// everything present in the version they were built against.
+const SessionInfoVersion = 1
+
+// SessionInfo describes one session. It replaces the untyped maps returned
+// by GetSessionInfo and ListSessions.
+type SessionInfo struct {
+	Version     int
+	ID          string
+	Owner       string
+	WorkDir     string
+	EnvCount    int
+	ConnectedAt time.Time
+	LastActive  time.Time
+	Active      bool // Active within the session timeout
+	Commands    int  // Commands run so far
+	Running     int  // Commands running now
+	Connections []ConnInfo
+}
+
+// ConnInfo describes a live connection serving a session
+type ConnInfo struct {
+	RemoteAddr  string
+	TLSIdentity string // "plain", "tls" or "tls:<CN>"
+	ConnectedAt time.Time
+	BytesIn     int64
+	BytesOut    int64
+}
+
+// SessionInfoRequest asks for one session. Admins may read any session;
+// anyone else must own it and send its secret.
+type SessionInfoRequest struct {
+	ID     string
+	Token  string
+	Secret string
+}
+
+// SessionList is the ListSessionsV2 reply
+type SessionList struct {
+	Version  int
+	Sessions []SessionInfo
+}
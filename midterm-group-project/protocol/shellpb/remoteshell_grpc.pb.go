@@ -0,0 +1,1430 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: remoteshell.proto
+
+// RemoteShell is the gRPC form of RemoteShellService (see package protocol).
+// Regenerate the Go code with `make proto`.
+
+package shellpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	RemoteShell_Hello_FullMethodName               = "/remoteshell.v1.RemoteShell/Hello"
+	RemoteShell_Register_FullMethodName            = "/remoteshell.v1.RemoteShell/Register"
+	RemoteShell_Heartbeat_FullMethodName           = "/remoteshell.v1.RemoteShell/Heartbeat"
+	RemoteShell_EndSession_FullMethodName          = "/remoteshell.v1.RemoteShell/EndSession"
+	RemoteShell_Execute_FullMethodName             = "/remoteshell.v1.RemoteShell/Execute"
+	RemoteShell_Shell_FullMethodName               = "/remoteshell.v1.RemoteShell/Shell"
+	RemoteShell_SetEnv_FullMethodName              = "/remoteshell.v1.RemoteShell/SetEnv"
+	RemoteShell_UnsetEnv_FullMethodName            = "/remoteshell.v1.RemoteShell/UnsetEnv"
+	RemoteShell_GetEnv_FullMethodName              = "/remoteshell.v1.RemoteShell/GetEnv"
+	RemoteShell_ListEnv_FullMethodName             = "/remoteshell.v1.RemoteShell/ListEnv"
+	RemoteShell_ChangeDir_FullMethodName           = "/remoteshell.v1.RemoteShell/ChangeDir"
+	RemoteShell_History_FullMethodName             = "/remoteshell.v1.RemoteShell/History"
+	RemoteShell_SessionInfo_FullMethodName         = "/remoteshell.v1.RemoteShell/SessionInfo"
+	RemoteShell_Upload_FullMethodName              = "/remoteshell.v1.RemoteShell/Upload"
+	RemoteShell_Download_FullMethodName            = "/remoteshell.v1.RemoteShell/Download"
+	RemoteShell_FetchOutput_FullMethodName         = "/remoteshell.v1.RemoteShell/FetchOutput"
+	RemoteShell_UploadStdin_FullMethodName         = "/remoteshell.v1.RemoteShell/UploadStdin"
+	RemoteShell_ListClients_FullMethodName         = "/remoteshell.v1.RemoteShell/ListClients"
+	RemoteShell_ListSessions_FullMethodName        = "/remoteshell.v1.RemoteShell/ListSessions"
+	RemoteShell_KillSession_FullMethodName         = "/remoteshell.v1.RemoteShell/KillSession"
+	RemoteShell_BanClient_FullMethodName           = "/remoteshell.v1.RemoteShell/BanClient"
+	RemoteShell_ListBanned_FullMethodName          = "/remoteshell.v1.RemoteShell/ListBanned"
+	RemoteShell_AddToWhitelist_FullMethodName      = "/remoteshell.v1.RemoteShell/AddToWhitelist"
+	RemoteShell_RemoveFromWhitelist_FullMethodName = "/remoteshell.v1.RemoteShell/RemoveFromWhitelist"
+	RemoteShell_ListWhitelist_FullMethodName       = "/remoteshell.v1.RemoteShell/ListWhitelist"
+	RemoteShell_AuditLog_FullMethodName            = "/remoteshell.v1.RemoteShell/AuditLog"
+	RemoteShell_ListJobs_FullMethodName            = "/remoteshell.v1.RemoteShell/ListJobs"
+	RemoteShell_ListApprovals_FullMethodName       = "/remoteshell.v1.RemoteShell/ListApprovals"
+	RemoteShell_ApproveCommand_FullMethodName      = "/remoteshell.v1.RemoteShell/ApproveCommand"
+	RemoteShell_RejectCommand_FullMethodName       = "/remoteshell.v1.RemoteShell/RejectCommand"
+	RemoteShell_ReadRecording_FullMethodName       = "/remoteshell.v1.RemoteShell/ReadRecording"
+	RemoteShell_Top_FullMethodName                 = "/remoteshell.v1.RemoteShell/Top"
+	RemoteShell_WatchSession_FullMethodName        = "/remoteshell.v1.RemoteShell/WatchSession"
+	RemoteShell_Broadcast_FullMethodName           = "/remoteshell.v1.RemoteShell/Broadcast"
+)
+
+// RemoteShellClient is the client API for RemoteShell service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Credentials travel as metadata rather than in messages:
+//
+//	authorization: Bearer <token>   on every call (when the server has a token)
+//	x-session-secret: <secret>      on calls about a session, as issued by Register
+//
+// Refusals map to status codes: UNAUTHENTICATED (bad token), PERMISSION_DENIED
+// (banned, wrong session secret, command not whitelisted or rejected by an
+// admin), NOT_FOUND (no such session), RESOURCE_EXHAUSTED (rate limit),
+// DEADLINE_EXCEEDED (no admin approved the command in time), UNAVAILABLE
+// (server shutting down), FAILED_PRECONDITION (incompatible Hello) and
+// INVALID_ARGUMENT (anything else the server rejects).
+type RemoteShellClient interface {
+	// Version handshake, as RemoteShellService.Hello
+	Hello(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (*HelloResponse, error)
+	// Session calls
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
+	Heartbeat(ctx context.Context, in *SessionRef, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// EndSession ends the caller's session so its client ID is free again
+	EndSession(ctx context.Context, in *SessionRef, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// Execute streams output chunks as the command produces them, then one result
+	Execute(ctx context.Context, in *CommandRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExecuteReply], error)
+	// Shell is an interactive session: the first message names the session,
+	// every message with a line runs it ("cd <dir>" changes directory) and
+	// the server also pushes broadcasts and the end of the session
+	Shell(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ShellInput, ShellOutput], error)
+	SetEnv(ctx context.Context, in *EnvRequest, opts ...grpc.CallOption) (*StatusReply, error)
+	UnsetEnv(ctx context.Context, in *EnvKeyRequest, opts ...grpc.CallOption) (*StatusReply, error)
+	GetEnv(ctx context.Context, in *EnvKeyRequest, opts ...grpc.CallOption) (*EnvValue, error)
+	ListEnv(ctx context.Context, in *SessionRef, opts ...grpc.CallOption) (*EnvList, error)
+	ChangeDir(ctx context.Context, in *DirRequest, opts ...grpc.CallOption) (*StatusReply, error)
+	History(ctx context.Context, in *HistoryRequest, opts ...grpc.CallOption) (*HistoryReply, error)
+	SessionInfo(ctx context.Context, in *SessionRef, opts ...grpc.CallOption) (*Session, error)
+	Upload(ctx context.Context, in *UploadRequest, opts ...grpc.CallOption) (*UploadReply, error)
+	Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (*DownloadReply, error)
+	// FetchOutput reads the rest of a command's output past what Execute sent
+	FetchOutput(ctx context.Context, in *FetchOutputRequest, opts ...grpc.CallOption) (*OutputChunk, error)
+	// UploadStdin stores a command's input in chunks for Execute to read
+	UploadStdin(ctx context.Context, in *StdinChunk, opts ...grpc.CallOption) (*StdinUploadReply, error)
+	// Admin calls
+	ListClients(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*StringList, error)
+	ListSessions(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*SessionList, error)
+	KillSession(ctx context.Context, in *SessionRef, opts ...grpc.CallOption) (*StatusReply, error)
+	BanClient(ctx context.Context, in *BanRequest, opts ...grpc.CallOption) (*StringList, error)
+	ListBanned(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*StringList, error)
+	AddToWhitelist(ctx context.Context, in *WhitelistRequest, opts ...grpc.CallOption) (*StringList, error)
+	RemoveFromWhitelist(ctx context.Context, in *WhitelistRequest, opts ...grpc.CallOption) (*StringList, error)
+	ListWhitelist(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*StringList, error)
+	AuditLog(ctx context.Context, in *AuditRequest, opts ...grpc.CallOption) (*AuditReply, error)
+	ListJobs(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*JobList, error)
+	// Commands that need approval wait in Execute until an admin decides
+	ListApprovals(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ApprovalList, error)
+	ApproveCommand(ctx context.Context, in *ApprovalDecision, opts ...grpc.CallOption) (*PendingApproval, error)
+	RejectCommand(ctx context.Context, in *ApprovalDecision, opts ...grpc.CallOption) (*PendingApproval, error)
+	// ReadRecording returns a chunk of a session's newest asciicast recording
+	ReadRecording(ctx context.Context, in *RecordingRequest, opts ...grpc.CallOption) (*RecordingChunk, error)
+	Top(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*TopSnapshot, error)
+	// WatchSession streams a session's commands and output until it ends
+	WatchSession(ctx context.Context, in *SessionRef, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+	Broadcast(ctx context.Context, in *BroadcastRequest, opts ...grpc.CallOption) (*BroadcastReply, error)
+}
+
+type remoteShellClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRemoteShellClient(cc grpc.ClientConnInterface) RemoteShellClient {
+	return &remoteShellClient{cc}
+}
+
+func (c *remoteShellClient) Hello(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (*HelloResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HelloResponse)
+	err := c.cc.Invoke(ctx, RemoteShell_Hello_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RegisterResponse)
+	err := c.cc.Invoke(ctx, RemoteShell_Register_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) Heartbeat(ctx context.Context, in *SessionRef, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, RemoteShell_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) EndSession(ctx context.Context, in *SessionRef, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, RemoteShell_EndSession_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) Execute(ctx context.Context, in *CommandRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExecuteReply], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &RemoteShell_ServiceDesc.Streams[0], RemoteShell_Execute_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[CommandRequest, ExecuteReply]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RemoteShell_ExecuteClient = grpc.ServerStreamingClient[ExecuteReply]
+
+func (c *remoteShellClient) Shell(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ShellInput, ShellOutput], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &RemoteShell_ServiceDesc.Streams[1], RemoteShell_Shell_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ShellInput, ShellOutput]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RemoteShell_ShellClient = grpc.BidiStreamingClient[ShellInput, ShellOutput]
+
+func (c *remoteShellClient) SetEnv(ctx context.Context, in *EnvRequest, opts ...grpc.CallOption) (*StatusReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatusReply)
+	err := c.cc.Invoke(ctx, RemoteShell_SetEnv_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) UnsetEnv(ctx context.Context, in *EnvKeyRequest, opts ...grpc.CallOption) (*StatusReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatusReply)
+	err := c.cc.Invoke(ctx, RemoteShell_UnsetEnv_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) GetEnv(ctx context.Context, in *EnvKeyRequest, opts ...grpc.CallOption) (*EnvValue, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EnvValue)
+	err := c.cc.Invoke(ctx, RemoteShell_GetEnv_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) ListEnv(ctx context.Context, in *SessionRef, opts ...grpc.CallOption) (*EnvList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EnvList)
+	err := c.cc.Invoke(ctx, RemoteShell_ListEnv_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) ChangeDir(ctx context.Context, in *DirRequest, opts ...grpc.CallOption) (*StatusReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatusReply)
+	err := c.cc.Invoke(ctx, RemoteShell_ChangeDir_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) History(ctx context.Context, in *HistoryRequest, opts ...grpc.CallOption) (*HistoryReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HistoryReply)
+	err := c.cc.Invoke(ctx, RemoteShell_History_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) SessionInfo(ctx context.Context, in *SessionRef, opts ...grpc.CallOption) (*Session, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Session)
+	err := c.cc.Invoke(ctx, RemoteShell_SessionInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) Upload(ctx context.Context, in *UploadRequest, opts ...grpc.CallOption) (*UploadReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UploadReply)
+	err := c.cc.Invoke(ctx, RemoteShell_Upload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (*DownloadReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DownloadReply)
+	err := c.cc.Invoke(ctx, RemoteShell_Download_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) FetchOutput(ctx context.Context, in *FetchOutputRequest, opts ...grpc.CallOption) (*OutputChunk, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OutputChunk)
+	err := c.cc.Invoke(ctx, RemoteShell_FetchOutput_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) UploadStdin(ctx context.Context, in *StdinChunk, opts ...grpc.CallOption) (*StdinUploadReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StdinUploadReply)
+	err := c.cc.Invoke(ctx, RemoteShell_UploadStdin_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) ListClients(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*StringList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StringList)
+	err := c.cc.Invoke(ctx, RemoteShell_ListClients_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) ListSessions(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*SessionList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SessionList)
+	err := c.cc.Invoke(ctx, RemoteShell_ListSessions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) KillSession(ctx context.Context, in *SessionRef, opts ...grpc.CallOption) (*StatusReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatusReply)
+	err := c.cc.Invoke(ctx, RemoteShell_KillSession_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) BanClient(ctx context.Context, in *BanRequest, opts ...grpc.CallOption) (*StringList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StringList)
+	err := c.cc.Invoke(ctx, RemoteShell_BanClient_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) ListBanned(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*StringList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StringList)
+	err := c.cc.Invoke(ctx, RemoteShell_ListBanned_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) AddToWhitelist(ctx context.Context, in *WhitelistRequest, opts ...grpc.CallOption) (*StringList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StringList)
+	err := c.cc.Invoke(ctx, RemoteShell_AddToWhitelist_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) RemoveFromWhitelist(ctx context.Context, in *WhitelistRequest, opts ...grpc.CallOption) (*StringList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StringList)
+	err := c.cc.Invoke(ctx, RemoteShell_RemoveFromWhitelist_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) ListWhitelist(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*StringList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StringList)
+	err := c.cc.Invoke(ctx, RemoteShell_ListWhitelist_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) AuditLog(ctx context.Context, in *AuditRequest, opts ...grpc.CallOption) (*AuditReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AuditReply)
+	err := c.cc.Invoke(ctx, RemoteShell_AuditLog_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) ListJobs(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*JobList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(JobList)
+	err := c.cc.Invoke(ctx, RemoteShell_ListJobs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) ListApprovals(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ApprovalList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ApprovalList)
+	err := c.cc.Invoke(ctx, RemoteShell_ListApprovals_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) ApproveCommand(ctx context.Context, in *ApprovalDecision, opts ...grpc.CallOption) (*PendingApproval, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PendingApproval)
+	err := c.cc.Invoke(ctx, RemoteShell_ApproveCommand_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) RejectCommand(ctx context.Context, in *ApprovalDecision, opts ...grpc.CallOption) (*PendingApproval, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PendingApproval)
+	err := c.cc.Invoke(ctx, RemoteShell_RejectCommand_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) ReadRecording(ctx context.Context, in *RecordingRequest, opts ...grpc.CallOption) (*RecordingChunk, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RecordingChunk)
+	err := c.cc.Invoke(ctx, RemoteShell_ReadRecording_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) Top(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*TopSnapshot, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TopSnapshot)
+	err := c.cc.Invoke(ctx, RemoteShell_Top_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoteShellClient) WatchSession(ctx context.Context, in *SessionRef, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &RemoteShell_ServiceDesc.Streams[2], RemoteShell_WatchSession_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SessionRef, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RemoteShell_WatchSessionClient = grpc.ServerStreamingClient[Event]
+
+func (c *remoteShellClient) Broadcast(ctx context.Context, in *BroadcastRequest, opts ...grpc.CallOption) (*BroadcastReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BroadcastReply)
+	err := c.cc.Invoke(ctx, RemoteShell_Broadcast_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RemoteShellServer is the server API for RemoteShell service.
+// All implementations must embed UnimplementedRemoteShellServer
+// for forward compatibility.
+//
+// Credentials travel as metadata rather than in messages:
+//
+//	authorization: Bearer <token>   on every call (when the server has a token)
+//	x-session-secret: <secret>      on calls about a session, as issued by Register
+//
+// Refusals map to status codes: UNAUTHENTICATED (bad token), PERMISSION_DENIED
+// (banned, wrong session secret, command not whitelisted or rejected by an
+// admin), NOT_FOUND (no such session), RESOURCE_EXHAUSTED (rate limit),
+// DEADLINE_EXCEEDED (no admin approved the command in time), UNAVAILABLE
+// (server shutting down), FAILED_PRECONDITION (incompatible Hello) and
+// INVALID_ARGUMENT (anything else the server rejects).
+type RemoteShellServer interface {
+	// Version handshake, as RemoteShellService.Hello
+	Hello(context.Context, *HelloRequest) (*HelloResponse, error)
+	// Session calls
+	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
+	Heartbeat(context.Context, *SessionRef) (*emptypb.Empty, error)
+	// EndSession ends the caller's session so its client ID is free again
+	EndSession(context.Context, *SessionRef) (*emptypb.Empty, error)
+	// Execute streams output chunks as the command produces them, then one result
+	Execute(*CommandRequest, grpc.ServerStreamingServer[ExecuteReply]) error
+	// Shell is an interactive session: the first message names the session,
+	// every message with a line runs it ("cd <dir>" changes directory) and
+	// the server also pushes broadcasts and the end of the session
+	Shell(grpc.BidiStreamingServer[ShellInput, ShellOutput]) error
+	SetEnv(context.Context, *EnvRequest) (*StatusReply, error)
+	UnsetEnv(context.Context, *EnvKeyRequest) (*StatusReply, error)
+	GetEnv(context.Context, *EnvKeyRequest) (*EnvValue, error)
+	ListEnv(context.Context, *SessionRef) (*EnvList, error)
+	ChangeDir(context.Context, *DirRequest) (*StatusReply, error)
+	History(context.Context, *HistoryRequest) (*HistoryReply, error)
+	SessionInfo(context.Context, *SessionRef) (*Session, error)
+	Upload(context.Context, *UploadRequest) (*UploadReply, error)
+	Download(context.Context, *DownloadRequest) (*DownloadReply, error)
+	// FetchOutput reads the rest of a command's output past what Execute sent
+	FetchOutput(context.Context, *FetchOutputRequest) (*OutputChunk, error)
+	// UploadStdin stores a command's input in chunks for Execute to read
+	UploadStdin(context.Context, *StdinChunk) (*StdinUploadReply, error)
+	// Admin calls
+	ListClients(context.Context, *emptypb.Empty) (*StringList, error)
+	ListSessions(context.Context, *emptypb.Empty) (*SessionList, error)
+	KillSession(context.Context, *SessionRef) (*StatusReply, error)
+	BanClient(context.Context, *BanRequest) (*StringList, error)
+	ListBanned(context.Context, *emptypb.Empty) (*StringList, error)
+	AddToWhitelist(context.Context, *WhitelistRequest) (*StringList, error)
+	RemoveFromWhitelist(context.Context, *WhitelistRequest) (*StringList, error)
+	ListWhitelist(context.Context, *emptypb.Empty) (*StringList, error)
+	AuditLog(context.Context, *AuditRequest) (*AuditReply, error)
+	ListJobs(context.Context, *emptypb.Empty) (*JobList, error)
+	// Commands that need approval wait in Execute until an admin decides
+	ListApprovals(context.Context, *emptypb.Empty) (*ApprovalList, error)
+	ApproveCommand(context.Context, *ApprovalDecision) (*PendingApproval, error)
+	RejectCommand(context.Context, *ApprovalDecision) (*PendingApproval, error)
+	// ReadRecording returns a chunk of a session's newest asciicast recording
+	ReadRecording(context.Context, *RecordingRequest) (*RecordingChunk, error)
+	Top(context.Context, *emptypb.Empty) (*TopSnapshot, error)
+	// WatchSession streams a session's commands and output until it ends
+	WatchSession(*SessionRef, grpc.ServerStreamingServer[Event]) error
+	Broadcast(context.Context, *BroadcastRequest) (*BroadcastReply, error)
+	mustEmbedUnimplementedRemoteShellServer()
+}
+
+// UnimplementedRemoteShellServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRemoteShellServer struct{}
+
+func (UnimplementedRemoteShellServer) Hello(context.Context, *HelloRequest) (*HelloResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Hello not implemented")
+}
+func (UnimplementedRemoteShellServer) Register(context.Context, *RegisterRequest) (*RegisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedRemoteShellServer) Heartbeat(context.Context, *SessionRef) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedRemoteShellServer) EndSession(context.Context, *SessionRef) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EndSession not implemented")
+}
+func (UnimplementedRemoteShellServer) Execute(*CommandRequest, grpc.ServerStreamingServer[ExecuteReply]) error {
+	return status.Errorf(codes.Unimplemented, "method Execute not implemented")
+}
+func (UnimplementedRemoteShellServer) Shell(grpc.BidiStreamingServer[ShellInput, ShellOutput]) error {
+	return status.Errorf(codes.Unimplemented, "method Shell not implemented")
+}
+func (UnimplementedRemoteShellServer) SetEnv(context.Context, *EnvRequest) (*StatusReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetEnv not implemented")
+}
+func (UnimplementedRemoteShellServer) UnsetEnv(context.Context, *EnvKeyRequest) (*StatusReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnsetEnv not implemented")
+}
+func (UnimplementedRemoteShellServer) GetEnv(context.Context, *EnvKeyRequest) (*EnvValue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEnv not implemented")
+}
+func (UnimplementedRemoteShellServer) ListEnv(context.Context, *SessionRef) (*EnvList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListEnv not implemented")
+}
+func (UnimplementedRemoteShellServer) ChangeDir(context.Context, *DirRequest) (*StatusReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ChangeDir not implemented")
+}
+func (UnimplementedRemoteShellServer) History(context.Context, *HistoryRequest) (*HistoryReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method History not implemented")
+}
+func (UnimplementedRemoteShellServer) SessionInfo(context.Context, *SessionRef) (*Session, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SessionInfo not implemented")
+}
+func (UnimplementedRemoteShellServer) Upload(context.Context, *UploadRequest) (*UploadReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedRemoteShellServer) Download(context.Context, *DownloadRequest) (*DownloadReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Download not implemented")
+}
+func (UnimplementedRemoteShellServer) FetchOutput(context.Context, *FetchOutputRequest) (*OutputChunk, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchOutput not implemented")
+}
+func (UnimplementedRemoteShellServer) UploadStdin(context.Context, *StdinChunk) (*StdinUploadReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UploadStdin not implemented")
+}
+func (UnimplementedRemoteShellServer) ListClients(context.Context, *emptypb.Empty) (*StringList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListClients not implemented")
+}
+func (UnimplementedRemoteShellServer) ListSessions(context.Context, *emptypb.Empty) (*SessionList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (UnimplementedRemoteShellServer) KillSession(context.Context, *SessionRef) (*StatusReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KillSession not implemented")
+}
+func (UnimplementedRemoteShellServer) BanClient(context.Context, *BanRequest) (*StringList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BanClient not implemented")
+}
+func (UnimplementedRemoteShellServer) ListBanned(context.Context, *emptypb.Empty) (*StringList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBanned not implemented")
+}
+func (UnimplementedRemoteShellServer) AddToWhitelist(context.Context, *WhitelistRequest) (*StringList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddToWhitelist not implemented")
+}
+func (UnimplementedRemoteShellServer) RemoveFromWhitelist(context.Context, *WhitelistRequest) (*StringList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveFromWhitelist not implemented")
+}
+func (UnimplementedRemoteShellServer) ListWhitelist(context.Context, *emptypb.Empty) (*StringList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWhitelist not implemented")
+}
+func (UnimplementedRemoteShellServer) AuditLog(context.Context, *AuditRequest) (*AuditReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AuditLog not implemented")
+}
+func (UnimplementedRemoteShellServer) ListJobs(context.Context, *emptypb.Empty) (*JobList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListJobs not implemented")
+}
+func (UnimplementedRemoteShellServer) ListApprovals(context.Context, *emptypb.Empty) (*ApprovalList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListApprovals not implemented")
+}
+func (UnimplementedRemoteShellServer) ApproveCommand(context.Context, *ApprovalDecision) (*PendingApproval, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ApproveCommand not implemented")
+}
+func (UnimplementedRemoteShellServer) RejectCommand(context.Context, *ApprovalDecision) (*PendingApproval, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RejectCommand not implemented")
+}
+func (UnimplementedRemoteShellServer) ReadRecording(context.Context, *RecordingRequest) (*RecordingChunk, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadRecording not implemented")
+}
+func (UnimplementedRemoteShellServer) Top(context.Context, *emptypb.Empty) (*TopSnapshot, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Top not implemented")
+}
+func (UnimplementedRemoteShellServer) WatchSession(*SessionRef, grpc.ServerStreamingServer[Event]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchSession not implemented")
+}
+func (UnimplementedRemoteShellServer) Broadcast(context.Context, *BroadcastRequest) (*BroadcastReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Broadcast not implemented")
+}
+func (UnimplementedRemoteShellServer) mustEmbedUnimplementedRemoteShellServer() {}
+func (UnimplementedRemoteShellServer) testEmbeddedByValue()                     {}
+
+// UnsafeRemoteShellServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RemoteShellServer will
+// result in compilation errors.
+type UnsafeRemoteShellServer interface {
+	mustEmbedUnimplementedRemoteShellServer()
+}
+
+func RegisterRemoteShellServer(s grpc.ServiceRegistrar, srv RemoteShellServer) {
+	// If the following call pancis, it indicates UnimplementedRemoteShellServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&RemoteShell_ServiceDesc, srv)
+}
+
+func _RemoteShell_Hello_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HelloRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).Hello(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_Hello_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).Hello(ctx, req.(*HelloRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).Heartbeat(ctx, req.(*SessionRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_EndSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).EndSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_EndSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).EndSession(ctx, req.(*SessionRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_Execute_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(CommandRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RemoteShellServer).Execute(m, &grpc.GenericServerStream[CommandRequest, ExecuteReply]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RemoteShell_ExecuteServer = grpc.ServerStreamingServer[ExecuteReply]
+
+func _RemoteShell_Shell_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(RemoteShellServer).Shell(&grpc.GenericServerStream[ShellInput, ShellOutput]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RemoteShell_ShellServer = grpc.BidiStreamingServer[ShellInput, ShellOutput]
+
+func _RemoteShell_SetEnv_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnvRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).SetEnv(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_SetEnv_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).SetEnv(ctx, req.(*EnvRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_UnsetEnv_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnvKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).UnsetEnv(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_UnsetEnv_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).UnsetEnv(ctx, req.(*EnvKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_GetEnv_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnvKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).GetEnv(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_GetEnv_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).GetEnv(ctx, req.(*EnvKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_ListEnv_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).ListEnv(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_ListEnv_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).ListEnv(ctx, req.(*SessionRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_ChangeDir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DirRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).ChangeDir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_ChangeDir_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).ChangeDir(ctx, req.(*DirRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_History_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).History(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_History_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).History(ctx, req.(*HistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_SessionInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).SessionInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_SessionInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).SessionInfo(ctx, req.(*SessionRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_Upload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).Upload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_Upload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).Upload(ctx, req.(*UploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_Download_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DownloadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).Download(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_Download_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).Download(ctx, req.(*DownloadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_FetchOutput_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchOutputRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).FetchOutput(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_FetchOutput_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).FetchOutput(ctx, req.(*FetchOutputRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_UploadStdin_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StdinChunk)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).UploadStdin(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_UploadStdin_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).UploadStdin(ctx, req.(*StdinChunk))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_ListClients_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).ListClients(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_ListClients_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).ListClients(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_ListSessions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).ListSessions(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_KillSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).KillSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_KillSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).KillSession(ctx, req.(*SessionRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_BanClient_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).BanClient(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_BanClient_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).BanClient(ctx, req.(*BanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_ListBanned_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).ListBanned(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_ListBanned_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).ListBanned(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_AddToWhitelist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WhitelistRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).AddToWhitelist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_AddToWhitelist_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).AddToWhitelist(ctx, req.(*WhitelistRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_RemoveFromWhitelist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WhitelistRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).RemoveFromWhitelist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_RemoveFromWhitelist_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).RemoveFromWhitelist(ctx, req.(*WhitelistRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_ListWhitelist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).ListWhitelist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_ListWhitelist_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).ListWhitelist(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_AuditLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuditRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).AuditLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_AuditLog_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).AuditLog(ctx, req.(*AuditRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_ListJobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).ListJobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_ListJobs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).ListJobs(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_ListApprovals_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).ListApprovals(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_ListApprovals_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).ListApprovals(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_ApproveCommand_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApprovalDecision)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).ApproveCommand(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_ApproveCommand_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).ApproveCommand(ctx, req.(*ApprovalDecision))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_RejectCommand_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApprovalDecision)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).RejectCommand(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_RejectCommand_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).RejectCommand(ctx, req.(*ApprovalDecision))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_ReadRecording_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RecordingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).ReadRecording(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_ReadRecording_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).ReadRecording(ctx, req.(*RecordingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_Top_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).Top(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_Top_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).Top(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RemoteShell_WatchSession_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SessionRef)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RemoteShellServer).WatchSession(m, &grpc.GenericServerStream[SessionRef, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RemoteShell_WatchSessionServer = grpc.ServerStreamingServer[Event]
+
+func _RemoteShell_Broadcast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BroadcastRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoteShellServer).Broadcast(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemoteShell_Broadcast_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoteShellServer).Broadcast(ctx, req.(*BroadcastRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RemoteShell_ServiceDesc is the grpc.ServiceDesc for RemoteShell service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RemoteShell_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "remoteshell.v1.RemoteShell",
+	HandlerType: (*RemoteShellServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Hello",
+			Handler:    _RemoteShell_Hello_Handler,
+		},
+		{
+			MethodName: "Register",
+			Handler:    _RemoteShell_Register_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _RemoteShell_Heartbeat_Handler,
+		},
+		{
+			MethodName: "EndSession",
+			Handler:    _RemoteShell_EndSession_Handler,
+		},
+		{
+			MethodName: "SetEnv",
+			Handler:    _RemoteShell_SetEnv_Handler,
+		},
+		{
+			MethodName: "UnsetEnv",
+			Handler:    _RemoteShell_UnsetEnv_Handler,
+		},
+		{
+			MethodName: "GetEnv",
+			Handler:    _RemoteShell_GetEnv_Handler,
+		},
+		{
+			MethodName: "ListEnv",
+			Handler:    _RemoteShell_ListEnv_Handler,
+		},
+		{
+			MethodName: "ChangeDir",
+			Handler:    _RemoteShell_ChangeDir_Handler,
+		},
+		{
+			MethodName: "History",
+			Handler:    _RemoteShell_History_Handler,
+		},
+		{
+			MethodName: "SessionInfo",
+			Handler:    _RemoteShell_SessionInfo_Handler,
+		},
+		{
+			MethodName: "Upload",
+			Handler:    _RemoteShell_Upload_Handler,
+		},
+		{
+			MethodName: "Download",
+			Handler:    _RemoteShell_Download_Handler,
+		},
+		{
+			MethodName: "FetchOutput",
+			Handler:    _RemoteShell_FetchOutput_Handler,
+		},
+		{
+			MethodName: "UploadStdin",
+			Handler:    _RemoteShell_UploadStdin_Handler,
+		},
+		{
+			MethodName: "ListClients",
+			Handler:    _RemoteShell_ListClients_Handler,
+		},
+		{
+			MethodName: "ListSessions",
+			Handler:    _RemoteShell_ListSessions_Handler,
+		},
+		{
+			MethodName: "KillSession",
+			Handler:    _RemoteShell_KillSession_Handler,
+		},
+		{
+			MethodName: "BanClient",
+			Handler:    _RemoteShell_BanClient_Handler,
+		},
+		{
+			MethodName: "ListBanned",
+			Handler:    _RemoteShell_ListBanned_Handler,
+		},
+		{
+			MethodName: "AddToWhitelist",
+			Handler:    _RemoteShell_AddToWhitelist_Handler,
+		},
+		{
+			MethodName: "RemoveFromWhitelist",
+			Handler:    _RemoteShell_RemoveFromWhitelist_Handler,
+		},
+		{
+			MethodName: "ListWhitelist",
+			Handler:    _RemoteShell_ListWhitelist_Handler,
+		},
+		{
+			MethodName: "AuditLog",
+			Handler:    _RemoteShell_AuditLog_Handler,
+		},
+		{
+			MethodName: "ListJobs",
+			Handler:    _RemoteShell_ListJobs_Handler,
+		},
+		{
+			MethodName: "ListApprovals",
+			Handler:    _RemoteShell_ListApprovals_Handler,
+		},
+		{
+			MethodName: "ApproveCommand",
+			Handler:    _RemoteShell_ApproveCommand_Handler,
+		},
+		{
+			MethodName: "RejectCommand",
+			Handler:    _RemoteShell_RejectCommand_Handler,
+		},
+		{
+			MethodName: "ReadRecording",
+			Handler:    _RemoteShell_ReadRecording_Handler,
+		},
+		{
+			MethodName: "Top",
+			Handler:    _RemoteShell_Top_Handler,
+		},
+		{
+			MethodName: "Broadcast",
+			Handler:    _RemoteShell_Broadcast_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Execute",
+			Handler:       _RemoteShell_Execute_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Shell",
+			Handler:       _RemoteShell_Shell_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchSession",
+			Handler:       _RemoteShell_WatchSession_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "remoteshell.proto",
+}
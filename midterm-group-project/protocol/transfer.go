@@ -0,0 +1,90 @@
+package protocol
+
+import "os"
+
+// MaxChunkSize is the largest chunk the server accepts or returns per call
+const MaxChunkSize = 1024 * 1024
+
+// MaxInlineStdin is the most stdin sent inline with CommandRequest; larger
+// input goes through UploadStdin
+const MaxInlineStdin = 64 * 1024
+
+// UploadRequest carries one chunk of a file being uploaded.
+// Chunks must arrive in order; Offset 0 starts (or restarts) the upload and
+// the chunk with Final set commits it after checking Checksum.
+type UploadRequest struct {
+	ID       string
+	Token    string
+	Secret   string
+	Path     string // Relative to the session WorkDir unless absolute
+	Offset   int64
+	Data     []byte
+	Final    bool
+	Checksum string // Hex SHA-256 of the whole file, required with Final
+	Mode     os.FileMode
+}
+
+// UploadResponse reports upload progress
+type UploadResponse struct {
+	Written int64  // Bytes stored so far
+	Path    string // Resolved destination path (set on Final)
+	Error   string
+}
+
+// DownloadRequest asks for a chunk of a file
+type DownloadRequest struct {
+	ID     string
+	Token  string
+	Secret string
+	Path   string // Relative to the session WorkDir unless absolute
+	Offset int64
+	Length int
+}
+
+// DownloadResponse returns one chunk of a file
+type DownloadResponse struct {
+	Data     []byte
+	Size     int64  // Total file size
+	EOF      bool   // True once the chunk reaches the end of the file
+	Checksum string // Hex SHA-256 of the whole file (only when Offset is 0)
+	Mode     os.FileMode
+	Error    string
+}
+
+// FetchOutputRequest reads part of a command's full output; see
+// CommandResponse.OutputHandle
+type FetchOutputRequest struct {
+	ID     string
+	Token  string
+	Secret string
+	Handle string
+	Offset int64
+	Length int
+}
+
+// OutputChunk is one chunk of a command's full output
+type OutputChunk struct {
+	Data  []byte
+	Size  int64 // Bytes kept, less than OutputSize if the server's spool limit was hit
+	EOF   bool  // True once the chunk reaches the end of the output
+	Error string
+}
+
+// StdinChunk carries one chunk of a command's stdin. The first chunk has
+// no Handle and starts a new upload; later ones send the Handle it returned.
+// Chunks must arrive in order.
+type StdinChunk struct {
+	ID     string
+	Token  string
+	Secret string
+	Handle string
+	Offset int64
+	Data   []byte
+}
+
+// StdinUploadResponse reports stdin upload progress
+type StdinUploadResponse struct {
+	Handle  string // Pass as CommandRequest.StdinHandle; it can be used once
+	Written int64  // Bytes stored so far
+	Error   string
+}
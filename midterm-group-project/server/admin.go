@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// startJob registers a running command and returns its job ID. Caller must
+// hold r.mu.
+func (r *RemoteShellService) startJob(clientID, command, workDir string) int64 {
+	r.nextJob++
+	if r.jobs == nil {
+		r.jobs = make(map[int64]protocol.JobInfo)
+	}
+	r.jobs[r.nextJob] = protocol.JobInfo{JobID: r.nextJob, ClientID: clientID, Command: command, WorkDir: workDir, StartedAt: time.Now()}
+	return r.nextJob
+}
+
+// ListJobs returns the commands currently running, oldest first (admin only)
+func (r *RemoteShellService) ListJobs(req protocol.ListRequest, resp *[]protocol.JobInfo) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]protocol.JobInfo, 0, len(r.jobs))
+	for _, j := range r.jobs {
+		out = append(out, j)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].JobID < out[j].JobID })
+	*resp = out
+	return nil
+}
+
+// BanClient bans or unbans client IDs without touching their sessions and
+// returns the resulting ban list (admin only). Use KillSession to also end
+// a session.
+func (r *RemoteShellService) BanClient(req protocol.BanRequest, resp *[]string) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	action, done := "ban", "Banned"
+	if req.Lift {
+		action, done = "unban", "Unbanned"
+	}
+	for _, id := range req.IDs {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if req.Lift {
+			delete(r.banned, id)
+		} else {
+			r.banned[id] = struct{}{}
+		}
+		r.audit.record(protocol.AuditEntry{Identity: r.identityFor(req.Token), ClientID: id, Action: action, Outcome: "ok"})
+		log.Printf("[Admin] %s client %s", done, id)
+	}
+	*resp = sortedKeys(r.banned)
+	return nil
+}
+
+// ListBanned returns the banned client IDs (admin only)
+func (r *RemoteShellService) ListBanned(req protocol.ListRequest, resp *[]string) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	*resp = sortedKeys(r.banned)
+	return nil
+}
+
+// ListWhitelist returns the allowed commands; empty means all are allowed
+// (admin only)
+func (r *RemoteShellService) ListWhitelist(req protocol.ListRequest, resp *[]string) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	*resp = sortedKeys(r.allowedCmds)
+	return nil
+}
+
+// RemoveFromWhitelist removes commands from the whitelist and returns the
+// rest (admin only). Removing the last entry is refused because an empty
+// whitelist allows every command.
+func (r *RemoteShellService) RemoveFromWhitelist(req protocol.UpdateWhitelistRequest, resp *[]string) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remove := make(map[string]struct{})
+	for _, c := range req.Commands {
+		if f := strings.Fields(c); len(f) > 0 {
+			if _, ok := r.allowedCmds[f[0]]; !ok {
+				return fmt.Errorf("not found: %s is not whitelisted", f[0])
+			}
+			remove[f[0]] = struct{}{}
+		}
+	}
+	if len(remove) > 0 && len(remove) == len(r.allowedCmds) {
+		return fmt.Errorf("refusing to remove every whitelisted command: an empty whitelist allows all commands")
+	}
+	for c := range remove {
+		delete(r.allowedCmds, c)
+		r.audit.record(protocol.AuditEntry{Identity: r.identityFor(req.Token), Action: "whitelist-rm", Detail: c, Outcome: "ok"})
+		log.Printf("[Admin] Removed from whitelist: %s", c)
+	}
+	*resp = sortedKeys(r.allowedCmds)
+	return nil
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	out := keys(m)
+	sort.Strings(out)
+	return out
+}
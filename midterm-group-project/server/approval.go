@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"remote-shell-rpc/protocol"
+)
+
+// defaultApprovalTimeout is how long a command waits for an admin unless
+// --approval-timeout-sec says otherwise
+const defaultApprovalTimeout = 5 * time.Minute
+
+// pendingApproval is a command parked in execute until an admin decides
+type pendingApproval struct {
+	info     protocol.PendingApproval
+	decided  chan struct{} // Closed once approved, rejected or dropped
+	approver string        // Set when approved
+	reason   string        // Why it was not approved
+}
+
+// commandPrefixes run the command that follows them, so the approval policy
+// looks past them
+var commandPrefixes = map[string]bool{
+	"builtin": true, "command": true, "doas": true, "env": true, "exec": true, "ionice": true,
+	"nice": true, "nohup": true, "setsid": true, "stdbuf": true, "sudo": true, "time": true, "timeout": true,
+}
+
+// prefixOptionArgs are options of commandPrefixes that take the next word
+// as their argument
+var prefixOptionArgs = map[string]bool{"-u": true, "-g": true, "-n": true, "-C": true, "-s": true, "-k": true, "-c": true, "-p": true}
+
+// commandRunners run commands given as arguments or on stdin, which the
+// policy cannot pick out reliably
+var commandRunners = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true, "su": true,
+	"eval": true, "source": true, ".": true, "xargs": true, "find": true, "watch": true, "parallel": true,
+}
+
+// shellWord is a word as the shell would run it: quotes and backslashes
+// removed, and a path reduced to the program name
+func shellWord(w string) string {
+	w = strings.NewReplacer(`\`, "", `"`, "", `'`, "").Replace(w)
+	if w == "" {
+		return w
+	}
+	return filepath.Base(w)
+}
+
+// commandName returns the program cmd runs, looking past variable
+// assignments and commandPrefixes with their options ("sudo -u x nice rm"
+// runs rm)
+func commandName(cmd string) string {
+	fields := strings.Fields(cmd)
+	for i := 0; i < len(fields); i++ {
+		w := shellWord(fields[i])
+		switch {
+		case strings.Contains(fields[i], "=") && !strings.HasPrefix(w, "-"):
+			// VAR=value before the command, or env's own assignments
+		case commandPrefixes[w]:
+		case strings.HasPrefix(w, "-") && i > 0:
+			if prefixOptionArgs[w] {
+				i++
+			}
+		case i > 0 && w != "" && w[0] >= '0' && w[0] <= '9' && strings.Trim(w, "0123456789.smhd") == "":
+			// timeout's duration
+		default:
+			return w
+		}
+	}
+	return ""
+}
+
+// isCompound reports whether cmd uses shell syntax that can run more than
+// one program
+func isCompound(cmd string) bool {
+	return strings.ContainsAny(cmd, ";|&`\n") || strings.Contains(cmd, "$(") || strings.Contains(cmd, "<(") || strings.Contains(cmd, ">(")
+}
+
+// needsApproval reports whether the policy parks cmd until an admin
+// approves it: its program is one of approvalCmds, found behind paths,
+// quotes and prefixes like env or sudo. A compound command or one that
+// runs others (sh -c, xargs, ...) needs approval if any of its words names
+// one of approvalCmds. Caller must hold r.mu.
+func (r *RemoteShellService) needsApproval(cmd string) bool {
+	if len(r.approvalCmds) == 0 {
+		return false
+	}
+	name := commandName(cmd)
+	if _, ok := r.approvalCmds[name]; ok {
+		return true
+	}
+	if !isCompound(cmd) && !commandRunners[name] {
+		return false
+	}
+	words := strings.FieldsFunc(cmd, func(c rune) bool {
+		return unicode.IsSpace(c) || strings.ContainsRune(";|&()$`<>{}'\"", c)
+	})
+	for _, w := range words {
+		if _, ok := r.approvalCmds[shellWord(w)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitApproval parks req until an admin approves or rejects it, the wait
+// runs out or caller is cancelled, and returns the approving admin. shown
+// is the redacted command admins see. Caller must not hold r.mu.
+func (r *RemoteShellService) awaitApproval(caller context.Context, req protocol.CommandRequest, shown, workDir string) (string, error) {
+	wait := r.approvalTimeout
+	if req.ApprovalWait > 0 && req.ApprovalWait < wait {
+		wait = req.ApprovalWait
+	}
+	now := time.Now()
+	requester := r.identityFor(req.Token)
+
+	r.mu.Lock()
+	if r.draining {
+		r.mu.Unlock()
+		return "", errors.New("server shutting down")
+	}
+	r.nextApproval++
+	p := &pendingApproval{
+		info: protocol.PendingApproval{
+			ApprovalID:  r.nextApproval,
+			ClientID:    req.ID,
+			Requester:   requester,
+			Command:     shown,
+			WorkDir:     workDir,
+			RequestedAt: now,
+			Expires:     now.Add(wait),
+		},
+		decided: make(chan struct{}),
+	}
+	if r.approvals == nil {
+		r.approvals = make(map[int64]*pendingApproval)
+	}
+	r.approvals[p.info.ApprovalID] = p
+	r.mu.Unlock()
+
+	id := p.info.ApprovalID
+	r.audit.record(protocol.AuditEntry{Identity: requester, ClientID: req.ID, Action: "approval-request", Detail: fmt.Sprintf("#%d %s", id, shown), Outcome: "pending"})
+	r.events.publish(protocol.Event{Kind: "approval", ClientID: req.ID, Command: shown,
+		Message: fmt.Sprintf("command #%d waits for admin approval (up to %v)", id, wait)})
+	log.Printf("[Client %s] Command #%d waits for approval: %s", req.ID, id, shown)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-p.decided:
+	case <-timer.C:
+		r.decide(id, "", fmt.Sprintf("approval timed out after %v", wait))
+	case <-caller.Done():
+		r.decide(id, "", "Command cancelled: caller went away")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if p.approver == "" {
+		return "", errors.New(p.reason)
+	}
+	return p.approver, nil
+}
+
+// decide settles a pending command: approved by approver if it is set,
+// otherwise refused with reason. It reports false if the command was no
+// longer pending.
+func (r *RemoteShellService) decide(id int64, approver, reason string) (protocol.PendingApproval, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.decideLocked(id, approver, reason)
+}
+
+// decideLocked is decide for callers holding r.mu
+func (r *RemoteShellService) decideLocked(id int64, approver, reason string) (protocol.PendingApproval, bool) {
+	p, ok := r.approvals[id]
+	if !ok {
+		return protocol.PendingApproval{}, false
+	}
+	delete(r.approvals, id)
+	p.approver, p.reason = approver, reason
+	close(p.decided)
+	return p.info, true
+}
+
+// dropApprovals refuses every pending command, or those of one client if
+// clientID is set. Caller must hold r.mu.
+func (r *RemoteShellService) dropApprovals(clientID, reason string) {
+	for id, p := range r.approvals {
+		if clientID == "" || p.info.ClientID == clientID {
+			r.decideLocked(id, "", reason)
+		}
+	}
+}
+
+// ListApprovals returns the commands waiting for approval, oldest first
+// (admin only)
+func (r *RemoteShellService) ListApprovals(req protocol.ListRequest, resp *[]protocol.PendingApproval) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]protocol.PendingApproval, 0, len(r.approvals))
+	for _, p := range r.approvals {
+		out = append(out, p.info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ApprovalID < out[j].ApprovalID })
+	*resp = out
+	return nil
+}
+
+// ApproveCommand lets a pending command run (admin only). Approving a
+// command needs a second person: the admin's identity must differ from the
+// one that asked to run it.
+func (r *RemoteShellService) ApproveCommand(req protocol.ApprovalDecision, resp *protocol.PendingApproval) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	admin := r.identityFor(req.Token)
+	r.mu.Lock()
+	p, ok := r.approvals[req.ApprovalID]
+	if !ok {
+		r.mu.Unlock()
+		return fmt.Errorf("not found: no pending command #%d", req.ApprovalID)
+	}
+	if p.info.Requester == admin {
+		r.mu.Unlock()
+		r.audit.record(protocol.AuditEntry{Identity: admin, ClientID: p.info.ClientID, Action: "approve", Detail: fmt.Sprintf("#%d %s", req.ApprovalID, p.info.Command), Outcome: "rejected: own command"})
+		return fmt.Errorf("cannot approve your own command #%d", req.ApprovalID)
+	}
+	*resp, _ = r.decideLocked(req.ApprovalID, admin, "")
+	r.mu.Unlock()
+
+	r.audit.record(protocol.AuditEntry{Identity: admin, ClientID: resp.ClientID, Action: "approve", Detail: fmt.Sprintf("#%d %s", resp.ApprovalID, resp.Command), Outcome: "ok"})
+	r.events.publish(protocol.Event{Kind: "approval", ClientID: resp.ClientID, Command: resp.Command, Message: fmt.Sprintf("command #%d approved by %s", resp.ApprovalID, admin)})
+	log.Printf("[Admin] %s approved command #%d of %s: %s", admin, resp.ApprovalID, resp.ClientID, resp.Command)
+	return nil
+}
+
+// RejectCommand refuses a pending command (admin only); the client gets
+// the reason
+func (r *RemoteShellService) RejectCommand(req protocol.ApprovalDecision, resp *protocol.PendingApproval) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	admin := r.identityFor(req.Token)
+	reason := "command rejected by " + admin
+	if req.Reason != "" {
+		reason += ": " + req.Reason
+	}
+	info, ok := r.decide(req.ApprovalID, "", reason)
+	if !ok {
+		return fmt.Errorf("not found: no pending command #%d", req.ApprovalID)
+	}
+	*resp = info
+
+	detail := fmt.Sprintf("#%d %s", info.ApprovalID, info.Command)
+	if req.Reason != "" {
+		detail += " (" + req.Reason + ")"
+	}
+	r.audit.record(protocol.AuditEntry{Identity: admin, ClientID: info.ClientID, Action: "reject", Detail: detail, Outcome: "ok"})
+	r.events.publish(protocol.Event{Kind: "approval", ClientID: info.ClientID, Command: info.Command, Message: fmt.Sprintf("command #%d %s", info.ApprovalID, strings.TrimPrefix(reason, "command "))})
+	log.Printf("[Admin] %s rejected command #%d of %s: %s", admin, info.ApprovalID, info.ClientID, info.Command)
+	return nil
+}
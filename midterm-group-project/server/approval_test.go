@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+func TestNeedsApproval(t *testing.T) {
+	r := newTestService(t)
+	r.approvalCmds = map[string]struct{}{"rm": {}, "shutdown": {}}
+	tests := []struct {
+		cmd  string
+		want bool
+	}{
+		{"rm -rf /tmp/x", true},
+		{"/bin/rm x", true},
+		{`\rm x`, true},
+		{`"rm" x`, true},
+		{"env rm x", true},
+		{"env FOO=1 rm x", true},
+		{"FOO=1 rm x", true},
+		{"command rm x", true},
+		{"sudo rm x", true},
+		{"sudo -u root rm x", true},
+		{"nice -n 10 rm x", true},
+		{"nohup rm x &", true},
+		{"timeout 5s rm x", true},
+		{"xargs rm < list", true},
+		{"sh -c 'rm x'", true},
+		{"bash -c \"/usr/bin/rm x\"", true},
+		{"echo $(rm x)", true},
+		{"echo `rm x`", true},
+		{"true; rm x", true},
+		{"find . -exec rm {} +", true},
+		{"ls -l", false},
+		{"echo rm", false},
+		{"grep -r shutdown logs", false},
+		{"sudo ls", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := r.needsApproval(tt.cmd); got != tt.want {
+			t.Errorf("needsApproval(%q) = %v, want %v", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+// newApprovalService returns a service where "touch" needs approval by the
+// holder of token "admin", and a registered session "dev"
+func newApprovalService(t *testing.T) (*RemoteShellService, string) {
+	t.Helper()
+	r := newTestService(t)
+	r.adminToken = "admin"
+	r.approvalCmds = map[string]struct{}{"touch": {}}
+	reg := register(t, r, "dev", "")
+	if reg.Error != "" {
+		t.Fatalf("Register: %s", reg.Error)
+	}
+	return r, reg.Secret
+}
+
+// executeAsync runs command in session dev and returns the pending approval
+// it waits for along with a channel for its result
+func executeAsync(t *testing.T, r *RemoteShellService, secret, command string) (int64, <-chan protocol.CommandResponse) {
+	t.Helper()
+	result := make(chan protocol.CommandResponse, 1)
+	go func() {
+		var resp protocol.CommandResponse
+		r.Execute(protocol.CommandRequest{ID: "dev", Token: "tok", Secret: secret, Command: command}, &resp)
+		result <- resp
+	}()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		var pending []protocol.PendingApproval
+		if err := r.ListApprovals(protocol.ListRequest{Token: "admin"}, &pending); err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) > 0 {
+			return pending[0].ApprovalID, result
+		}
+	}
+	t.Fatal("command never waited for approval")
+	return 0, nil
+}
+
+func TestApproveCommand(t *testing.T) {
+	r, secret := newApprovalService(t)
+	file := t.TempDir() + "/approved"
+	id, result := executeAsync(t, r, secret, "touch "+file)
+
+	// The requester's own token is not an admin token
+	var info protocol.PendingApproval
+	if err := r.ApproveCommand(protocol.ApprovalDecision{Token: "tok", ApprovalID: id}, &info); err == nil {
+		t.Fatal("approved with the user token")
+	}
+	if err := r.ApproveCommand(protocol.ApprovalDecision{Token: "admin", ApprovalID: id}, &info); err != nil {
+		t.Fatalf("ApproveCommand: %v", err)
+	}
+	resp := <-result
+	if resp.ExitCode != 0 || resp.ApprovedBy == "" {
+		t.Fatalf("Execute = %+v, want it run after approval", resp)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("approved command did not run: %v", err)
+	}
+	if err := r.ApproveCommand(protocol.ApprovalDecision{Token: "admin", ApprovalID: id}, &info); err == nil {
+		t.Fatal("approved the same command twice")
+	}
+}
+
+func TestRejectCommand(t *testing.T) {
+	r, secret := newApprovalService(t)
+	id, result := executeAsync(t, r, secret, "touch "+t.TempDir()+"/rejected")
+
+	var info protocol.PendingApproval
+	if err := r.RejectCommand(protocol.ApprovalDecision{Token: "admin", ApprovalID: id, Reason: "not today"}, &info); err != nil {
+		t.Fatalf("RejectCommand: %v", err)
+	}
+	resp := <-result
+	if resp.ExitCode != -1 || !strings.Contains(resp.Error, "not today") {
+		t.Fatalf("Execute = exit %d %q, want the rejection", resp.ExitCode, resp.Error)
+	}
+}
+
+func TestApprovalTimesOut(t *testing.T) {
+	r, secret := newApprovalService(t)
+	r.approvalTimeout = 50 * time.Millisecond
+	var resp protocol.CommandResponse
+	r.Execute(protocol.CommandRequest{ID: "dev", Token: "tok", Secret: secret, Command: "touch " + t.TempDir() + "/late"}, &resp)
+	if resp.ExitCode != -1 || !strings.Contains(resp.Error, "approval timed out") {
+		t.Fatalf("Execute = exit %d %q, want a timeout", resp.ExitCode, resp.Error)
+	}
+	var pending []protocol.PendingApproval
+	r.ListApprovals(protocol.ListRequest{Token: "admin"}, &pending)
+	if len(pending) != 0 {
+		t.Fatalf("approvals left after the timeout: %+v", pending)
+	}
+}
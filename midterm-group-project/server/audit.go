@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// auditLog keeps the last size entries in memory and, if a file is set,
+// appends every entry to it as a JSON line. Secrets in Detail and Outcome
+// are redacted before an entry is kept.
+type auditLog struct {
+	redact  *redactor
+	mu      sync.Mutex
+	size    int
+	entries []protocol.AuditEntry // Oldest first
+	nextSeq int64
+	file    *os.File
+	enc     *json.Encoder
+}
+
+func newAuditLog(size int) *auditLog {
+	return &auditLog{size: size}
+}
+
+// openFile appends entries to path from now on
+func (a *auditLog) openFile(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.file = f
+	a.enc = json.NewEncoder(f)
+	return nil
+}
+
+func (a *auditLog) record(e protocol.AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nextSeq++
+	e.Seq = a.nextSeq
+	e.Detail = a.redact.text(e.ClientID, e.Detail)
+	e.Outcome = a.redact.text(e.ClientID, e.Outcome)
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if a.size > 0 {
+		a.entries = append(a.entries, e)
+		if over := len(a.entries) - a.size; over > 0 {
+			a.entries = append(a.entries[:0:0], a.entries[over:]...)
+		}
+	}
+	if a.enc != nil {
+		if err := a.enc.Encode(e); err != nil {
+			log.Printf("[Audit] Write failed: %v", err)
+		}
+	}
+}
+
+// recent returns up to limit of the newest entries (0 = all kept), oldest
+// first, restricted to clientID if it is set
+func (a *auditLog) recent(clientID string, limit int) []protocol.AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []protocol.AuditEntry
+	for _, e := range a.entries {
+		if clientID == "" || e.ClientID == clientID {
+			out = append(out, e)
+		}
+	}
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out
+}
+
+// close flushes and closes the audit file
+func (a *auditLog) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return
+	}
+	if err := a.file.Sync(); err != nil {
+		log.Printf("[Audit] Sync failed: %v", err)
+	}
+	a.file.Close()
+	a.file, a.enc = nil, nil
+}
+
+// auditExec records the outcome of an Execute call, including calls that
+// were refused before the command ran
+func (r *RemoteShellService) auditExec(req protocol.CommandRequest, resp *protocol.CommandResponse, ran bool) {
+	outcome := fmt.Sprintf("exit=%d", resp.ExitCode)
+	switch {
+	case resp.Replayed:
+		outcome = "replayed " + outcome
+	case !ran:
+		outcome = "rejected: " + resp.Error
+	case resp.ExitCode == -1 && resp.Error != "":
+		outcome += ": " + resp.Error
+	}
+	if resp.ApprovedBy != "" {
+		outcome += " (approved by " + resp.ApprovedBy + ")"
+	}
+	r.audit.record(protocol.AuditEntry{
+		Identity: r.identityFor(req.Token),
+		ClientID: req.ID,
+		Action:   "exec",
+		Detail:   r.redact.text(req.ID, req.Command),
+		Outcome:  outcome,
+	})
+}
+
+// AuditLog returns recent audit entries (admin only)
+func (r *RemoteShellService) AuditLog(req protocol.AuditRequest, resp *[]protocol.AuditEntry) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	*resp = r.audit.recent(req.ClientID, req.Limit)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+)
+
+// newSessionSecret returns an unguessable secret handed out by Register
+func newSessionSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// identityFor names the authenticated principal behind an auth token. The
+// token itself is never stored; sessions keep a short fingerprint of it.
+// Tokens minted for front-end users (SSH) map to those users instead.
+func (r *RemoteShellService) identityFor(token string) string {
+	if identity, ok := r.principals[token]; ok {
+		return identity
+	}
+	if token == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:6])
+}
+
+// authorizeSession checks that a call on session comes from its owner: the
+// session secret must match and the auth token must map to the identity the
+// session was registered with. Mismatches are logged as hijack attempts.
+// Caller must hold r.mu.
+func (r *RemoteShellService) authorizeSession(session *Session, token, secret, op string) bool {
+	identity := r.identityFor(token)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(session.secret)) == 1 && identity == session.Owner {
+		return true
+	}
+	reason := "bad session secret"
+	if secret == "" {
+		reason = "missing session secret"
+	} else if identity != session.Owner {
+		reason = "identity mismatch"
+	}
+	r.metrics.deny("session")
+	log.Printf("[Security] Possible session hijack: %s on session %s by %s (%s)", op, session.ID, identity, reason)
+	return false
+}
+
+// validateAdmin checks a token for admin-only RPCs. Without a separate
+// --admin-token every authenticated caller is an admin, as before.
+func (r *RemoteShellService) validateAdmin(token string) bool {
+	if !r.adminTokenOK(token) {
+		r.metrics.deny("auth")
+		return false
+	}
+	return true
+}
+
+// adminTokenOK is validateAdmin without counting a denial
+func (r *RemoteShellService) adminTokenOK(token string) bool {
+	if r.adminToken == "" {
+		return r.authTokenOK(token)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(r.adminToken)) == 1
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// callTracker counts RPC calls that have been read but not yet answered
+type callTracker struct {
+	mu     sync.Mutex
+	active int
+	idle   chan struct{} // Closed when active drops to zero, nil if nobody waits
+}
+
+func (t *callTracker) begin() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active++
+}
+
+func (t *callTracker) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	if t.active == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+func (t *callTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// wait blocks until no calls are in flight or timeout passes and reports
+// whether the tracker went idle
+func (t *callTracker) wait(timeout time.Duration) bool {
+	t.mu.Lock()
+	if t.active == 0 {
+		t.mu.Unlock()
+		return true
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// trackedServerCodec is the gob codec rpc.ServeConn uses, counting each call
+// from the moment its header is read until its response has been written.
+// That lets shutdown wait for replies to reach clients, not just for the
+// methods to return.
+type trackedServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	calls  []*callTracker
+	closed bool
+}
+
+func newTrackedServerCodec(conn io.ReadWriteCloser, calls ...*callTracker) *trackedServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &trackedServerCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+		calls:  calls,
+	}
+}
+
+func (c *trackedServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.dec.Decode(r); err != nil {
+		return err
+	}
+	// net/rpc sends exactly one response for every header it reads
+	for _, t := range c.calls {
+		t.begin()
+	}
+	return nil
+}
+
+func (c *trackedServerCodec) ReadRequestBody(body interface{}) error {
+	if err := c.dec.Decode(body); err != nil {
+		return err
+	}
+	if b, ok := body.(connBinder); ok {
+		if conn, ok := c.rwc.(*idleConn); ok {
+			b.bindConn(conn)
+		}
+	}
+	return nil
+}
+
+func (c *trackedServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	defer func() {
+		for _, t := range c.calls {
+			t.end()
+		}
+	}()
+	if err := c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *trackedServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connLimit caps the connections served at once, over every listener that
+// takes one; nil means no limit
+type connLimit chan struct{}
+
+func newConnLimit(n int) connLimit {
+	if n <= 0 {
+		return nil
+	}
+	return make(connLimit, n)
+}
+
+// acquire takes a slot, reporting false if all are in use
+func (l connLimit) acquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l connLimit) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// idleConn closes a connection that has seen no traffic for timeout. Every
+// read or write pushes the deadline out, and a connection waiting for a
+// long-running command of its own is never considered idle. It also counts
+// traffic so sessions can report the connections serving them.
+type idleConn struct {
+	net.Conn
+	timeout     time.Duration // 0 = never time out
+	lastSeen    atomic.Int64  // UnixNano of the last read or write
+	calls       callTracker   // This connection's calls awaiting a response
+	connectedAt time.Time
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+	session     string // Client ID registered over this connection (guarded by service mu)
+
+	mu     sync.Mutex
+	reason string // Why the connection ended, first cause wins
+}
+
+func newIdleConn(conn net.Conn, timeout time.Duration) *idleConn {
+	c := &idleConn{Conn: conn, timeout: timeout, connectedAt: time.Now()}
+	c.touch()
+	return c
+}
+
+// tlsIdentity describes the transport security of the connection: "plain",
+// "tls", or "tls:<CN>" when the client presented a verified certificate
+func (c *idleConn) tlsIdentity() string {
+	tc, ok := c.Conn.(*tls.Conn)
+	if !ok {
+		return "plain"
+	}
+	state := tc.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return "tls"
+	}
+	return "tls:" + state.PeerCertificates[0].Subject.CommonName
+}
+
+// closeWithReason closes the connection, recording reason for the log
+func (c *idleConn) closeWithReason(reason string) {
+	c.setReason(reason)
+	c.Close()
+}
+
+func (c *idleConn) touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (c *idleConn) idleFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastSeen.Load()))
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	for {
+		if c.timeout > 0 {
+			wait := c.timeout - c.idleFor()
+			if wait <= 0 || c.calls.count() > 0 {
+				wait = c.timeout
+			}
+			c.Conn.SetReadDeadline(time.Now().Add(wait))
+		}
+		n, err := c.Conn.Read(p)
+		if n > 0 {
+			c.touch()
+			c.bytesIn.Add(int64(n))
+		}
+		var netErr net.Error
+		if n == 0 && errors.As(err, &netErr) && netErr.Timeout() {
+			// Writes count as activity too, and a command still running
+			// for this connection keeps it alive
+			if c.idleFor() < c.timeout || c.calls.count() > 0 {
+				continue
+			}
+			c.setReason(fmt.Sprintf("idle for %v", c.timeout))
+			return n, err
+		}
+		if err != nil {
+			c.setReason(readErrorReason(err))
+		}
+		return n, err
+	}
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.touch()
+		c.bytesOut.Add(int64(n))
+	}
+	if err != nil {
+		c.setReason("write failed: " + err.Error())
+	}
+	return n, err
+}
+
+// setReason records why the connection ended unless a cause is already known
+func (c *idleConn) setReason(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reason == "" {
+		c.reason = reason
+	}
+}
+
+// disconnectReason describes why the connection ended, for the log
+func (c *idleConn) disconnectReason() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reason == "" {
+		return "closed by server"
+	}
+	return c.reason
+}
+
+func readErrorReason(err error) string {
+	switch {
+	case err == io.EOF:
+		return "closed by client"
+	case errors.Is(err, net.ErrClosed):
+		return "closed by server"
+	default:
+		return "read failed: " + err.Error()
+	}
+}
+
+// callContext is embedded in requests whose handler needs the connection
+// the call arrived on. The codec fills it in after decoding; being
+// unexported it is never sent over the wire.
+type callContext struct {
+	conn *idleConn
+}
+
+func (c *callContext) bindConn(conn *idleConn) {
+	c.conn = conn
+}
+
+type connBinder interface {
+	bindConn(conn *idleConn)
+}
+
+// attachConn records that conn serves session, moving it off any session it
+// was registered for before. Caller must hold r.mu.
+func (r *RemoteShellService) attachConn(session *Session, conn *idleConn) {
+	if conn == nil {
+		return
+	}
+	if prev, ok := r.sessions[conn.session]; ok && prev != session {
+		delete(prev.conns, conn)
+	}
+	if session.conns == nil {
+		session.conns = make(map[*idleConn]struct{})
+	}
+	session.conns[conn] = struct{}{}
+	conn.session = session.ID
+}
+
+// detachConn forgets a closed connection
+func (r *RemoteShellService) detachConn(conn *idleConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if session, ok := r.sessions[conn.session]; ok {
+		delete(session.conns, conn)
+	}
+}
+
+// connFrom formats the origin of a call for log lines
+func connFrom(conn *idleConn) string {
+	if conn == nil {
+		return ""
+	}
+	return fmt.Sprintf(" from %s (%s)", conn.RemoteAddr(), conn.tlsIdentity())
+}
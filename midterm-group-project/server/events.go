@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+const (
+	eventBacklog = 1000             // Events kept for pollers that fall behind
+	maxPollWait  = 25 * time.Second // Longest a poll call is held open
+	watchGrace   = 10 * time.Second // Output keeps streaming this long after a shadow poll returns
+)
+
+// eventHub fans events out to long-polling readers. Publishing closes the
+// current wake channel so every waiting poller re-checks the backlog.
+type eventHub struct {
+	mu      sync.Mutex
+	events  []protocol.Event // Oldest first, bounded by eventBacklog
+	nextSeq int64
+	wake    chan struct{}
+	closed  bool
+	watched map[string]time.Time // Client ID -> shadowed until
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{wake: make(chan struct{}), watched: make(map[string]time.Time)}
+}
+
+func (h *eventHub) publish(e protocol.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.nextSeq++
+	e.Seq = h.nextSeq
+	e.Time = time.Now()
+	h.events = append(h.events, e)
+	if over := len(h.events) - eventBacklog; over > 0 {
+		h.events = append(h.events[:0:0], h.events[over:]...)
+	}
+	close(h.wake)
+	h.wake = make(chan struct{})
+}
+
+// close wakes every poller and makes further polls return immediately
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.closed {
+		h.closed = true
+		close(h.wake)
+	}
+}
+
+// watch marks clientID as shadowed so its command output is published
+func (h *eventHub) watch(clientID string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.watched[clientID] = time.Now().Add(d)
+}
+
+func (h *eventHub) isWatched(clientID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	until, ok := h.watched[clientID]
+	if ok && time.Now().After(until) {
+		delete(h.watched, clientID)
+		return false
+	}
+	return ok
+}
+
+// poll returns the events after seq that match, waiting up to wait for the
+// first one or until ctx is done
+func (h *eventHub) poll(ctx context.Context, after int64, wait time.Duration, match func(protocol.Event) bool) protocol.PollResponse {
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	for {
+		h.mu.Lock()
+		if after < 0 {
+			after = h.nextSeq
+		}
+		resp := protocol.PollResponse{Next: after, Closed: h.closed}
+		if len(h.events) > 0 && h.events[0].Seq > after+1 {
+			resp.Missed = h.events[0].Seq - after - 1
+		}
+		for _, e := range h.events {
+			if e.Seq > after {
+				resp.Next = e.Seq
+				if match(e) {
+					resp.Events = append(resp.Events, e)
+				}
+			}
+		}
+		wake := h.wake
+		h.mu.Unlock()
+
+		if len(resp.Events) > 0 || resp.Missed > 0 || resp.Closed {
+			return resp
+		}
+		after = resp.Next
+		select {
+		case <-wake:
+		case <-deadline.C:
+			return resp
+		case <-ctx.Done():
+			return resp
+		}
+	}
+}
+
+// outputWriter publishes command output for a shadowed session as it is
+// produced, up to limit bytes per command
+type outputWriter struct {
+	hub      *eventHub
+	clientID string
+	limit    int
+	written  int
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	if !w.hub.isWatched(w.clientID) || (w.limit > 0 && w.written >= w.limit) {
+		return len(p), nil
+	}
+	chunk := p
+	if w.limit > 0 && w.written+len(chunk) > w.limit {
+		chunk = chunk[:w.limit-w.written]
+	}
+	w.written += len(chunk)
+	w.hub.publish(protocol.Event{Kind: "output", ClientID: w.clientID, Output: string(chunk)})
+	return len(p), nil
+}
+
+// cappedWriter passes up to limit bytes on to w. Write errors (a stream
+// whose reader went away) are swallowed so the command's output is still
+// collected in full.
+type cappedWriter struct {
+	w       io.Writer
+	limit   int
+	written int
+	failed  bool
+}
+
+func (w *cappedWriter) Write(p []byte) (int, error) {
+	if w.failed || (w.limit > 0 && w.written >= w.limit) {
+		return len(p), nil
+	}
+	chunk := p
+	if w.limit > 0 && w.written+len(chunk) > w.limit {
+		chunk = chunk[:w.limit-w.written]
+	}
+	w.written += len(chunk)
+	if _, err := w.w.Write(chunk); err != nil {
+		w.failed = true
+	}
+	return len(p), nil
+}
+
+func pollWait(d time.Duration) time.Duration {
+	if d <= 0 || d > maxPollWait {
+		return maxPollWait
+	}
+	return d
+}
+
+// PollEvents delivers broadcasts, and news about the session's commands
+// waiting for approval, to a client session
+func (r *RemoteShellService) PollEvents(req protocol.PollRequest, resp *protocol.PollResponse) error {
+	r.pollEvents(context.Background(), req, resp)
+	return nil
+}
+
+// pollEvents is PollEvents for callers in the server, which stop waiting
+// when ctx is done
+func (r *RemoteShellService) pollEvents(ctx context.Context, req protocol.PollRequest, resp *protocol.PollResponse) {
+	if !r.validateToken(req.Token) {
+		resp.Error = "unauthorized"
+		return
+	}
+	if r.isBanned(req.ID) {
+		resp.Error = "banned"
+		return
+	}
+	r.mu.Lock()
+	session, exists := r.sessions[req.ID]
+	if !exists {
+		r.mu.Unlock()
+		resp.Error = "client not registered"
+		return
+	}
+	if !r.authorizeSession(session, req.Token, req.Secret, "PollEvents") {
+		r.mu.Unlock()
+		resp.Error = "invalid session secret"
+		return
+	}
+	session.LastActive = time.Now()
+	r.mu.Unlock()
+
+	*resp = r.events.poll(ctx, req.After, pollWait(req.Wait), func(e protocol.Event) bool {
+		return e.Kind == "broadcast" || ((e.Kind == "session-end" || e.Kind == "approval") && e.ClientID == req.ID)
+	})
+}
+
+// WatchSession streams another session's commands and output to an admin,
+// read-only (admin only). The session is shadowed while the admin keeps
+// polling.
+func (r *RemoteShellService) WatchSession(req protocol.PollRequest, resp *protocol.PollResponse) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	r.mu.RLock()
+	_, exists := r.sessions[req.ID]
+	r.mu.RUnlock()
+	if !exists && req.After < 0 {
+		return fmt.Errorf("not found: no session %s", req.ID)
+	}
+	if req.After < 0 {
+		r.audit.record(protocol.AuditEntry{Identity: r.identityFor(req.Token), ClientID: req.ID, Action: "shadow", Outcome: "ok"})
+		log.Printf("[Admin] Shadowing session %s", req.ID)
+	}
+
+	wait := pollWait(req.Wait)
+	r.events.watch(req.ID, wait+watchGrace)
+	*resp = r.events.poll(context.Background(), req.After, wait, func(e protocol.Event) bool {
+		return e.ClientID == req.ID || e.Kind == "broadcast"
+	})
+	return nil
+}
+
+// Broadcast sends a message to every connected interactive client and
+// returns the number of sessions (admin only)
+func (r *RemoteShellService) Broadcast(req protocol.BroadcastRequest, resp *int) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	if req.Message == "" {
+		return fmt.Errorf("empty message")
+	}
+	r.events.publish(protocol.Event{Kind: "broadcast", Message: req.Message})
+	r.audit.record(protocol.AuditEntry{Identity: r.identityFor(req.Token), Action: "broadcast", Detail: req.Message, Outcome: "ok"})
+	log.Printf("[Admin] Broadcast: %s", req.Message)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	*resp = len(r.sessions)
+	return nil
+}
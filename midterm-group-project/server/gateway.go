@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+
+	"remote-shell-rpc/protocol"
+)
+
+// gatewayMaxBody bounds JSON request bodies: an upload chunk of
+// MaxChunkSize grows by a third as base64
+const gatewayMaxBody = 2 * protocol.MaxChunkSize
+
+// gatewayMethod runs one RemoteShellService operation for the HTTP gateway
+type gatewayMethod func(ctx context.Context, body []byte, token, secret string) (interface{}, error)
+
+// gateway exposes RemoteShellService as JSON over HTTP, plus WebSocket
+// endpoints for interactive sessions and shadowing. Requests and replies are
+// the protocol types as JSON; like gRPC, every call goes through the net/rpc
+// method so auth, limits, validation and auditing are shared.
+type gateway struct {
+	svc     *RemoteShellService
+	methods map[string]gatewayMethod
+}
+
+var wsUpgrader = websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+
+// wsInput is a message from a WebSocket client. The first names the session
+// and may carry the credentials, for browsers that cannot set headers.
+type wsInput struct {
+	ID     string
+	Token  string
+	Secret string
+	Line   string
+}
+
+// wsOutput is a message to a WebSocket client; one field is set
+type wsOutput struct {
+	Output string                    `json:",omitempty"`
+	Result *protocol.CommandResponse `json:",omitempty"`
+	Event  *protocol.Event           `json:",omitempty"`
+}
+
+func newGateway(r *RemoteShellService) *gateway {
+	g := &gateway{svc: r}
+	g.methods = map[string]gatewayMethod{
+		"Hello":       gatewayCall(r.Hello),
+		"Register":    gatewayCall(r.Register),
+		"Heartbeat":   gatewayCall(r.Heartbeat),
+		"EndSession":  gatewayCall(r.EndSession),
+		"Execute":     g.execute,
+		"SetEnv":      gatewayCall(r.SetEnv),
+		"UnsetEnv":    gatewayCall(r.UnsetEnv),
+		"GetEnv":      gatewayCall(r.GetEnv),
+		"ListEnv":     gatewayCall(r.ListEnv),
+		"ChangeDir":   gatewayCall(r.ChangeDir),
+		"History":     gatewayCall(r.History),
+		"SessionInfo": gatewayCall(r.GetSessionInfoV2),
+		"Upload":      gatewayCall(r.Upload),
+		"Download":    gatewayCall(r.Download),
+		"FetchOutput": gatewayCall(r.FetchOutput),
+		"UploadStdin": gatewayCall(r.UploadStdin),
+
+		"ListClients":  gatewayCall(r.ListClients),
+		"ListSessions": gatewayCall(r.ListSessionsV2),
+		"KillSession": gatewayCall(func(req protocol.KillSessionRequest, resp *string) error {
+			r.KillSession(req, resp)
+			if *resp == "unauthorized" || *resp == "not found" {
+				return errors.New(*resp)
+			}
+			return nil
+		}),
+		"BanClient":           gatewayCall(r.BanClient),
+		"ListBanned":          gatewayCall(r.ListBanned),
+		"AddToWhitelist":      gatewayCall(r.AddToWhitelist),
+		"RemoveFromWhitelist": gatewayCall(r.RemoveFromWhitelist),
+		"ListWhitelist":       gatewayCall(r.ListWhitelist),
+		"AuditLog":            gatewayCall(r.AuditLog),
+		"ListJobs":            gatewayCall(r.ListJobs),
+		"ListApprovals":       gatewayCall(r.ListApprovals),
+		"ApproveCommand":      gatewayCall(r.ApproveCommand),
+		"RejectCommand":       gatewayCall(r.RejectCommand),
+		"ReadRecording":       gatewayCall(r.ReadRecording),
+		"Top":                 gatewayCall(r.Top),
+		"Broadcast":           gatewayCall(r.Broadcast),
+	}
+	return g
+}
+
+// startGateway serves the gateway on addr in the background, with TLS if
+// config is set
+func startGateway(addr string, config *tls.Config, service *RemoteShellService) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	g := newGateway(service)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/", g.serveCall)
+	mux.HandleFunc("/v1/ws/shell", g.serveShell)
+	mux.HandleFunc("/v1/ws/watch", g.serveWatch)
+	srv := &http.Server{Addr: addr, Handler: mux, TLSConfig: config}
+	go func() {
+		var err error
+		if config != nil {
+			err = srv.ServeTLS(listener, "", "")
+		} else {
+			err = srv.Serve(listener)
+		}
+		if err != http.ErrServerClosed {
+			log.Printf("HTTP gateway %s stopped: %v", addr, err)
+		}
+	}()
+	log.Printf("HTTP gateway available at %s/v1/", addr)
+	return srv, nil
+}
+
+// gatewayCall adapts a RemoteShellService method to the gateway. Refusals
+// the method reports in its reply ("Error: ..." strings, Error fields)
+// become errors.
+func gatewayCall[Req, Resp any](method func(Req, *Resp) error) gatewayMethod {
+	return func(ctx context.Context, body []byte, token, secret string) (interface{}, error) {
+		var req Req
+		if err := decodeRequest(body, &req, token, secret); err != nil {
+			return nil, err
+		}
+		var resp Resp
+		if err := method(req, &resp); err != nil {
+			return nil, err
+		}
+		if err := replyError(resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// execute is Execute with the request's context, so the command is killed
+// if the caller hangs up
+func (g *gateway) execute(ctx context.Context, body []byte, token, secret string) (interface{}, error) {
+	var req protocol.CommandRequest
+	if err := decodeRequest(body, &req, token, secret); err != nil {
+		return nil, err
+	}
+	var resp protocol.CommandResponse
+	if !g.svc.execute(ctx, req, nil, &resp) && !resp.Replayed {
+		return nil, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// decodeRequest unmarshals body into req and fills its Token and Secret
+// fields from the headers if the body left them empty
+func decodeRequest(body []byte, req interface{}, token, secret string) error {
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, req); err != nil {
+			return fmt.Errorf("invalid request body: %v", err)
+		}
+	}
+	v := reflect.ValueOf(req).Elem()
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	for name, value := range map[string]string{"Token": token, "Secret": secret} {
+		if f := v.FieldByName(name); f.IsValid() && f.Kind() == reflect.String && f.String() == "" {
+			f.SetString(value)
+		}
+	}
+	return nil
+}
+
+// replyError finds a refusal in a reply
+func replyError(resp interface{}) error {
+	if s, ok := resp.(string); ok {
+		return protocol.ReplyError(s)
+	}
+	v := reflect.ValueOf(resp)
+	if v.Kind() == reflect.Struct {
+		if f := v.FieldByName("Error"); f.IsValid() && f.Kind() == reflect.String && f.String() != "" {
+			return errors.New(f.String())
+		}
+	}
+	return nil
+}
+
+// httpCredentials reads the auth token and session secret from the headers
+func httpCredentials(req *http.Request) (token, secret string) {
+	token = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return token, req.Header.Get("X-Session-Secret")
+}
+
+// httpStatusFor maps a refusal to an HTTP status
+func httpStatusFor(err error) int {
+	switch refusalCode(err) {
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusRequestTimeout
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"Error": msg})
+}
+
+// serveCall handles POST /v1/<Method>
+func (g *gateway) serveCall(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/v1/")
+	method, ok := g.methods[name]
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("no method %s", name))
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, gatewayMaxBody))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	token, secret := httpCredentials(req)
+
+	g.svc.calls.begin()
+	defer g.svc.calls.end()
+	resp, err := method(req.Context(), body, token, secret)
+	if err != nil {
+		writeJSONError(w, httpStatusFor(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// wsFirst upgrades req and reads the first message, taking credentials
+// from it if it has them and from the headers otherwise
+func wsFirst(w http.ResponseWriter, req *http.Request) (*websocket.Conn, wsInput, bool) {
+	conn, err := wsUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return nil, wsInput{}, false // Upgrade has replied
+	}
+	var first wsInput
+	if err := conn.ReadJSON(&first); err != nil {
+		conn.Close()
+		return nil, wsInput{}, false
+	}
+	token, secret := httpCredentials(req)
+	if first.Token == "" {
+		first.Token = token
+	}
+	if first.Secret == "" {
+		first.Secret = secret
+	}
+	return conn, first, true
+}
+
+// wsClose ends a WebSocket with a close code for err: 1000 when the stream
+// finished, 1001 on shutdown and 4000 plus the HTTP status for refusals
+func wsClose(conn *websocket.Conn, err error) {
+	code, text := websocket.CloseNormalClosure, ""
+	switch {
+	case errors.Is(err, errShuttingDown):
+		code, text = websocket.CloseGoingAway, err.Error()
+	case err != nil && !errors.Is(err, context.Canceled):
+		code, text = 4000+httpStatusFor(err), err.Error()
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// serveShell is an interactive session over WebSocket: each message's Line
+// runs as a command; see runShell
+func (g *gateway) serveShell(w http.ResponseWriter, req *http.Request) {
+	conn, first, ok := wsFirst(w, req)
+	if !ok {
+		return
+	}
+	// A hijacked connection does not cancel the request context, so a
+	// failed read does
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	firstRead := false
+	recv := func() (string, error) {
+		if !firstRead {
+			firstRead = true
+			return first.Line, nil
+		}
+		var in wsInput
+		if err := conn.ReadJSON(&in); err != nil {
+			cancel()
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return "", io.EOF
+			}
+			return "", err
+		}
+		return in.Line, nil
+	}
+	err := g.svc.runShell(ctx, "WebSocket", first.ID, first.Token, first.Secret, recv, wsStream{conn})
+	wsClose(conn, err)
+}
+
+// wsStream sends an interactive session's replies as wsOutput messages
+type wsStream struct {
+	conn *websocket.Conn
+}
+
+func (s wsStream) sendOutput(p []byte) error {
+	return s.conn.WriteJSON(wsOutput{Output: string(p)})
+}
+
+// sendResult leaves out Output, which has already been streamed
+func (s wsStream) sendResult(resp protocol.CommandResponse) error {
+	resp.Output = ""
+	return s.conn.WriteJSON(wsOutput{Result: &resp})
+}
+
+func (s wsStream) sendEvent(e protocol.Event) error {
+	return s.conn.WriteJSON(wsOutput{Event: &e})
+}
+
+// serveWatch streams a session's events to an admin over WebSocket until
+// the session ends
+func (g *gateway) serveWatch(w http.ResponseWriter, req *http.Request) {
+	conn, first, ok := wsFirst(w, req)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	go func() {
+		// Nothing more is expected from the admin; reading notices a hang up
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+	err := g.svc.watchStream(ctx, first.ID, first.Token, func(e protocol.Event) error {
+		return conn.WriteJSON(wsOutput{Event: &e})
+	})
+	wsClose(conn, err)
+}
@@ -0,0 +1,652 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"remote-shell-rpc/protocol"
+	"remote-shell-rpc/protocol/shellpb"
+)
+
+// grpcShell serves RemoteShellService over gRPC. Every call goes through the
+// net/rpc method of the same name, so auth, rate limits, validation and
+// auditing are shared; only the credentials (from metadata) and the error
+// reporting (status codes) differ.
+type grpcShell struct {
+	shellpb.UnimplementedRemoteShellServer
+	svc *RemoteShellService
+}
+
+// startGRPCServer serves the gRPC transport on addr in the background, with
+// TLS if config is set
+func startGRPCServer(addr string, config *tls.Config, service *RemoteShellService) (*grpc.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	shell := &grpcShell{svc: service}
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(shell.trackUnary)}
+	if config != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	srv := grpc.NewServer(opts...)
+	shellpb.RegisterRemoteShellServer(srv, shell)
+	go func() {
+		if err := srv.Serve(listener); err != nil {
+			log.Printf("gRPC listener %s stopped: %v", addr, err)
+		}
+	}()
+	log.Printf("gRPC available at %s", addr)
+	return srv, nil
+}
+
+// stopGRPCServer lets open streams finish, then cuts off any that do not
+func stopGRPCServer(srv *grpc.Server) {
+	if srv == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		srv.Stop()
+	}
+}
+
+// trackUnary counts unary calls so shutdown waits for their replies. Streams
+// count each command they run instead.
+func (g *grpcShell) trackUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	g.svc.calls.begin()
+	defer g.svc.calls.end()
+	return handler(ctx, req)
+}
+
+// grpcCredentials reads the auth token and session secret from metadata
+func grpcCredentials(ctx context.Context) (token, secret string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("authorization"); len(v) > 0 {
+		token = strings.TrimPrefix(v[0], "Bearer ")
+	}
+	if v := md.Get("x-session-secret"); len(v) > 0 {
+		secret = v[0]
+	}
+	return token, secret
+}
+
+// statusFor turns a refusal from the service into a gRPC status
+func statusFor(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(refusalCode(err), err.Error())
+}
+
+// refusalCode classifies the service's refusals, which are plain messages
+func refusalCode(err error) codes.Code {
+	var verr *protocol.VersionError
+	if errors.As(err, &verr) {
+		return codes.FailedPrecondition
+	}
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "unauthorized"):
+		return codes.Unauthenticated
+	case msg == "banned", msg == "invalid session secret", msg == "command not allowed", strings.Contains(msg, "already in use"),
+		strings.HasPrefix(msg, "command rejected by"), strings.HasPrefix(msg, "cannot approve"):
+		return codes.PermissionDenied
+	case strings.HasPrefix(msg, "approval timed out"):
+		return codes.DeadlineExceeded
+	case msg == "rate limit exceeded":
+		return codes.ResourceExhausted
+	case strings.Contains(msg, "not registered"), strings.Contains(msg, "not found"), msg == "session ended":
+		return codes.NotFound
+	case msg == "server shutting down":
+		return codes.Unavailable
+	}
+	return codes.InvalidArgument
+}
+
+// replyStatus is statusFor for the RPCs that put their error in the reply
+func replyStatus(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	return statusFor(errors.New(msg))
+}
+
+func (g *grpcShell) Hello(ctx context.Context, req *shellpb.HelloRequest) (*shellpb.HelloResponse, error) {
+	var resp protocol.HelloResponse
+	err := g.svc.Hello(protocol.HelloRequest{Version: int(req.Version), MinVersion: int(req.MinVersion), Program: req.Program}, &resp)
+	if err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.HelloResponse{Version: int32(resp.Version), MinVersion: int32(resp.MinVersion)}, nil
+}
+
+// Session calls
+
+func (g *grpcShell) Register(ctx context.Context, req *shellpb.RegisterRequest) (*shellpb.RegisterResponse, error) {
+	token, secret := grpcCredentials(ctx)
+	var resp protocol.RegisterResponse
+	if err := g.svc.Register(RegisterRequest{ID: req.Id, Token: token, Secret: secret}, &resp); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := replyStatus(resp.Error); err != nil {
+		return nil, err
+	}
+	return &shellpb.RegisterResponse{Message: resp.Message, Secret: resp.Secret, Resumed: resp.Resumed}, nil
+}
+
+func (g *grpcShell) Heartbeat(ctx context.Context, req *shellpb.SessionRef) (*emptypb.Empty, error) {
+	token, secret := grpcCredentials(ctx)
+	var reply string
+	g.svc.Heartbeat(protocol.HeartbeatRequest{ID: req.Id, Token: token, Secret: secret}, &reply)
+	if err := protocol.ReplyError(reply); err != nil {
+		return nil, statusFor(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (g *grpcShell) EndSession(ctx context.Context, req *shellpb.SessionRef) (*emptypb.Empty, error) {
+	token, secret := grpcCredentials(ctx)
+	var reply string
+	g.svc.EndSession(protocol.EndSessionRequest{ID: req.Id, Token: token, Secret: secret}, &reply)
+	if err := protocol.ReplyError(reply); err != nil {
+		return nil, statusFor(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Execute streams output as the command produces it. Requests refused
+// before the command started end with a status instead of a result.
+func (g *grpcShell) Execute(req *shellpb.CommandRequest, stream shellpb.RemoteShell_ExecuteServer) error {
+	g.svc.calls.begin()
+	defer g.svc.calls.end()
+
+	token, secret := grpcCredentials(stream.Context())
+	out := sendFunc(func(p []byte) error {
+		return stream.Send(&shellpb.ExecuteReply{Kind: &shellpb.ExecuteReply_Output{Output: p}})
+	})
+	var resp protocol.CommandResponse
+	creq := protocol.CommandRequest{ID: req.Id, Token: token, Secret: secret, Command: req.Command, RequestID: req.RequestId,
+		ApprovalWait: req.ApprovalWait.AsDuration(), Stdin: req.Stdin, StdinHandle: req.StdinHandle}
+	if !g.svc.execute(stream.Context(), creq, out, &resp) && !resp.Replayed {
+		return replyStatus(resp.Error)
+	}
+	if resp.Replayed && resp.Output != "" {
+		if _, err := out.Write([]byte(resp.Output)); err != nil {
+			return err
+		}
+	}
+	return stream.Send(&shellpb.ExecuteReply{Kind: &shellpb.ExecuteReply_Result{Result: commandResult(resp)}})
+}
+
+// Shell runs lines from the client one at a time; see runShell
+func (g *grpcShell) Shell(stream shellpb.RemoteShell_ShellServer) error {
+	token, secret := grpcCredentials(stream.Context())
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	firstRead := false
+	recv := func() (string, error) {
+		if !firstRead {
+			firstRead = true
+			return first.Line, nil
+		}
+		in, err := stream.Recv()
+		if err != nil {
+			return "", err
+		}
+		return in.Line, nil
+	}
+	return statusFor(g.svc.runShell(stream.Context(), "gRPC", first.Id, token, secret, recv, grpcShellStream{stream}))
+}
+
+// grpcShellStream sends a Shell stream's replies
+type grpcShellStream struct {
+	stream shellpb.RemoteShell_ShellServer
+}
+
+func (s grpcShellStream) sendOutput(p []byte) error {
+	return s.stream.Send(&shellpb.ShellOutput{Kind: &shellpb.ShellOutput_Output{Output: p}})
+}
+
+func (s grpcShellStream) sendResult(resp protocol.CommandResponse) error {
+	return s.stream.Send(&shellpb.ShellOutput{Kind: &shellpb.ShellOutput_Result{Result: commandResult(resp)}})
+}
+
+func (s grpcShellStream) sendEvent(e protocol.Event) error {
+	return s.stream.Send(&shellpb.ShellOutput{Kind: &shellpb.ShellOutput_Event{Event: pbEvent(e)}})
+}
+
+func (g *grpcShell) SetEnv(ctx context.Context, req *shellpb.EnvRequest) (*shellpb.StatusReply, error) {
+	token, secret := grpcCredentials(ctx)
+	var reply string
+	g.svc.SetEnv(protocol.EnvRequest{ID: req.Id, Token: token, Secret: secret, Key: req.Key, Value: req.Value, Sensitive: req.Sensitive}, &reply)
+	if err := protocol.ReplyError(reply); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.StatusReply{Message: reply}, nil
+}
+
+func (g *grpcShell) UnsetEnv(ctx context.Context, req *shellpb.EnvKeyRequest) (*shellpb.StatusReply, error) {
+	token, secret := grpcCredentials(ctx)
+	var reply string
+	g.svc.UnsetEnv(protocol.EnvKeyRequest{ID: req.Id, Token: token, Secret: secret, Key: req.Key}, &reply)
+	if err := protocol.ReplyError(reply); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.StatusReply{Message: reply}, nil
+}
+
+func (g *grpcShell) GetEnv(ctx context.Context, req *shellpb.EnvKeyRequest) (*shellpb.EnvValue, error) {
+	token, secret := grpcCredentials(ctx)
+	var resp protocol.EnvValueResponse
+	g.svc.GetEnv(protocol.EnvKeyRequest{ID: req.Id, Token: token, Secret: secret, Key: req.Key}, &resp)
+	if err := replyStatus(resp.Error); err != nil {
+		return nil, err
+	}
+	return &shellpb.EnvValue{Key: resp.Key, Value: resp.Value, Exists: resp.Exists, Sensitive: resp.Sensitive}, nil
+}
+
+func (g *grpcShell) ListEnv(ctx context.Context, req *shellpb.SessionRef) (*shellpb.EnvList, error) {
+	token, secret := grpcCredentials(ctx)
+	var vars map[string]string
+	if err := g.svc.ListEnv(protocol.ListEnvRequest{ID: req.Id, Token: token, Secret: secret}, &vars); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.EnvList{Vars: vars}, nil
+}
+
+func (g *grpcShell) ChangeDir(ctx context.Context, req *shellpb.DirRequest) (*shellpb.StatusReply, error) {
+	token, secret := grpcCredentials(ctx)
+	var reply string
+	g.svc.ChangeDir(protocol.DirRequest{ID: req.Id, Token: token, Secret: secret, Dir: req.Dir}, &reply)
+	if err := protocol.ReplyError(reply); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.StatusReply{Message: reply}, nil
+}
+
+func (g *grpcShell) History(ctx context.Context, req *shellpb.HistoryRequest) (*shellpb.HistoryReply, error) {
+	token, secret := grpcCredentials(ctx)
+	var entries []protocol.HistoryEntry
+	if err := g.svc.History(protocol.HistoryRequest{ID: req.Id, Token: token, Secret: secret, Limit: int(req.Limit)}, &entries); err != nil {
+		return nil, statusFor(err)
+	}
+	reply := &shellpb.HistoryReply{}
+	for _, e := range entries {
+		reply.Entries = append(reply.Entries, &shellpb.HistoryEntry{
+			Seq:       int32(e.Seq),
+			Command:   e.Command,
+			StartedAt: pbTime(e.StartedAt),
+			Duration:  durationpb.New(e.Duration),
+			ExitCode:  int32(e.ExitCode),
+		})
+	}
+	return reply, nil
+}
+
+// SessionInfo describes the caller's session, or any session for an admin
+// calling without a session secret
+func (g *grpcShell) SessionInfo(ctx context.Context, req *shellpb.SessionRef) (*shellpb.Session, error) {
+	token, secret := grpcCredentials(ctx)
+	var info protocol.SessionInfo
+	if err := g.svc.GetSessionInfoV2(protocol.SessionInfoRequest{ID: req.Id, Token: token, Secret: secret}, &info); err != nil {
+		return nil, statusFor(err)
+	}
+	return pbSession(info), nil
+}
+
+func (g *grpcShell) Upload(ctx context.Context, req *shellpb.UploadRequest) (*shellpb.UploadReply, error) {
+	token, secret := grpcCredentials(ctx)
+	var resp protocol.UploadResponse
+	err := g.svc.Upload(protocol.UploadRequest{
+		ID:       req.Id,
+		Token:    token,
+		Secret:   secret,
+		Path:     req.Path,
+		Offset:   req.Offset,
+		Data:     req.Data,
+		Final:    req.Final,
+		Checksum: req.Checksum,
+		Mode:     os.FileMode(req.Mode),
+	}, &resp)
+	if err == nil {
+		err = replyStatus(resp.Error)
+	}
+	if err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.UploadReply{Written: resp.Written, Path: resp.Path}, nil
+}
+
+func (g *grpcShell) Download(ctx context.Context, req *shellpb.DownloadRequest) (*shellpb.DownloadReply, error) {
+	token, secret := grpcCredentials(ctx)
+	var resp protocol.DownloadResponse
+	err := g.svc.Download(protocol.DownloadRequest{ID: req.Id, Token: token, Secret: secret, Path: req.Path, Offset: req.Offset, Length: int(req.Length)}, &resp)
+	if err == nil {
+		err = replyStatus(resp.Error)
+	}
+	if err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.DownloadReply{Data: resp.Data, Size: resp.Size, Eof: resp.EOF, Checksum: resp.Checksum, Mode: uint32(resp.Mode)}, nil
+}
+
+func (g *grpcShell) FetchOutput(ctx context.Context, req *shellpb.FetchOutputRequest) (*shellpb.OutputChunk, error) {
+	token, secret := grpcCredentials(ctx)
+	var resp protocol.OutputChunk
+	g.svc.FetchOutput(protocol.FetchOutputRequest{ID: req.Id, Token: token, Secret: secret, Handle: req.Handle, Offset: req.Offset, Length: int(req.Length)}, &resp)
+	if err := replyStatus(resp.Error); err != nil {
+		return nil, err
+	}
+	return &shellpb.OutputChunk{Data: resp.Data, Size: resp.Size, Eof: resp.EOF}, nil
+}
+
+func (g *grpcShell) UploadStdin(ctx context.Context, req *shellpb.StdinChunk) (*shellpb.StdinUploadReply, error) {
+	token, secret := grpcCredentials(ctx)
+	var resp protocol.StdinUploadResponse
+	g.svc.UploadStdin(protocol.StdinChunk{ID: req.Id, Token: token, Secret: secret, Handle: req.Handle, Offset: req.Offset, Data: req.Data}, &resp)
+	if err := replyStatus(resp.Error); err != nil {
+		return nil, err
+	}
+	return &shellpb.StdinUploadReply{Handle: resp.Handle, Written: resp.Written}, nil
+}
+
+// Admin calls
+
+func (g *grpcShell) ListClients(ctx context.Context, _ *emptypb.Empty) (*shellpb.StringList, error) {
+	token, _ := grpcCredentials(ctx)
+	var ids []string
+	if err := g.svc.ListClients(protocol.ListRequest{Token: token}, &ids); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.StringList{Values: ids}, nil
+}
+
+func (g *grpcShell) ListSessions(ctx context.Context, _ *emptypb.Empty) (*shellpb.SessionList, error) {
+	token, _ := grpcCredentials(ctx)
+	var list protocol.SessionList
+	if err := g.svc.ListSessionsV2(protocol.ListSessionsRequest{Token: token}, &list); err != nil {
+		return nil, statusFor(err)
+	}
+	reply := &shellpb.SessionList{Version: int32(list.Version)}
+	for _, info := range list.Sessions {
+		reply.Sessions = append(reply.Sessions, pbSession(info))
+	}
+	return reply, nil
+}
+
+func (g *grpcShell) KillSession(ctx context.Context, req *shellpb.SessionRef) (*shellpb.StatusReply, error) {
+	token, _ := grpcCredentials(ctx)
+	var reply string
+	g.svc.KillSession(protocol.KillSessionRequest{ID: req.Id, Token: token}, &reply)
+	if reply == "unauthorized" || reply == "not found" {
+		return nil, replyStatus(reply)
+	}
+	return &shellpb.StatusReply{Message: reply}, nil
+}
+
+func (g *grpcShell) BanClient(ctx context.Context, req *shellpb.BanRequest) (*shellpb.StringList, error) {
+	token, _ := grpcCredentials(ctx)
+	var banned []string
+	if err := g.svc.BanClient(protocol.BanRequest{Token: token, IDs: req.Ids, Lift: req.Lift}, &banned); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.StringList{Values: banned}, nil
+}
+
+func (g *grpcShell) ListBanned(ctx context.Context, _ *emptypb.Empty) (*shellpb.StringList, error) {
+	token, _ := grpcCredentials(ctx)
+	var banned []string
+	if err := g.svc.ListBanned(protocol.ListRequest{Token: token}, &banned); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.StringList{Values: banned}, nil
+}
+
+func (g *grpcShell) AddToWhitelist(ctx context.Context, req *shellpb.WhitelistRequest) (*shellpb.StringList, error) {
+	token, _ := grpcCredentials(ctx)
+	var cmds []string
+	if err := g.svc.AddToWhitelist(protocol.UpdateWhitelistRequest{Token: token, Commands: req.Commands}, &cmds); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.StringList{Values: cmds}, nil
+}
+
+func (g *grpcShell) RemoveFromWhitelist(ctx context.Context, req *shellpb.WhitelistRequest) (*shellpb.StringList, error) {
+	token, _ := grpcCredentials(ctx)
+	var cmds []string
+	if err := g.svc.RemoveFromWhitelist(protocol.UpdateWhitelistRequest{Token: token, Commands: req.Commands}, &cmds); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.StringList{Values: cmds}, nil
+}
+
+func (g *grpcShell) ListWhitelist(ctx context.Context, _ *emptypb.Empty) (*shellpb.StringList, error) {
+	token, _ := grpcCredentials(ctx)
+	var cmds []string
+	if err := g.svc.ListWhitelist(protocol.ListRequest{Token: token}, &cmds); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.StringList{Values: cmds}, nil
+}
+
+func (g *grpcShell) AuditLog(ctx context.Context, req *shellpb.AuditRequest) (*shellpb.AuditReply, error) {
+	token, _ := grpcCredentials(ctx)
+	var entries []protocol.AuditEntry
+	if err := g.svc.AuditLog(protocol.AuditRequest{Token: token, ClientID: req.ClientId, Limit: int(req.Limit)}, &entries); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.AuditReply{Entries: pbAuditEntries(entries)}, nil
+}
+
+func (g *grpcShell) ListJobs(ctx context.Context, _ *emptypb.Empty) (*shellpb.JobList, error) {
+	token, _ := grpcCredentials(ctx)
+	var jobs []protocol.JobInfo
+	if err := g.svc.ListJobs(protocol.ListRequest{Token: token}, &jobs); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.JobList{Jobs: pbJobs(jobs)}, nil
+}
+
+func (g *grpcShell) ListApprovals(ctx context.Context, _ *emptypb.Empty) (*shellpb.ApprovalList, error) {
+	token, _ := grpcCredentials(ctx)
+	var pending []protocol.PendingApproval
+	if err := g.svc.ListApprovals(protocol.ListRequest{Token: token}, &pending); err != nil {
+		return nil, statusFor(err)
+	}
+	reply := &shellpb.ApprovalList{}
+	for _, p := range pending {
+		reply.Approvals = append(reply.Approvals, pbApproval(p))
+	}
+	return reply, nil
+}
+
+func (g *grpcShell) ApproveCommand(ctx context.Context, req *shellpb.ApprovalDecision) (*shellpb.PendingApproval, error) {
+	token, _ := grpcCredentials(ctx)
+	var p protocol.PendingApproval
+	if err := g.svc.ApproveCommand(protocol.ApprovalDecision{Token: token, ApprovalID: req.ApprovalId, Reason: req.Reason}, &p); err != nil {
+		return nil, statusFor(err)
+	}
+	return pbApproval(p), nil
+}
+
+func (g *grpcShell) RejectCommand(ctx context.Context, req *shellpb.ApprovalDecision) (*shellpb.PendingApproval, error) {
+	token, _ := grpcCredentials(ctx)
+	var p protocol.PendingApproval
+	if err := g.svc.RejectCommand(protocol.ApprovalDecision{Token: token, ApprovalID: req.ApprovalId, Reason: req.Reason}, &p); err != nil {
+		return nil, statusFor(err)
+	}
+	return pbApproval(p), nil
+}
+
+func (g *grpcShell) ReadRecording(ctx context.Context, req *shellpb.RecordingRequest) (*shellpb.RecordingChunk, error) {
+	token, _ := grpcCredentials(ctx)
+	var chunk protocol.RecordingChunk
+	if err := g.svc.ReadRecording(protocol.RecordingRequest{Token: token, ID: req.Id, Offset: req.Offset, Length: int(req.Length)}, &chunk); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.RecordingChunk{Name: chunk.Name, Data: chunk.Data, Size: chunk.Size, Eof: chunk.EOF}, nil
+}
+
+func (g *grpcShell) Top(ctx context.Context, _ *emptypb.Empty) (*shellpb.TopSnapshot, error) {
+	token, _ := grpcCredentials(ctx)
+	var snap protocol.TopSnapshot
+	if err := g.svc.Top(protocol.ListRequest{Token: token}, &snap); err != nil {
+		return nil, statusFor(err)
+	}
+	reply := &shellpb.TopSnapshot{
+		Time:        pbTime(snap.Time),
+		Sessions:    int32(snap.Sessions),
+		Connections: int32(snap.Connections),
+		Jobs:        pbJobs(snap.Jobs),
+		Denials:     pbAuditEntries(snap.Denials),
+		DenialTotal: snap.DenialTotal,
+	}
+	for _, c := range snap.Clients {
+		reply.Clients = append(reply.Clients, &shellpb.ClientActivity{
+			ClientId:        c.ClientID,
+			RequestsLastMin: int32(c.RequestsLastMin),
+			Running:         int32(c.Running),
+			Connected:       c.Connected,
+			RemoteAddr:      c.RemoteAddr,
+			LastActive:      pbTime(c.LastActive),
+		})
+	}
+	return reply, nil
+}
+
+// WatchSession streams a session's events until it ends, the server shuts
+// down or the admin hangs up
+func (g *grpcShell) WatchSession(req *shellpb.SessionRef, stream shellpb.RemoteShell_WatchSessionServer) error {
+	token, _ := grpcCredentials(stream.Context())
+	return statusFor(g.svc.watchStream(stream.Context(), req.Id, token, func(e protocol.Event) error {
+		return stream.Send(pbEvent(e))
+	}))
+}
+
+func (g *grpcShell) Broadcast(ctx context.Context, req *shellpb.BroadcastRequest) (*shellpb.BroadcastReply, error) {
+	token, _ := grpcCredentials(ctx)
+	var n int
+	if err := g.svc.Broadcast(protocol.BroadcastRequest{Token: token, Message: req.Message}, &n); err != nil {
+		return nil, statusFor(err)
+	}
+	return &shellpb.BroadcastReply{Sessions: int32(n)}, nil
+}
+
+// Conversions to the protobuf types
+
+// pbTime leaves unset times unset rather than sending year 1
+func pbTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func commandResult(resp protocol.CommandResponse) *shellpb.CommandResult {
+	return &shellpb.CommandResult{ExitCode: int32(resp.ExitCode), Error: resp.Error, Replayed: resp.Replayed, ApprovedBy: resp.ApprovedBy,
+		OutputSize: resp.OutputSize, OutputHandle: resp.OutputHandle}
+}
+
+func pbEvent(e protocol.Event) *shellpb.Event {
+	return &shellpb.Event{
+		Seq:      e.Seq,
+		Time:     pbTime(e.Time),
+		Kind:     e.Kind,
+		ClientId: e.ClientID,
+		Command:  e.Command,
+		Output:   []byte(e.Output),
+		ExitCode: int32(e.ExitCode),
+		Message:  e.Message,
+	}
+}
+
+func pbSession(info protocol.SessionInfo) *shellpb.Session {
+	s := &shellpb.Session{
+		Version:     int32(info.Version),
+		Id:          info.ID,
+		Owner:       info.Owner,
+		WorkDir:     info.WorkDir,
+		EnvCount:    int32(info.EnvCount),
+		ConnectedAt: pbTime(info.ConnectedAt),
+		LastActive:  pbTime(info.LastActive),
+		Active:      info.Active,
+		Commands:    int32(info.Commands),
+		Running:     int32(info.Running),
+	}
+	for _, c := range info.Connections {
+		s.Connections = append(s.Connections, &shellpb.Connection{
+			RemoteAddr:  c.RemoteAddr,
+			TlsIdentity: c.TLSIdentity,
+			ConnectedAt: pbTime(c.ConnectedAt),
+			BytesIn:     c.BytesIn,
+			BytesOut:    c.BytesOut,
+		})
+	}
+	return s
+}
+
+func pbJobs(jobs []protocol.JobInfo) []*shellpb.Job {
+	var out []*shellpb.Job
+	for _, j := range jobs {
+		out = append(out, &shellpb.Job{JobId: j.JobID, ClientId: j.ClientID, Command: j.Command, WorkDir: j.WorkDir, StartedAt: pbTime(j.StartedAt)})
+	}
+	return out
+}
+
+func pbApproval(p protocol.PendingApproval) *shellpb.PendingApproval {
+	return &shellpb.PendingApproval{
+		ApprovalId:  p.ApprovalID,
+		ClientId:    p.ClientID,
+		Requester:   p.Requester,
+		Command:     p.Command,
+		WorkDir:     p.WorkDir,
+		RequestedAt: pbTime(p.RequestedAt),
+		Expires:     pbTime(p.Expires),
+	}
+}
+
+func pbAuditEntries(entries []protocol.AuditEntry) []*shellpb.AuditEntry {
+	var out []*shellpb.AuditEntry
+	for _, e := range entries {
+		out = append(out, &shellpb.AuditEntry{
+			Seq:      e.Seq,
+			Time:     pbTime(e.Time),
+			Identity: e.Identity,
+			ClientId: e.ClientID,
+			Action:   e.Action,
+			Detail:   e.Detail,
+			Outcome:  e.Outcome,
+		})
+	}
+	return out
+}
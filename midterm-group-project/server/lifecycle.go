@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// killGrace is how long drain waits for replies after killing commands that
+// outlived the shutdown timeout
+const killGrace = 5 * time.Second
+
+// isDraining reports whether shutdown has started
+func (r *RemoteShellService) isDraining() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.draining
+}
+
+// setReady marks the service as accepting connections
+func (r *RemoteShellService) setReady() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ready = true
+}
+
+// beginShutdown makes the service refuse new sessions and commands and
+// report itself not ready, and tells clients. Commands waiting for approval
+// are refused; calls already running are unaffected.
+func (r *RemoteShellService) beginShutdown() {
+	r.mu.Lock()
+	already := r.draining
+	r.draining = true
+	r.dropApprovals("", "server shutting down")
+	r.mu.Unlock()
+	if !already {
+		r.events.publish(protocol.Event{Kind: "broadcast", Message: "server is shutting down"})
+		r.events.close()
+	}
+}
+
+// drain waits up to timeout for in-flight calls to be answered. Commands
+// still running after that are killed so their callers get a reply. It then
+// stops the cleanup goroutine, flushes the audit log and logs the state of
+// the remaining sessions, closing their recordings.
+func (r *RemoteShellService) drain(timeout time.Duration) {
+	r.beginShutdown()
+
+	if n := r.calls.count(); n > 0 {
+		log.Printf("[Shutdown] Waiting up to %v for %d in-flight calls", timeout, n)
+	}
+	if !r.calls.wait(timeout) {
+		log.Printf("[Shutdown] %d calls still running after %v, killing their commands", r.calls.count(), timeout)
+		r.killRunning()
+		if !r.calls.wait(killGrace) {
+			log.Printf("[Shutdown] Giving up on %d calls", r.calls.count())
+		}
+	}
+	r.killRunning()
+	close(r.stopCleanup)
+	r.audit.close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, session := range r.sessions {
+		session.recorder.close()
+		session.dropSpools()
+		log.Printf("[Shutdown] Dropping session %s (owner %s, %d commands, idle %v)",
+			id, session.Owner, session.nextSeq, time.Since(session.LastActive).Round(time.Second))
+	}
+	log.Printf("[Shutdown] Drained, %d sessions dropped", len(r.sessions))
+}
+
+// serveHealthz is the liveness check: it fails if the session lock cannot be
+// taken, i.e. the service is wedged and should be restarted
+func (r *RemoteShellService) serveHealthz(w http.ResponseWriter, req *http.Request) {
+	locked := make(chan struct{})
+	go func() {
+		r.mu.RLock()
+		r.mu.RUnlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		fmt.Fprintln(w, "ok")
+	case <-time.After(2 * time.Second):
+		http.Error(w, "session lock unavailable", http.StatusServiceUnavailable)
+	}
+}
+
+// serveReadyz is the readiness check: it fails until the RPC listener is up
+// and again once shutdown has started, so load balancers stop routing new
+// clients here while running commands drain
+func (r *RemoteShellService) serveReadyz(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	ready, draining := r.ready, r.draining
+	r.mu.RUnlock()
+	switch {
+	case draining:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	case !ready:
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintln(w, "ready")
+	}
+}
+
+// startHTTPServers serves /metrics on metricsAddr and /healthz and /readyz
+// on healthAddr in the background. Either may be empty; if both name the
+// same address they share one listener.
+func startHTTPServers(metricsAddr, healthAddr string, service *RemoteShellService) []*http.Server {
+	muxes := make(map[string]*http.ServeMux)
+	mux := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+	if metricsAddr != "" {
+		mux(metricsAddr).HandleFunc("/metrics", service.serveMetrics)
+		log.Printf("Metrics available at http://%s/metrics", metricsAddr)
+	}
+	if healthAddr != "" {
+		m := mux(healthAddr)
+		m.HandleFunc("/healthz", service.serveHealthz)
+		m.HandleFunc("/readyz", service.serveReadyz)
+		log.Printf("Health checks available at http://%s/healthz and /readyz", healthAddr)
+	}
+
+	var servers []*http.Server
+	for addr, m := range muxes {
+		srv := &http.Server{Addr: addr, Handler: m}
+		servers = append(servers, srv)
+		go func(srv *http.Server) {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("HTTP listener %s stopped: %v", srv.Addr, err)
+			}
+		}(srv)
+	}
+	return servers
+}
+
+// stopHTTPServers shuts the HTTP listeners down, letting a last scrape or
+// probe finish
+func stopHTTPServers(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(ctx)
+	}
+}
@@ -1,82 +1,39 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/rpc"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
-)
-
-// CommandRequest represents a command execution request
-type CommandRequest struct {
-	Command string
-	Args    []string
-	ID      string // Client ID for tracking
-	Token   string // Auth token
-}
 
-// CommandResponse represents the result of command execution
-type CommandResponse struct {
-	Output   string
-	Error    string
-	ExitCode int
-	ID       string
-}
+	"google.golang.org/grpc"
 
-// HeartbeatRequest for keepalive
-type HeartbeatRequest struct {
-	ID    string
-	Token string
-}
+	"remote-shell-rpc/protocol"
+)
 
-// RegisterRequest for registering client
+// RegisterRequest is protocol.RegisterRequest plus the connection it arrived
+// on, which the codec fills in. Gob matches fields by name, so the exported
+// fields must stay the same as protocol.RegisterRequest's.
 type RegisterRequest struct {
-	ID    string
-	Token string
-}
-
-// EnvRequest for set env
-type EnvRequest struct {
-	ID    string
-	Token string
-	Key   string
-	Value string
-}
-
-// DirRequest for change directory
-type DirRequest struct {
-	ID    string
-	Token string
-	Dir   string
-}
-
-// ListRequest for listing clients
-type ListRequest struct {
-	Token string
-}
-
-type ListSessionsRequest struct {
-	Token string
-}
-
-type KillSessionRequest struct {
-	ID    string
-	Token string
-}
-
-// UpdateWhitelistRequest for dynamic whitelist changes
-type UpdateWhitelistRequest struct {
-	Token    string
-	Commands []string
+	ID     string
+	Token  string
+	Secret string
+	callContext
 }
 
 // RemoteShellService is the RPC service for remote shell execution
@@ -88,14 +45,51 @@ type RemoteShellService struct {
 
 	// Security / limits
 	authToken     string
+	adminToken    string            // Separate token for admin RPCs (empty = authToken)
+	principals    map[string]string // Tokens minted in-process for SSH users -> identity (set before serving)
 	allowedCmds   map[string]struct{}
 	rateLimit     int
 	rateWindow    time.Duration
+	rateMu        sync.Mutex // Guards rateCounters, which are checked outside mu
 	rateCounters  map[string]*rateInfo
+	requestMeters map[string]*requestMeter // Requests per client over the last minute (guarded by rateMu)
 	maxRuntime    time.Duration
-	maxOutput     int
+	maxOutput     int    // Output returned with a response; the rest is spooled for FetchOutput
+	maxSpool      int64  // Max output spooled per command (0 = unlimited)
+	spoolDir      string // Where spooled output goes (empty = system temp dir)
 	blockChaining bool
 	banned        map[string]struct{} // Banned client IDs
+	connLimit     connLimit           // Shared by the RPC and SSH listeners (--max-connections)
+	idleTimeout   time.Duration       // Connections idle this long are closed (0 = never)
+
+	historySize int           // Max history entries kept per session
+	dedupWindow time.Duration // How long Execute results are kept for retried RequestIDs
+	recordDir   string        // If set, every session is recorded to an asciicast file here
+
+	metrics *serverMetrics
+	audit   *auditLog
+	events  *eventHub
+	redact  *redactor // Hides secrets in output, audit entries and recordings
+
+	jobs    map[int64]protocol.JobInfo // Running commands by job ID (guarded by mu)
+	nextJob int64
+
+	// Approval
+	approvalCmds    map[string]struct{}        // Commands parked until an admin approves them
+	approvalTimeout time.Duration              // Longest a command waits for a decision
+	approvals       map[int64]*pendingApproval // Commands waiting by approval ID (guarded by mu)
+	nextApproval    int64
+
+	// Lifecycle
+	ready       bool               // RPC listener is up (guarded by mu)
+	draining    bool               // Shutdown started, new work refused (guarded by mu)
+	calls       *callTracker       // RPC calls awaiting a response
+	runCtx      context.Context    // Parent of every command's context
+	killRunning context.CancelFunc // Kills all running commands
+
+	// File transfer
+	maxTransfer  int64  // Max file size for Upload/Download (0 = unlimited)
+	transferRoot string // If set, transfers are confined below this directory instead of the session WorkDir
 }
 
 type rateInfo struct {
@@ -106,28 +100,104 @@ type rateInfo struct {
 // Session tracks a client session
 type Session struct {
 	ID          string
+	Owner       string // Identity that registered the session
+	secret      string // Issued by Register, required on every call
 	Env         map[string]string
 	WorkDir     string
 	ConnectedAt time.Time
 	LastActive  time.Time
+	History     []protocol.HistoryEntry // Oldest first, bounded by historySize
+	nextSeq     int
+
+	execResults map[string]execResult        // Completed Execute results by RequestID
+	inflight    map[string]chan struct{}     // Running Execute calls by RequestID, closed when done
+	running     map[int64]context.CancelFunc // Kill switches of running commands by job ID
+	conns       map[*idleConn]struct{}       // Live connections that registered this session
+	recorder    *recorder                    // Nil unless sessions are recorded
+	spools      []spooledOutput              // Full output of recent commands whose response was cut, oldest first
+	stdins      map[string]string            // Files from UploadStdin not yet used by a command, by handle
+	nextSpool   int
+}
+
+// execResult is a stored Execute response used to answer retried requests
+type execResult struct {
+	resp   protocol.CommandResponse
+	stored time.Time
+}
+
+// storeExecResult remembers resp for requestID and drops results older than
+// window. Caller must hold r.mu.
+func (s *Session) storeExecResult(requestID string, resp protocol.CommandResponse, window time.Duration) {
+	if requestID == "" || window <= 0 {
+		return
+	}
+	now := time.Now()
+	if s.execResults == nil {
+		s.execResults = make(map[string]execResult)
+	}
+	for id, res := range s.execResults {
+		if now.Sub(res.stored) > window {
+			delete(s.execResults, id)
+		}
+	}
+	s.execResults[requestID] = execResult{resp: resp, stored: now}
+}
+
+// lookupExecResult returns the stored response for requestID if it is still
+// within window. Caller must hold r.mu.
+func (s *Session) lookupExecResult(requestID string, window time.Duration) (protocol.CommandResponse, bool) {
+	if requestID == "" {
+		return protocol.CommandResponse{}, false
+	}
+	res, ok := s.execResults[requestID]
+	if !ok || time.Since(res.stored) > window {
+		return protocol.CommandResponse{}, false
+	}
+	return res.resp, true
+}
+
+// recordHistory appends a command to the session history, dropping the
+// oldest entries once the limit is reached. Caller must hold r.mu.
+func (s *Session) recordHistory(entry protocol.HistoryEntry, limit int) {
+	if limit <= 0 {
+		return
+	}
+	s.nextSeq++
+	entry.Seq = s.nextSeq
+	s.History = append(s.History, entry)
+	if over := len(s.History) - limit; over > 0 {
+		s.History = append(s.History[:0:0], s.History[over:]...)
+	}
 }
 
 // NewRemoteShellService creates a new remote shell service
 func NewRemoteShellService(authToken string, allowedCmds map[string]struct{}, rateLimit int, rateWindow time.Duration, maxRuntime time.Duration, maxOutput int, blockChaining bool) *RemoteShellService {
 	service := &RemoteShellService{
-		sessions:       make(map[string]*Session),
-		sessionTimeout: 30 * time.Minute, // 30 minutes timeout
-		stopCleanup:    make(chan bool),
-		authToken:      authToken,
-		allowedCmds:    allowedCmds,
-		rateLimit:      rateLimit,
-		rateWindow:     rateWindow,
-		rateCounters:   make(map[string]*rateInfo),
-		maxRuntime:     maxRuntime,
-		maxOutput:      maxOutput,
-		blockChaining:  blockChaining,
-		banned:         make(map[string]struct{}),
-	}
+		sessions:        make(map[string]*Session),
+		sessionTimeout:  30 * time.Minute, // 30 minutes timeout
+		stopCleanup:     make(chan bool),
+		authToken:       authToken,
+		principals:      make(map[string]string),
+		approvalTimeout: defaultApprovalTimeout,
+		allowedCmds:     allowedCmds,
+		rateLimit:       rateLimit,
+		rateWindow:      rateWindow,
+		rateCounters:    make(map[string]*rateInfo),
+		maxRuntime:      maxRuntime,
+		maxOutput:       maxOutput,
+		blockChaining:   blockChaining,
+		banned:          make(map[string]struct{}),
+		historySize:     100,
+		dedupWindow:     10 * time.Minute,
+		metrics:         newServerMetrics(),
+		audit:           newAuditLog(1000),
+		events:          newEventHub(),
+		redact:          &redactor{},
+		maxTransfer:     100 * 1024 * 1024,
+		calls:           &callTracker{},
+	}
+	service.audit.redact = service.redact
+	service.runCtx, service.killRunning = context.WithCancel(context.Background())
 	// Start background cleanup goroutine
 	go service.cleanupInactiveSessions()
 	return service
@@ -146,10 +216,11 @@ func (r *RemoteShellService) cleanupInactiveSessions() {
 			for id, session := range r.sessions {
 				if now.Sub(session.LastActive) > r.sessionTimeout {
 					log.Printf("[Cleanup] Removing inactive session: %s (inactive for %v)", id, now.Sub(session.LastActive))
-					delete(r.sessions, id)
+					r.endSession(session, "expired")
 				}
 			}
 			r.mu.Unlock()
+			r.pruneRates()
 		case <-r.stopCleanup:
 			return
 		}
@@ -157,7 +228,7 @@ func (r *RemoteShellService) cleanupInactiveSessions() {
 }
 
 // Heartbeat updates the last active time for a client (for keepalive)
-func (r *RemoteShellService) Heartbeat(req HeartbeatRequest, resp *string) error {
+func (r *RemoteShellService) Heartbeat(req protocol.HeartbeatRequest, resp *string) error {
 	if !r.validateToken(req.Token) {
 		*resp = "Error: unauthorized"
 		return nil
@@ -175,93 +246,261 @@ func (r *RemoteShellService) Heartbeat(req HeartbeatRequest, resp *string) error
 		*resp = "Error: client not registered"
 		return nil
 	}
+	if !r.authorizeSession(session, req.Token, req.Secret, "Heartbeat") {
+		*resp = "Error: invalid session secret"
+		return nil
+	}
 
 	session.LastActive = time.Now()
 	*resp = "OK"
 	return nil
 }
 
-// GetSessionInfo returns information about a client session
-func (r *RemoteShellService) GetSessionInfo(clientID string, resp *map[string]interface{}) error {
-	// Kept without token for backward compatibility; can be secured similarly if needed
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+// EndSession ends the caller's own session, so the next run of a client
+// with the same ID starts a fresh one instead of being refused until this
+// one expires
+func (r *RemoteShellService) EndSession(req protocol.EndSessionRequest, resp *string) error {
+	if !r.validateToken(req.Token) {
+		*resp = "Error: unauthorized"
+		return nil
+	}
 
-	session, exists := r.sessions[clientID]
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, exists := r.sessions[req.ID]
 	if !exists {
-		*resp = map[string]interface{}{
-			"error": "Session not found",
-		}
+		*resp = "Error: client not registered"
 		return nil
 	}
+	if !r.authorizeSession(session, req.Token, req.Secret, "EndSession") {
+		*resp = "Error: invalid session secret"
+		return nil
+	}
+
+	r.endSession(session, "ended by client")
+	r.audit.record(protocol.AuditEntry{Identity: session.Owner, ClientID: req.ID, Action: "end-session", Outcome: "ok"})
+	log.Printf("[Client %s] Ended session", req.ID)
+	*resp = "OK"
+	return nil
+}
 
-	*resp = map[string]interface{}{
-		"id":           session.ID,
-		"work_dir":     session.WorkDir,
-		"connected_at": session.ConnectedAt.Format(time.RFC3339),
-		"last_active":  session.LastActive.Format(time.RFC3339),
-		"env_count":    len(session.Env),
-		"is_active":    time.Since(session.LastActive) < r.sessionTimeout,
+// endSession removes a session and frees what it holds: its running
+// commands, recording, spooled output, secrets and commands waiting for
+// approval. Caller must hold r.mu.
+func (r *RemoteShellService) endSession(session *Session, why string) {
+	delete(r.sessions, session.ID)
+	for jobID, cancel := range session.running {
+		cancel()
+		delete(r.jobs, jobID)
+	}
+	session.recorder.close()
+	session.dropSpools()
+	r.redact.dropSession(session.ID)
+	r.dropApprovals(session.ID, "session ended")
+	r.events.publish(protocol.Event{Kind: "session-end", ClientID: session.ID, Message: why})
+}
+
+// Hello is the version handshake. It refuses clients whose protocol
+// version this server cannot talk to, so they fail with a clear error
+// instead of odd gob decoding results later.
+func (r *RemoteShellService) Hello(req protocol.HelloRequest, resp *protocol.HelloResponse) error {
+	program := req.Program
+	if program == "" {
+		program = "client"
+	}
+	if err := protocol.Compatible("server", program, req.Version, req.MinVersion); err != nil {
+		log.Printf("[Hello] Refused: %v", err)
+		return err
 	}
+	*resp = protocol.HelloResponse{Version: protocol.Version, MinVersion: protocol.MinVersion}
 	return nil
 }
 
+// GetSessionInfo is the original session lookup. It took a bare client ID
+// and no credentials, so it leaked any session's working directory; it now
+// only points callers at GetSessionInfoV2.
+func (r *RemoteShellService) GetSessionInfo(clientID string, resp *map[string]interface{}) error {
+	r.metrics.deny("auth")
+	return fmt.Errorf("unauthorized: GetSessionInfo carries no credentials, use GetSessionInfoV2")
+}
+
 // Execute executes a shell command remotely
-func (r *RemoteShellService) Execute(req CommandRequest, resp *CommandResponse) error {
+func (r *RemoteShellService) Execute(req protocol.CommandRequest, resp *protocol.CommandResponse) error {
+	r.execute(context.Background(), req, nil, resp)
+	return nil
+}
+
+// execute runs req for Execute and the streaming transports. The command is
+// killed if caller is cancelled, and its output is also copied to stream (if
+// not nil) as it is produced. It reports whether the command was started.
+func (r *RemoteShellService) execute(caller context.Context, req protocol.CommandRequest, stream io.Writer, resp *protocol.CommandResponse) (ran bool) {
+	defer func() { r.auditExec(req, resp, ran) }()
+
 	if !r.validateToken(req.Token) {
 		resp.Error = "unauthorized"
 		resp.ExitCode = -1
-		return nil
+		return
 	}
 	if r.isBanned(req.ID) {
 		resp.Error = "banned"
 		resp.ExitCode = -1
-		return nil
+		return
 	}
 
 	if !r.allowCommand(req.Command) {
+		r.metrics.deny("whitelist")
 		resp.Error = "command not allowed"
 		resp.ExitCode = -1
-		return nil
+		return
 	}
 
 	if !r.consumeRate(req.ID) {
 		resp.Error = "rate limit exceeded"
 		resp.ExitCode = -1
-		return nil
+		return
+	}
+
+	if len(req.Stdin) > protocol.MaxInlineStdin {
+		resp.Error = fmt.Sprintf("stdin too large to send inline (max %d bytes, use UploadStdin)", protocol.MaxInlineStdin)
+		resp.ExitCode = -1
+		return
 	}
 
 	if r.blockChaining && containsChaining(req.Command) {
+		r.metrics.deny("chaining")
 		resp.Error = "chaining/piping is blocked"
 		resp.ExitCode = -1
-		return nil
+		return
+	}
+
+	if r.isDraining() {
+		resp.Error = "server shutting down"
+		resp.ExitCode = -1
+		return
 	}
 
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
-	// Get or create session for this client
+	// Sessions are created by Register only, so every session has an owner
 	session, exists := r.sessions[req.ID]
 	if !exists {
-		now := time.Now()
-		session = &Session{
-			ID:          req.ID,
-			Env:         make(map[string]string),
-			WorkDir:     getDefaultWorkDir(),
-			ConnectedAt: now,
-			LastActive:  now,
+		r.mu.Unlock()
+		resp.Error = "client not registered"
+		resp.ExitCode = -1
+		return
+	}
+	if !r.authorizeSession(session, req.Token, req.Secret, "Execute") {
+		r.mu.Unlock()
+		resp.Error = "invalid session secret"
+		resp.ExitCode = -1
+		return
+	}
+
+	// A retry of a request that already ran gets the stored result instead
+	// of running the command a second time; a retry that arrives while the
+	// original is still running waits for it
+	for {
+		if prev, ok := session.lookupExecResult(req.RequestID, r.dedupWindow); ok {
+			session.LastActive = time.Now()
+			r.mu.Unlock()
+			*resp = prev
+			resp.Replayed = true
+			log.Printf("[Client %s] Replayed result of request %s: %s (Exit: %d)", req.ID, req.RequestID, r.redact.text(req.ID, req.Command), resp.ExitCode)
+			return
 		}
-		r.sessions[req.ID] = session
-		log.Printf("[Client %s] Auto-registered on first command", req.ID)
+		running, ok := session.inflight[req.RequestID]
+		if !ok {
+			break
+		}
+		r.mu.Unlock()
+		<-running
+		r.mu.Lock()
+		if r.sessions[req.ID] != session {
+			r.mu.Unlock()
+			resp.Error = "session ended"
+			resp.ExitCode = -1
+			return
+		}
+	}
+
+	// Uploaded stdin is taken now so no other command can read it
+	var stdin io.Reader
+	if req.StdinHandle != "" {
+		path := session.takeStdin(req.StdinHandle)
+		if path != "" {
+			defer os.Remove(path)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			r.mu.Unlock()
+			resp.Error = fmt.Sprintf("not found: no stdin upload %q", req.StdinHandle)
+			resp.ExitCode = -1
+			return
+		}
+		defer f.Close()
+		stdin = f
+	} else if len(req.Stdin) > 0 {
+		stdin = bytes.NewReader(req.Stdin)
+	}
+
+	var done chan struct{}
+	if req.RequestID != "" {
+		done = make(chan struct{})
+		session.inflight[req.RequestID] = done
+	}
+
+	// The command as admins, watchers, history, recordings and logs see it;
+	// only the shell gets req.Command itself
+	shown := r.redact.text(req.ID, req.Command)
+
+	// Commands the policy marks wait for an admin; a refusal is stored like
+	// a result so retries do not ask again
+	if r.needsApproval(req.Command) {
+		r.mu.Unlock()
+		approver, err := r.awaitApproval(caller, req, shown, session.WorkDir)
+		r.mu.Lock()
+		switch {
+		case err != nil:
+			resp.Error = err.Error()
+		case r.sessions[req.ID] != session:
+			resp.Error = "session ended"
+		}
+		if resp.Error != "" {
+			resp.ExitCode = -1
+			session.storeExecResult(req.RequestID, *resp, r.dedupWindow)
+			if done != nil {
+				delete(session.inflight, req.RequestID)
+				close(done)
+			}
+			r.mu.Unlock()
+			return
+		}
+		resp.ApprovedBy = approver
+	}
+
+	// Snapshot what the command needs so the lock is not held while it runs
+	workDir := session.WorkDir
+	env := os.Environ()
+	for k, v := range session.Env {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
+	rec := session.recorder
 
-	// Prepare command with timeout context
+	// Prepare command with timeout context; ending the session cancels it
 	limit := r.maxRuntime
 	if limit <= 0 {
 		limit = 5 * time.Minute
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), limit)
+	ctx, cancel := context.WithTimeout(r.runCtx, limit)
 	defer cancel()
+	stop := context.AfterFunc(caller, cancel)
+	defer stop()
+	jobID := r.startJob(req.ID, shown, workDir)
+	session.running[jobID] = cancel
+	r.mu.Unlock()
+	r.events.publish(protocol.Event{Kind: "command", ClientID: req.ID, Command: shown})
+	rec.command(req.ID, shown)
 
 	var cmd *exec.Cmd
 	if runtime.GOOS == "windows" {
@@ -270,35 +509,78 @@ func (r *RemoteShellService) Execute(req CommandRequest, resp *CommandResponse)
 		cmd = exec.CommandContext(ctx, "sh", "-c", req.Command)
 	}
 
+	// Once killed, stop waiting for children of the shell that still hold
+	// the output pipe
+	cmd.WaitDelay = time.Second
+
 	// Set working directory
-	if session.WorkDir != "" {
-		cmd.Dir = session.WorkDir
+	if workDir != "" {
+		cmd.Dir = workDir
 	}
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for k, v := range session.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	cmd.Env = env
+
+	// Execute command, streaming redacted output to admins shadowing the
+	// session, the caller's stream and the recording, which also gets the
+	// redacted stdin. Output past maxOutput is spooled to disk for
+	// FetchOutput.
+	spool := &outputSpool{dir: r.spoolDir, head: r.maxOutput, limit: r.maxSpool}
+	var out io.Writer = io.MultiWriter(spool, &outputWriter{hub: r.events, clientID: req.ID, limit: r.maxOutput})
+	if stream != nil {
+		out = io.MultiWriter(out, &cappedWriter{w: stream, limit: r.maxOutput})
+	}
+	var recOut, recIn *castStream
+	var in io.Writer
+	if rec != nil {
+		recOut = rec.stream("o")
+		out = io.MultiWriter(out, recOut)
+		if stdin != nil {
+			recIn = rec.stream("i")
+			in = newRedactWriter(r.redact, req.ID, recIn)
+			stdin = io.TeeReader(stdin, in)
+		}
 	}
+	out = newRedactWriter(r.redact, req.ID, out)
+	cmd.Stdin = stdin
+	cmd.Stdout = out
+	cmd.Stderr = out
+	ran = true
+	started := time.Now()
+	err := cmd.Run()
+	flushRedacted(out)
+	if recIn != nil {
+		flushRedacted(in)
+		recIn.flush()
+	}
+	if recOut != nil {
+		recOut.flush()
+	}
+	entry := protocol.HistoryEntry{Command: shown, StartedAt: started, Duration: time.Since(started)}
+	output, spoolPath := spool.finish()
+	outputLen := int(spool.size)
+	resp.OutputSize = spool.size
 
-	// Execute command
-	output, err := cmd.CombinedOutput()
-	if r.maxOutput > 0 && len(output) > r.maxOutput {
-		output = output[:r.maxOutput]
-	}
-	
-	// Check for timeout
-	if ctx.Err() == context.DeadlineExceeded {
-		resp.ID = req.ID
+	resp.ID = req.ID
+	timedOut := ctx.Err() == context.DeadlineExceeded
+	switch {
+	case timedOut:
 		resp.ExitCode = -1
-		resp.Error = "Command execution timeout (5 minutes)"
+		resp.Error = fmt.Sprintf("Command execution timeout (%v)", limit)
 		resp.Output = string(output)
-		log.Printf("[Client %s] Command timeout: %s", req.ID, req.Command)
-		return nil
-	}
-	
-	resp.ID = req.ID
-	if err != nil {
+	case r.runCtx.Err() != nil:
+		resp.ExitCode = -1
+		resp.Error = "Command killed: server shutting down"
+		resp.Output = string(output)
+	case caller.Err() != nil:
+		resp.ExitCode = -1
+		resp.Error = "Command cancelled: caller went away"
+		resp.Output = string(output)
+	case ctx.Err() != nil:
+		resp.ExitCode = -1
+		resp.Error = "Command killed: session ended"
+		resp.Output = string(output)
+	case err != nil:
 		if exitError, ok := err.(*exec.ExitError); ok {
 			resp.ExitCode = exitError.ExitCode()
 		} else {
@@ -306,64 +588,121 @@ func (r *RemoteShellService) Execute(req CommandRequest, resp *CommandResponse)
 		}
 		resp.Error = err.Error()
 		resp.Output = string(output)
-	} else {
+	default:
 		resp.ExitCode = 0
 		resp.Output = string(output)
 	}
+	entry.ExitCode = resp.ExitCode
+	rec.result(*resp)
+	r.metrics.commandDone(resp.ExitCode, timedOut, entry.Duration, outputLen)
+	r.events.publish(protocol.Event{Kind: "exit", ClientID: req.ID, Command: shown, ExitCode: resp.ExitCode, Message: resp.Error})
 
-	log.Printf("[Client %s] Executed: %s (Exit: %d)", req.ID, req.Command, resp.ExitCode)
-	
-	// Update last active time
-	if session, exists := r.sessions[req.ID]; exists {
-		session.LastActive = time.Now()
+	r.mu.Lock()
+	delete(r.jobs, jobID)
+	delete(session.running, jobID)
+	if spoolPath != "" {
+		if r.sessions[req.ID] == session {
+			resp.OutputHandle = session.addSpool(spoolPath)
+		} else {
+			os.Remove(spoolPath)
+		}
 	}
-	
-	return nil
+	session.recordHistory(entry, r.historySize)
+	session.storeExecResult(req.RequestID, *resp, r.dedupWindow)
+	if done != nil {
+		delete(session.inflight, req.RequestID)
+		close(done)
+	}
+	session.LastActive = time.Now()
+	r.mu.Unlock()
+
+	if timedOut {
+		log.Printf("[Client %s] Command timeout: %s", req.ID, shown)
+	} else {
+		log.Printf("[Client %s] Executed: %s (Exit: %d)", req.ID, shown, resp.ExitCode)
+	}
+	return
 }
 
 // Register registers a new client session
-func (r *RemoteShellService) Register(req RegisterRequest, resp *string) error {
+func (r *RemoteShellService) Register(req RegisterRequest, resp *protocol.RegisterResponse) error {
 	if !r.validateToken(req.Token) {
-		*resp = "Error: unauthorized"
+		resp.Error = "unauthorized"
 		return nil
 	}
 	if r.isBanned(req.ID) {
-		*resp = "Error: banned"
+		resp.Error = "banned"
 		return nil
 	}
 
 	if !r.consumeRate(req.ID) {
-		*resp = "Error: rate limit exceeded"
+		resp.Error = "rate limit exceeded"
+		return nil
+	}
+	if req.ID == "" {
+		resp.Error = "client_id required"
 		return nil
 	}
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if r.draining {
+		resp.Error = "server shutting down"
+		return nil
+	}
+
 	now := time.Now()
 	session, exists := r.sessions[req.ID]
-	if !exists {
-		session = &Session{
-			ID:          req.ID,
-			Env:         make(map[string]string),
-			WorkDir:     getDefaultWorkDir(),
-			ConnectedAt: now,
-			LastActive:  now,
-		}
-		r.sessions[req.ID] = session
-		log.Printf("[Client %s] Registered (new session)", req.ID)
-		*resp = fmt.Sprintf("Client %s registered successfully", req.ID)
-	} else {
+	if exists {
+		// Resuming requires the secret issued for this session; anyone else
+		// picking the same ID must not get access to its env and directory
+		if !r.authorizeSession(session, req.Token, req.Secret, "Register") {
+			r.audit.record(protocol.AuditEntry{Identity: r.identityFor(req.Token), ClientID: req.ID, Action: "register", Detail: "resume", Outcome: "rejected: session secret"})
+			resp.Error = "client ID already in use by another session"
+			return nil
+		}
 		session.LastActive = now
-		log.Printf("[Client %s] Re-registered (existing session)", req.ID)
-		*resp = fmt.Sprintf("Client %s re-registered", req.ID)
+		r.attachConn(session, req.conn)
+		r.audit.record(protocol.AuditEntry{Identity: session.Owner, ClientID: req.ID, Action: "register", Detail: "resume", Outcome: "ok"})
+		log.Printf("[Client %s] Re-registered (existing session)%s", req.ID, connFrom(req.conn))
+		resp.Message = fmt.Sprintf("Client %s re-registered", req.ID)
+		resp.Secret = session.secret
+		resp.Resumed = true
+		return nil
 	}
 
+	secret, err := newSessionSecret()
+	if err != nil {
+		return fmt.Errorf("generate session secret: %v", err)
+	}
+	session = &Session{
+		ID:          req.ID,
+		Owner:       r.identityFor(req.Token),
+		secret:      secret,
+		Env:         make(map[string]string),
+		WorkDir:     getDefaultWorkDir(),
+		ConnectedAt: now,
+		LastActive:  now,
+		inflight:    make(map[string]chan struct{}),
+		running:     make(map[int64]context.CancelFunc),
+	}
+	if r.recordDir != "" {
+		if session.recorder, err = newRecorder(r.recordDir, req.ID, now); err != nil {
+			log.Printf("[Client %s] Not recording session: %v", req.ID, err)
+		}
+	}
+	r.sessions[req.ID] = session
+	r.attachConn(session, req.conn)
+	r.audit.record(protocol.AuditEntry{Identity: session.Owner, ClientID: req.ID, Action: "register", Detail: "new", Outcome: "ok"})
+	log.Printf("[Client %s] Registered (new session, owner %s)%s", req.ID, session.Owner, connFrom(req.conn))
+	resp.Message = fmt.Sprintf("Client %s registered successfully", req.ID)
+	resp.Secret = secret
 	return nil
 }
 
 // SetEnv sets an environment variable for a client session
-func (r *RemoteShellService) SetEnv(req EnvRequest, resp *string) error {
+func (r *RemoteShellService) SetEnv(req protocol.EnvRequest, resp *string) error {
 	if !r.validateToken(req.Token) {
 		*resp = "Error: unauthorized"
 		return nil
@@ -389,33 +728,158 @@ func (r *RemoteShellService) SetEnv(req EnvRequest, resp *string) error {
 
 	session, exists := r.sessions[clientID]
 	if !exists {
-		now := time.Now()
-		session = &Session{
-			ID:          clientID,
-			Env:         make(map[string]string),
-			WorkDir:     getDefaultWorkDir(),
-			ConnectedAt: now,
-			LastActive:  now,
-		}
-		r.sessions[clientID] = session
-		log.Printf("[Client %s] Auto-registered on SetEnv", clientID)
+		*resp = "Error: client not registered"
+		return nil
+	}
+	if !r.authorizeSession(session, req.Token, req.Secret, "SetEnv") {
+		*resp = "Error: invalid session secret"
+		return nil
 	}
 	session.LastActive = time.Now()
 
+	// Empty values are allowed (FOO= is a valid assignment); only the key is required
 	key := req.Key
 	value := req.Value
-	if key != "" && value != "" {
-		session.Env[key] = value
-		*resp = fmt.Sprintf("Set %s=%s for client %s", key, value, clientID)
-	} else {
-		*resp = "Error: key and value required"
+	if key == "" {
+		*resp = "Error: key required"
+		return nil
+	}
+	if !validEnvKey(key) {
+		*resp = fmt.Sprintf("Error: invalid variable name %q", key)
+		return nil
+	}
+	session.Env[key] = value
+	// Once secret, a variable stays secret until it is unset
+	if req.Sensitive || r.redact.isSecretKey(key) || r.redact.secret(clientID, key) {
+		r.redact.setSecret(clientID, key, value)
+		*resp = fmt.Sprintf("Set %s (secret) for client %s", key, clientID)
+		return nil
 	}
+	*resp = fmt.Sprintf("Set %s=%s for client %s", key, value, clientID)
 
 	return nil
 }
 
+// UnsetEnv removes an environment variable from a client session
+func (r *RemoteShellService) UnsetEnv(req protocol.EnvKeyRequest, resp *string) error {
+	if !r.validateToken(req.Token) {
+		*resp = "Error: unauthorized"
+		return nil
+	}
+	if r.isBanned(req.ID) {
+		*resp = "Error: banned"
+		return nil
+	}
+
+	if !r.consumeRate(req.ID) {
+		*resp = "Error: rate limit exceeded"
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, exists := r.sessions[req.ID]
+	if !exists {
+		*resp = "Error: client not registered"
+		return nil
+	}
+	if !r.authorizeSession(session, req.Token, req.Secret, "UnsetEnv") {
+		*resp = "Error: invalid session secret"
+		return nil
+	}
+	session.LastActive = time.Now()
+
+	if req.Key == "" {
+		*resp = "Error: key required"
+		return nil
+	}
+	if _, ok := session.Env[req.Key]; !ok {
+		*resp = fmt.Sprintf("%s was not set for client %s", req.Key, req.ID)
+		return nil
+	}
+	delete(session.Env, req.Key)
+	r.redact.unsetSecret(req.ID, req.Key)
+	*resp = fmt.Sprintf("Unset %s for client %s", req.Key, req.ID)
+	return nil
+}
+
+// GetEnv returns a single environment variable of a client session
+func (r *RemoteShellService) GetEnv(req protocol.EnvKeyRequest, resp *protocol.EnvValueResponse) error {
+	resp.Key = req.Key
+	if !r.validateToken(req.Token) {
+		resp.Error = "unauthorized"
+		return nil
+	}
+	if r.isBanned(req.ID) {
+		resp.Error = "banned"
+		return nil
+	}
+
+	if !r.consumeRate(req.ID) {
+		resp.Error = "rate limit exceeded"
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, exists := r.sessions[req.ID]
+	if !exists {
+		resp.Error = "client not registered"
+		return nil
+	}
+	if !r.authorizeSession(session, req.Token, req.Secret, "GetEnv") {
+		resp.Error = "invalid session secret"
+		return nil
+	}
+	session.LastActive = time.Now()
+
+	resp.Value, resp.Exists = session.Env[req.Key]
+	if resp.Exists && r.redact.secret(req.ID, req.Key) {
+		resp.Value, resp.Sensitive = redactedText, true
+	}
+	return nil
+}
+
+// ListEnv returns all environment variables set on a client session
+func (r *RemoteShellService) ListEnv(req protocol.ListEnvRequest, resp *map[string]string) error {
+	if !r.validateToken(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	if r.isBanned(req.ID) {
+		return fmt.Errorf("banned")
+	}
+
+	if !r.consumeRate(req.ID) {
+		return fmt.Errorf("rate limit exceeded")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, exists := r.sessions[req.ID]
+	if !exists {
+		return fmt.Errorf("client not registered")
+	}
+	if !r.authorizeSession(session, req.Token, req.Secret, "ListEnv") {
+		return fmt.Errorf("invalid session secret")
+	}
+	session.LastActive = time.Now()
+
+	out := make(map[string]string, len(session.Env))
+	for k, v := range session.Env {
+		if r.redact.secret(req.ID, k) {
+			v = redactedText
+		}
+		out[k] = v
+	}
+	*resp = out
+	return nil
+}
+
 // ChangeDir changes the working directory for a client session
-func (r *RemoteShellService) ChangeDir(req DirRequest, resp *string) error {
+func (r *RemoteShellService) ChangeDir(req protocol.DirRequest, resp *string) error {
 	if !r.validateToken(req.Token) {
 		*resp = "Error: unauthorized"
 		return nil
@@ -443,16 +907,12 @@ func (r *RemoteShellService) ChangeDir(req DirRequest, resp *string) error {
 
 	session, exists := r.sessions[clientID]
 	if !exists {
-		now := time.Now()
-		session = &Session{
-			ID:          clientID,
-			Env:         make(map[string]string),
-			WorkDir:     getDefaultWorkDir(),
-			ConnectedAt: now,
-			LastActive:  now,
-		}
-		r.sessions[clientID] = session
-		log.Printf("[Client %s] Auto-registered on ChangeDir", clientID)
+		*resp = "Error: client not registered"
+		return nil
+	}
+	if !r.authorizeSession(session, req.Token, req.Secret, "ChangeDir") {
+		*resp = "Error: invalid session secret"
+		return nil
 	}
 	session.LastActive = time.Now()
 
@@ -462,6 +922,7 @@ func (r *RemoteShellService) ChangeDir(req DirRequest, resp *string) error {
 			return nil
 		}
 		session.WorkDir = dir
+		session.recorder.command(clientID, r.redact.text(clientID, "cd "+dir))
 		*resp = fmt.Sprintf("Changed directory to %s for client %s", dir, clientID)
 	} else {
 		*resp = "Error: dir required"
@@ -470,9 +931,40 @@ func (r *RemoteShellService) ChangeDir(req DirRequest, resp *string) error {
 	return nil
 }
 
+// History returns the recorded command history of a session
+func (r *RemoteShellService) History(req protocol.HistoryRequest, resp *[]protocol.HistoryEntry) error {
+	if !r.authTokenOK(req.Token) && !r.adminTokenOK(req.Token) {
+		r.metrics.deny("auth")
+		return fmt.Errorf("unauthorized")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	session, exists := r.sessions[req.ID]
+	if !exists {
+		return fmt.Errorf("session not found")
+	}
+	// Admins read any session without its secret; everyone else must own it
+	if req.Secret != "" || !r.adminTokenOK(req.Token) {
+		if !r.authorizeSession(session, req.Token, req.Secret, "History") {
+			return fmt.Errorf("invalid session secret")
+		}
+	}
+
+	entries := session.History
+	if req.Limit > 0 && len(entries) > req.Limit {
+		entries = entries[len(entries)-req.Limit:]
+	}
+	out := make([]protocol.HistoryEntry, len(entries))
+	copy(out, entries)
+	*resp = out
+	return nil
+}
+
 // ListClients returns list of active client sessions
-func (r *RemoteShellService) ListClients(req ListRequest, resp *[]string) error {
-	if !r.validateToken(req.Token) {
+func (r *RemoteShellService) ListClients(req protocol.ListRequest, resp *[]string) error {
+	if !r.validateAdmin(req.Token) {
 		return fmt.Errorf("unauthorized")
 	}
 
@@ -488,54 +980,49 @@ func (r *RemoteShellService) ListClients(req ListRequest, resp *[]string) error
 	return nil
 }
 
-// ListSessions returns detail of sessions
-func (r *RemoteShellService) ListSessions(req ListSessionsRequest, resp *[]map[string]interface{}) error {
-	if !r.validateToken(req.Token) {
+// ListSessions returns detail of sessions as untyped maps. Kept for admin
+// binaries that predate ListSessionsV2.
+func (r *RemoteShellService) ListSessions(req protocol.ListSessionsRequest, resp *[]map[string]interface{}) error {
+	if !r.validateAdmin(req.Token) {
 		return fmt.Errorf("unauthorized")
 	}
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	out := make([]map[string]interface{}, 0, len(r.sessions))
 	now := time.Now()
-	for id, s := range r.sessions {
-		out = append(out, map[string]interface{}{
-			"id":           id,
-			"work_dir":     s.WorkDir,
-			"env_count":    len(s.Env),
-			"connected_at": s.ConnectedAt.Format(time.RFC3339),
-			"last_active":  s.LastActive.Format(time.RFC3339),
-			"age":          now.Sub(s.ConnectedAt).String(),
-			"idle":         now.Sub(s.LastActive).String(),
-			"is_active":    now.Sub(s.LastActive) < r.sessionTimeout,
-		})
+	infos := r.allSessionInfo()
+	out := make([]map[string]interface{}, 0, len(infos))
+	for _, info := range infos {
+		out = append(out, legacySessionMap(info, now))
 	}
 	*resp = out
 	return nil
 }
 
 // KillSession removes a session by ID
-func (r *RemoteShellService) KillSession(req KillSessionRequest, resp *string) error {
-	if !r.validateToken(req.Token) {
+func (r *RemoteShellService) KillSession(req protocol.KillSessionRequest, resp *string) error {
+	if !r.validateAdmin(req.Token) {
 		*resp = "unauthorized"
 		return nil
 	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if _, ok := r.sessions[req.ID]; !ok {
+	session, ok := r.sessions[req.ID]
+	if !ok {
 		*resp = "not found"
 		return nil
 	}
-	delete(r.sessions, req.ID)
+	for conn := range session.conns {
+		conn.closeWithReason("session killed by admin")
+	}
 	r.banned[req.ID] = struct{}{}
-	*resp = "killed and banned"
-	log.Printf("[Admin] Killed and banned session %s", req.ID)
+	r.endSession(session, "killed by admin")
+	r.audit.record(protocol.AuditEntry{Identity: r.identityFor(req.Token), ClientID: req.ID, Action: "kill", Outcome: "ok"})
+	*resp = fmt.Sprintf("killed and banned (%d connections closed)", len(session.conns))
+	log.Printf("[Admin] Killed and banned session %s, closed %d connections", req.ID, len(session.conns))
 	return nil
 }
 
 // AddToWhitelist adds commands to the allowed command whitelist
-func (r *RemoteShellService) AddToWhitelist(req UpdateWhitelistRequest, resp *[]string) error {
-	if !r.validateToken(req.Token) {
+func (r *RemoteShellService) AddToWhitelist(req protocol.UpdateWhitelistRequest, resp *[]string) error {
+	if !r.validateAdmin(req.Token) {
 		return fmt.Errorf("unauthorized")
 	}
 	r.mu.Lock()
@@ -555,11 +1042,12 @@ func (r *RemoteShellService) AddToWhitelist(req UpdateWhitelistRequest, resp *[]
 			continue
 		}
 		r.allowedCmds[first] = struct{}{}
+		r.audit.record(protocol.AuditEntry{Identity: r.identityFor(req.Token), Action: "whitelist-add", Detail: first, Outcome: "ok"})
 		log.Printf("[Admin] Added to whitelist: %s", first)
 	}
 
 	// Return current whitelist for convenience
-	*resp = keys(r.allowedCmds)
+	*resp = sortedKeys(r.allowedCmds)
 	return nil
 }
 
@@ -571,8 +1059,33 @@ func getDefaultWorkDir() string {
 	return wd
 }
 
+// validEnvKey reports whether key is a usable environment variable name
+func validEnvKey(key string) bool {
+	for i, c := range key {
+		switch {
+		case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return key != ""
+}
+
 // validateToken checks auth token if configured
 func (r *RemoteShellService) validateToken(token string) bool {
+	if !r.authTokenOK(token) {
+		r.metrics.deny("auth")
+		return false
+	}
+	return true
+}
+
+// authTokenOK is validateToken without counting a denial
+func (r *RemoteShellService) authTokenOK(token string) bool {
+	if _, ok := r.principals[token]; ok {
+		return true
+	}
 	if r.authToken == "" {
 		return true // no auth configured
 	}
@@ -581,6 +1094,8 @@ func (r *RemoteShellService) validateToken(token string) bool {
 
 // allowCommand checks whitelist; if empty allow all
 func (r *RemoteShellService) allowCommand(cmd string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	if len(r.allowedCmds) == 0 {
 		return true
 	}
@@ -595,9 +1110,12 @@ func (r *RemoteShellService) allowCommand(cmd string) bool {
 
 // consumeRate applies simple fixed window rate limiting per client ID
 func (r *RemoteShellService) consumeRate(id string) bool {
+	r.markRequest(id)
 	if r.rateLimit <= 0 {
 		return true
 	}
+	r.rateMu.Lock()
+	defer r.rateMu.Unlock()
 	now := time.Now()
 	info, ok := r.rateCounters[id]
 	if !ok || now.Sub(info.windowFrom) > r.rateWindow {
@@ -605,12 +1123,32 @@ func (r *RemoteShellService) consumeRate(id string) bool {
 		return true
 	}
 	if info.count >= r.rateLimit {
+		r.metrics.deny("rate")
 		return false
 	}
 	info.count++
 	return true
 }
 
+// pruneRates forgets the rate limit windows and request meters of clients
+// that have gone quiet. Both are created per client ID before the caller is
+// checked, so without this they would grow with every ID ever tried.
+func (r *RemoteShellService) pruneRates() {
+	r.rateMu.Lock()
+	defer r.rateMu.Unlock()
+	now := time.Now()
+	for id, info := range r.rateCounters {
+		if now.Sub(info.windowFrom) > r.rateWindow {
+			delete(r.rateCounters, id)
+		}
+	}
+	for id, m := range r.requestMeters {
+		if m.lastMinute(now) == 0 {
+			delete(r.requestMeters, id)
+		}
+	}
+}
+
 func keys(m map[string]struct{}) []string {
 	out := make([]string, 0, len(m))
 	for k := range m {
@@ -620,7 +1158,12 @@ func keys(m map[string]struct{}) []string {
 }
 
 func (r *RemoteShellService) isBanned(id string) bool {
+	r.mu.RLock()
 	_, ok := r.banned[id]
+	r.mu.RUnlock()
+	if ok {
+		r.metrics.deny("banned")
+	}
 	return ok
 }
 
@@ -640,12 +1183,36 @@ func main() {
 	var (
 		port           = flag.Int("port", 8080, "Port to listen on")
 		authToken      = flag.String("auth-token", "", "Auth token required from clients (optional)")
+		adminToken     = flag.String("admin-token", "", "Separate token for admin RPCs (optional, defaults to --auth-token)")
 		allowCmdsStr   = flag.String("allow-commands", "", "Comma-separated whitelist of allowed commands (empty = allow all)")
+		approvalStr    = flag.String("require-approval", "", "Comma-separated commands that wait for an admin to approve them (optional, needs --admin-token; a safety net against mistakes, not a security boundary)")
+		approvalSec    = flag.Int("approval-timeout-sec", int(defaultApprovalTimeout/time.Second), "Seconds a command waits for approval before it is refused")
 		rateLimit      = flag.Int("rate-limit", 60, "Max requests per window per client (0 = disable)")
 		rateWindowSec  = flag.Int("rate-window-sec", 60, "Rate limit window in seconds")
 		maxConnections = flag.Int("max-connections", 100, "Maximum number of concurrent connections (0 = unlimited)")
 		tlsCert        = flag.String("tls-cert", "", "Path to TLS certificate (optional)")
 		tlsKey         = flag.String("tls-key", "", "Path to TLS key (optional)")
+		tlsClientCA    = flag.String("tls-client-ca", "", "Verify client certificates against this CA; their CN is shown as the connection's identity (optional)")
+		historySize    = flag.Int("history-size", 100, "Commands kept in each session's history (0 = disable)")
+		maxTransferMB  = flag.Int("max-transfer-mb", 100, "Max file size for put/get in MiB (0 = unlimited)")
+		maxSpoolMB     = flag.Int("max-spool-mb", 100, "Max output kept per command for FetchOutput past the 256 KiB returned with it, in MiB (0 = unlimited)")
+		spoolDir       = flag.String("spool-dir", "", "Directory for spooled command output (optional, default: system temp dir)")
+		transferRoot   = flag.String("transfer-root", "", "Confine put/get to this directory (default: the session's working directory; required for transfers with --allow-commands)")
+		redactRules    = flag.String("redact-rules", "", "File of regexes, one per line, whose matches are redacted from output, audit entries and recordings (optional)")
+		secretEnvStr   = flag.String("secret-env", "", "Comma-separated globs of env var names always kept secret, e.g. *PASSWORD*,*TOKEN* (optional)")
+		recordDir      = flag.String("record-dir", "", "Record every session's commands and output as asciicast v2 files in this directory (optional)")
+		dedupWindowSec = flag.Int("dedup-window-sec", 600, "Seconds Execute results are kept to answer retried requests (0 = disable)")
+		metricsAddr    = flag.String("metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9090 (optional)")
+		healthAddr     = flag.String("health-addr", "", "Serve /healthz and /readyz on this address (optional, may equal --metrics-addr)")
+		grpcAddr       = flag.String("grpc-addr", "", "Also serve the gRPC transport on this address, e.g. :9443 (optional, uses the same TLS settings)")
+		httpAddr       = flag.String("http-addr", "", "Also serve the HTTP/JSON and WebSocket gateway on this address, e.g. :8443 (optional, uses the same TLS settings)")
+		sshAddr        = flag.String("ssh-addr", "", "Also serve an SSH front end on this address, e.g. :2222 (optional, needs --ssh-authorized-keys)")
+		sshHostKey     = flag.String("ssh-host-key", "", "PEM private key the SSH front end identifies with (optional, default: temporary key)")
+		sshAuthKeys    = flag.String("ssh-authorized-keys", "", "authorized_keys file for the SSH front end; the comment after each key is its user")
+		idleTimeoutSec = flag.Int("idle-timeout-sec", 600, "Close connections idle for this many seconds (0 = never)")
+		auditSize      = flag.Int("audit-size", 1000, "Audit entries kept in memory for admin audit")
+		auditFile      = flag.String("audit-log", "", "Also append audit entries to this file as JSON lines (optional)")
+		shutdownSec    = flag.Int("shutdown-timeout-sec", 30, "On SIGINT/SIGTERM, seconds to let running commands finish before killing them")
 	)
 	flag.Parse()
 
@@ -662,8 +1229,68 @@ func main() {
 
 	limit := time.Duration(*rateWindowSec) * time.Second
 	service := NewRemoteShellService(*authToken, allowed, *rateLimit, time.Duration(*rateWindowSec)*time.Second, limit, 256*1024, true)
+	service.adminToken = *adminToken
+	service.approvalCmds = make(map[string]struct{})
+	for _, p := range strings.Split(*approvalStr, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			service.approvalCmds[p] = struct{}{}
+		}
+	}
+	// Approval is only two-person control if approvers hold a token users
+	// do not: with a shared token the requester is an admin too
+	if len(service.approvalCmds) > 0 && (*adminToken == "" || *adminToken == *authToken) {
+		log.Fatal("--require-approval needs an --admin-token different from --auth-token")
+	}
+	service.approvalTimeout = time.Duration(*approvalSec) * time.Second
+	service.historySize = *historySize
+	service.dedupWindow = time.Duration(*dedupWindowSec) * time.Second
+	service.metrics.maxConnections = *maxConnections
+	service.connLimit = newConnLimit(*maxConnections)
+	service.idleTimeout = time.Duration(*idleTimeoutSec) * time.Second
+	service.maxTransfer = int64(*maxTransferMB) * 1024 * 1024
+	service.maxSpool = int64(*maxSpoolMB) * 1024 * 1024
+	service.spoolDir = *spoolDir
+	service.audit.size = *auditSize
+	if *auditFile != "" {
+		if err := service.audit.openFile(*auditFile); err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		log.Printf("Audit log: %s", *auditFile)
+	}
+	if *redactRules != "" {
+		rules, err := loadRedactRules(*redactRules)
+		if err != nil {
+			log.Fatalf("Failed to load redaction rules: %v", err)
+		}
+		service.redact.rules = rules
+		log.Printf("Loaded %d redaction rules from %s", len(rules), *redactRules)
+	}
+	for _, p := range strings.Split(*secretEnvStr, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			service.redact.secretKeys = append(service.redact.secretKeys, strings.ToUpper(p))
+		}
+	}
+	if *recordDir != "" {
+		if err := os.MkdirAll(*recordDir, 0700); err != nil {
+			log.Fatalf("Failed to create record dir: %v", err)
+		}
+		service.recordDir = *recordDir
+		log.Printf("Recording sessions to %s", *recordDir)
+	}
+	if *transferRoot != "" {
+		root, err := filepath.Abs(*transferRoot)
+		if err == nil {
+			root, err = filepath.EvalSymlinks(root)
+		}
+		if err != nil {
+			log.Fatalf("Invalid transfer root %s: %v", *transferRoot, err)
+		}
+		service.transferRoot = root
+	}
 	rpc.Register(service)
 
+	httpServers := startHTTPServers(*metricsAddr, *healthAddr, service)
+
 	addr := fmt.Sprintf(":%d", *port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -671,32 +1298,84 @@ func main() {
 	}
 
 	// Wrap with TLS if cert/key provided
+	var tlsConfig *tls.Config
 	if *tlsCert != "" && *tlsKey != "" {
 		cer, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
 		if err != nil {
 			log.Fatalf("Failed to load TLS cert/key: %v", err)
 		}
 		config := &tls.Config{Certificates: []tls.Certificate{cer}}
+		if *tlsClientCA != "" {
+			pem, err := os.ReadFile(*tlsClientCA)
+			if err != nil {
+				log.Fatalf("Failed to read client CA: %v", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				log.Fatalf("No certificates found in %s", *tlsClientCA)
+			}
+			config.ClientCAs = pool
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
 		listener = tls.NewListener(listener, config)
+		tlsConfig = config
 		log.Printf("TLS enabled with cert %s", *tlsCert)
 	}
 
+	var grpcServer *grpc.Server
+	if *grpcAddr != "" {
+		grpcServer, err = startGRPCServer(*grpcAddr, tlsConfig, service)
+		if err != nil {
+			log.Fatalf("Error starting gRPC server: %v", err)
+		}
+	}
+	if *httpAddr != "" {
+		gateway, err := startGateway(*httpAddr, tlsConfig, service)
+		if err != nil {
+			log.Fatalf("Error starting HTTP gateway: %v", err)
+		}
+		httpServers = append(httpServers, gateway)
+	}
+	var sshListener net.Listener
+	if *sshAddr != "" {
+		sshListener, err = startSSHServer(*sshAddr, *sshHostKey, *sshAuthKeys, service)
+		if err != nil {
+			log.Fatalf("Error starting SSH server: %v", err)
+		}
+	}
+
 	// Get server IP addresses for display
 	log.Printf("Remote Shell RPC Server started on %s", addr)
 	if *authToken != "" {
 		log.Println("Auth token required for all calls")
 	}
+	if *adminToken != "" {
+		log.Println("Separate admin token required for admin calls")
+	}
 	if len(allowed) > 0 {
 		log.Printf("Command whitelist enabled: %v", keys(allowed))
 	}
+	if len(service.approvalCmds) > 0 {
+		log.Printf("Commands requiring admin approval: %v (wait up to %v)", keys(service.approvalCmds), service.approvalTimeout)
+	}
 	log.Printf("Rate limit: %d requests / %ds per client", *rateLimit, *rateWindowSec)
+	if service.transferRoot != "" {
+		log.Printf("File transfers confined to %s", service.transferRoot)
+	} else if len(allowed) > 0 {
+		log.Printf("File transfers disabled: --allow-commands is set without --transfer-root")
+	} else {
+		log.Printf("File transfers confined to each session's working directory")
+	}
 	log.Printf("Max runtime: %ds, Max output: %d bytes, Block chaining: %v", int(service.maxRuntime.Seconds()), service.maxOutput, service.blockChaining)
+	if *idleTimeoutSec > 0 {
+		log.Printf("Idle connection timeout: %ds", *idleTimeoutSec)
+	}
 	if *maxConnections > 0 {
 		log.Printf("Max concurrent connections: %d", *maxConnections)
 	} else {
 		log.Println("Max concurrent connections: unlimited")
 	}
-	
+
 	// Display local IP addresses
 	addrs, err := net.InterfaceAddrs()
 	if err == nil {
@@ -709,56 +1388,68 @@ func main() {
 			}
 		}
 	}
-	
+
 	log.Println("Waiting for clients...")
 	log.Printf("Clients can connect using: <server-ip>:%d", *port)
 
-	// Connection limiting semaphore
-	var connectionSemaphore chan struct{}
-	if *maxConnections > 0 {
-		connectionSemaphore = make(chan struct{}, *maxConnections)
-	}
+	// Stop accepting on SIGINT/SIGTERM; a second signal exits immediately
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, shutting down (send again to force)", sig)
+		service.beginShutdown()
+		listener.Close()
+		sig = <-signals
+		log.Printf("Received %v again, exiting without draining", sig)
+		os.Exit(1)
+	}()
+
+	service.setReady()
 
 	// Accept connections
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if service.isDraining() {
+				break
+			}
 			log.Printf("Error accepting connection: %v", err)
 			continue
 		}
 
 		// Check connection limit
-		if *maxConnections > 0 {
-			select {
-			case connectionSemaphore <- struct{}{}:
-				// Acquired semaphore, proceed with connection
-			default:
-				// Max connections reached, reject
-				conn.Close()
-				log.Printf("Max connections (%d) reached, rejecting connection from %s", *maxConnections, conn.RemoteAddr())
-				continue
-			}
+		if !service.connLimit.acquire() {
+			conn.Close()
+			service.metrics.connRejected()
+			log.Printf("Max connections (%d) reached, rejecting connection from %s", *maxConnections, conn.RemoteAddr())
+			continue
 		}
 
-		// Set connection timeout
-		conn.SetDeadline(time.Now().Add(10 * time.Minute))
-		
 		// Handle each client in a separate goroutine
-		go func(conn net.Conn) {
+		go func(conn *idleConn) {
 			clientAddr := conn.RemoteAddr()
 			log.Printf("New client connected: %s", clientAddr)
-			
-			// Release semaphore when connection closes
-			if *maxConnections > 0 {
-				defer func() { <-connectionSemaphore }()
-			}
+			service.metrics.connOpened()
+			defer service.metrics.connClosed()
+
+			// Release the connection slot when the connection closes
+			defer service.connLimit.release()
 			defer conn.Close()
-			
-			// Serve RPC (net/rpc ServeConn does not return an error)
-			rpc.ServeConn(conn)
-			log.Printf("Client disconnected: %s", clientAddr)
-		}(conn)
-	}
-}
 
+			// Serve RPC, counting calls so shutdown can wait for their replies
+			// and the idle timeout skips connections with a command running
+			rpc.ServeCodec(newTrackedServerCodec(conn, service.calls, &conn.calls))
+			service.detachConn(conn)
+			log.Printf("Client disconnected: %s (%s)", clientAddr, conn.disconnectReason())
+		}(newIdleConn(conn, service.idleTimeout))
+	}
 
+	service.drain(time.Duration(*shutdownSec) * time.Second)
+	stopGRPCServer(grpcServer)
+	stopHTTPServers(httpServers)
+	if sshListener != nil {
+		sshListener.Close()
+	}
+	log.Println("Server stopped")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// countingCommand appends a line to a file each time it runs and prints
+// how many runs there have been
+func countingCommand(t *testing.T) (string, func() int) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "runs")
+	runs := func() int {
+		data, _ := os.ReadFile(file)
+		return strings.Count(string(data), "\n")
+	}
+	return "echo run >> " + file + "; wc -l < " + file, runs
+}
+
+func TestExecuteReplaysFinishedRequest(t *testing.T) {
+	r := newTestService(t)
+	reg := register(t, r, "dev", "")
+	cmd, runs := countingCommand(t)
+	req := protocol.CommandRequest{ID: "dev", Token: "tok", Secret: reg.Secret, Command: cmd, RequestID: "req-1"}
+
+	var first, retry protocol.CommandResponse
+	r.Execute(req, &first)
+	r.Execute(req, &retry)
+	if first.Replayed || !retry.Replayed {
+		t.Fatalf("Replayed = %v then %v, want false then true", first.Replayed, retry.Replayed)
+	}
+	if retry.Output != first.Output || retry.ExitCode != first.ExitCode {
+		t.Fatalf("replayed %+v, want %+v", retry, first)
+	}
+	if n := runs(); n != 1 {
+		t.Fatalf("command ran %d times, want once", n)
+	}
+
+	// A new RequestID runs again
+	var other protocol.CommandResponse
+	req.RequestID = "req-2"
+	r.Execute(req, &other)
+	if other.Replayed || runs() != 2 {
+		t.Fatalf("new request replayed = %v after %d runs", other.Replayed, runs())
+	}
+}
+
+func TestExecuteDuplicateWaitsForRunningRequest(t *testing.T) {
+	r := newTestService(t)
+	reg := register(t, r, "dev", "")
+	cmd, runs := countingCommand(t)
+	req := protocol.CommandRequest{ID: "dev", Token: "tok", Secret: reg.Secret, Command: "sleep 0.3; " + cmd, RequestID: "req-1"}
+
+	first := make(chan protocol.CommandResponse, 1)
+	go func() {
+		var resp protocol.CommandResponse
+		r.Execute(req, &resp)
+		first <- resp
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		r.mu.RLock()
+		_, running := r.sessions["dev"].inflight["req-1"]
+		r.mu.RUnlock()
+		if running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first request never started")
+		}
+	}
+
+	var dup protocol.CommandResponse
+	r.Execute(req, &dup)
+	orig := <-first
+	if !dup.Replayed || dup.Output != orig.Output {
+		t.Fatalf("duplicate = %+v, want the first result %+v replayed", dup, orig)
+	}
+	if n := runs(); n != 1 {
+		t.Fatalf("command ran %d times, want once", n)
+	}
+}
+
+func TestExecuteResultExpires(t *testing.T) {
+	r := newTestService(t)
+	r.dedupWindow = 50 * time.Millisecond
+	reg := register(t, r, "dev", "")
+	cmd, runs := countingCommand(t)
+	req := protocol.CommandRequest{ID: "dev", Token: "tok", Secret: reg.Secret, Command: cmd, RequestID: "req-1"}
+
+	var first, later protocol.CommandResponse
+	r.Execute(req, &first)
+	time.Sleep(100 * time.Millisecond)
+	r.Execute(req, &later)
+	if later.Replayed || runs() != 2 {
+		t.Fatalf("request after the window replayed = %v after %d runs, want it run again", later.Replayed, runs())
+	}
+
+	// Storing a result sweeps the expired ones
+	r.mu.RLock()
+	stored := len(r.sessions["dev"].execResults)
+	r.mu.RUnlock()
+	if stored != 1 {
+		t.Fatalf("%d results stored, want only the latest", stored)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	durationBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+	outputBuckets   = []float64{0, 64, 256, 1024, 4096, 16384, 65536, 262144, 1048576}
+)
+
+// histogram is a cumulative Prometheus-style histogram
+type histogram struct {
+	bounds []float64
+	counts []uint64 // counts[i] = observations <= bounds[i]
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer, name string) {
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, b, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// serverMetrics collects counters fed from RemoteShellService and the
+// accept loop. All methods are safe for concurrent use.
+type serverMetrics struct {
+	mu                  sync.Mutex
+	connections         int
+	maxConnections      int
+	connectionsTotal    uint64
+	connectionsRejected uint64
+	commands            map[string]uint64 // By exit code ("timeout" for timeouts)
+	denials             map[string]uint64 // By reason
+	duration            *histogram
+	outputBytes         *histogram
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		commands:    make(map[string]uint64),
+		denials:     make(map[string]uint64),
+		duration:    newHistogram(durationBuckets),
+		outputBytes: newHistogram(outputBuckets),
+	}
+}
+
+func (m *serverMetrics) connOpened() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connections++
+	m.connectionsTotal++
+}
+
+func (m *serverMetrics) connClosed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connections--
+}
+
+func (m *serverMetrics) connRejected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connectionsRejected++
+}
+
+// deny counts a rejected request: auth, banned, rate, whitelist, chaining or session
+func (m *serverMetrics) deny(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.denials[reason]++
+}
+
+// denialCounts returns a copy of the denial counters
+func (m *serverMetrics) denialCounts() map[string]uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]uint64, len(m.denials))
+	for k, v := range m.denials {
+		out[k] = v
+	}
+	return out
+}
+
+// commandDone records one executed command
+func (m *serverMetrics) commandDone(exitCode int, timedOut bool, d time.Duration, outputLen int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	label := fmt.Sprintf("%d", exitCode)
+	if timedOut {
+		label = "timeout"
+	}
+	m.commands[label]++
+	m.duration.observe(d.Seconds())
+	m.outputBytes.observe(float64(outputLen))
+}
+
+// write renders all metrics in the Prometheus text exposition format. The
+// lock is only held while rendering into a buffer, so a slow scraper does
+// not stall every request that updates a counter.
+func (m *serverMetrics) write(w io.Writer, activeSessions int) {
+	var buf bytes.Buffer
+	m.mu.Lock()
+	m.render(&buf, activeSessions)
+	m.mu.Unlock()
+	w.Write(buf.Bytes())
+}
+
+// render writes the metrics to w. Caller must hold m.mu.
+func (m *serverMetrics) render(w io.Writer, activeSessions int) {
+	gauge := func(name, help string, v int) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, v)
+	}
+	counter := func(name, help string, v uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+	labelled := func(name, help, label string, values map[string]uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, escapeLabel(k), values[k])
+		}
+	}
+
+	gauge("remote_shell_active_sessions", "Sessions currently held by the server.", activeSessions)
+	gauge("remote_shell_connections", "Open client connections.", m.connections)
+	gauge("remote_shell_max_connections", "Configured --max-connections (0 = unlimited).", m.maxConnections)
+	counter("remote_shell_connections_total", "Connections accepted since start.", m.connectionsTotal)
+	counter("remote_shell_connections_rejected_total", "Connections rejected because --max-connections was reached.", m.connectionsRejected)
+	labelled("remote_shell_commands_total", "Commands executed, by exit code.", "exit_code", m.commands)
+	labelled("remote_shell_denials_total", "Requests denied, by reason.", "reason", m.denials)
+
+	fmt.Fprintf(w, "# HELP remote_shell_command_duration_seconds Command execution time.\n# TYPE remote_shell_command_duration_seconds histogram\n")
+	m.duration.write(w, "remote_shell_command_duration_seconds")
+	fmt.Fprintf(w, "# HELP remote_shell_command_output_bytes Command output size before truncation.\n# TYPE remote_shell_command_output_bytes histogram\n")
+	m.outputBytes.write(w, "remote_shell_command_output_bytes")
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// serveMetrics exposes the service metrics on /metrics
+func (r *RemoteShellService) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	active := len(r.sessions)
+	r.mu.RUnlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.metrics.write(w, active)
+}
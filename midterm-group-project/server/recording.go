@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"remote-shell-rpc/protocol"
+)
+
+// recordingStamp is the start time in recording file names
+const recordingStamp = "20060102-150405"
+
+// recorder writes one session's commands, stdin and output with their timing to
+// an asciicast v2 file (https://docs.asciinema.org/manual/asciicast/v2/),
+// so it can be replayed with admin replay or asciinema. A nil *recorder
+// records nothing. Write errors stop the recording but never the command.
+type recorder struct {
+	mu    sync.Mutex
+	f     *os.File
+	enc   *json.Encoder
+	start time.Time
+}
+
+// castHeader is the first line of an asciicast v2 file
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title"`
+	Env       map[string]string `json:"env"`
+}
+
+// newRecorder starts a recording of session id in dir
+func newRecorder(dir, id string, start time.Time) (*recorder, error) {
+	name := fmt.Sprintf("%s-%s.cast", recordingName(id), start.Format(recordingStamp))
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	rec := &recorder{f: f, enc: json.NewEncoder(f), start: start}
+	header := castHeader{
+		Version:   2,
+		Width:     80,
+		Height:    24,
+		Timestamp: start.Unix(),
+		Title:     "remote shell session " + id,
+		Env:       map[string]string{"SHELL": "/bin/sh", "TERM": "xterm-256color"},
+	}
+	if err := rec.enc.Encode(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return rec, nil
+}
+
+// recordingName makes a session ID safe to use in a file name
+func recordingName(id string) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+			return c
+		}
+		return '_'
+	}, id)
+}
+
+// event appends one [time, kind, data] line
+func (rec *recorder) event(kind, data string) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.f == nil {
+		return
+	}
+	t := float64(time.Since(rec.start).Microseconds()) / 1e6
+	if err := rec.enc.Encode([]interface{}{t, kind, data}); err != nil {
+		log.Printf("[Recording] Write to %s failed, recording stopped: %v", rec.f.Name(), err)
+		rec.f.Close()
+		rec.f = nil
+	}
+}
+
+// command records a line the client ran, echoed after a prompt the way the
+// interactive client shows it
+func (rec *recorder) command(id, line string) {
+	rec.event("i", line+"\n")
+	rec.event("o", fmt.Sprintf("[%s@remote]$ %s\r\n", id, line))
+}
+
+// result records how a command ended if it failed, like the interactive
+// client prints it
+func (rec *recorder) result(resp protocol.CommandResponse) {
+	if resp.ExitCode == 0 {
+		return
+	}
+	msg := fmt.Sprintf("Exit code: %d\r\n", resp.ExitCode)
+	if resp.Error != "" {
+		msg += resp.Error + "\r\n"
+	}
+	rec.event("o", msg)
+}
+
+// castStream records one stream of a command: its output ("o") or its
+// stdin ("i"). A write may end inside a multi-byte UTF-8 character, which
+// JSON would turn into U+FFFD, so the incomplete tail is held back until
+// the next write completes it.
+type castStream struct {
+	rec     *recorder
+	kind    string
+	pending []byte
+}
+
+// stream returns a writer recording a command's stream of kind "o" or "i".
+// Call flush when the command is done.
+func (rec *recorder) stream(kind string) *castStream {
+	return &castStream{rec: rec, kind: kind}
+}
+
+func (w *castStream) Write(p []byte) (int, error) {
+	data := append(w.pending, p...)
+	cut := completeUTF8(data)
+	w.pending = append(w.pending[:0:0], data[cut:]...)
+	if cut > 0 {
+		w.emit(data[:cut])
+	}
+	return len(p), nil
+}
+
+// flush records whatever is held back, complete or not
+func (w *castStream) flush() {
+	if len(w.pending) > 0 {
+		w.emit(w.pending)
+		w.pending = nil
+	}
+}
+
+// emit records p. Commands do not run on a terminal, so output newlines are
+// expanded the way a tty would.
+func (w *castStream) emit(p []byte) {
+	data := string(p)
+	if w.kind == "o" {
+		data = strings.ReplaceAll(data, "\n", "\r\n")
+	}
+	w.rec.event(w.kind, data)
+}
+
+// completeUTF8 returns the length of p without a trailing incomplete UTF-8
+// sequence. Invalid bytes count as complete; they cannot be fixed by
+// waiting.
+func completeUTF8(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if utf8.FullRune(p[i:]) {
+				return len(p)
+			}
+			return i
+		}
+	}
+	return len(p)
+}
+
+func (rec *recorder) close() {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.f != nil {
+		rec.f.Close()
+		rec.f = nil
+	}
+}
+
+// latestRecording returns the newest recording of session id
+func (r *RemoteShellService) latestRecording(id string) (string, error) {
+	prefix := recordingName(id) + "-"
+	entries, err := os.ReadDir(r.recordDir)
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		// The stamp has a fixed length, so "a-" does not match "a-b-..."
+		if strings.HasPrefix(name, prefix) && len(name) == len(prefix)+len(recordingStamp)+len(".cast") && strings.HasSuffix(name, ".cast") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("not found: no recording of session %s", id)
+	}
+	sort.Strings(names)
+	return names[len(names)-1], nil
+}
+
+// ReadRecording returns a chunk of the newest recording of a session, which
+// may still be growing (admin only)
+func (r *RemoteShellService) ReadRecording(req protocol.RecordingRequest, resp *protocol.RecordingChunk) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	if r.recordDir == "" {
+		return fmt.Errorf("not found: recording is disabled on this server")
+	}
+	name, err := r.latestRecording(req.ID)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filepath.Join(r.recordDir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if req.Offset == 0 {
+		r.audit.record(protocol.AuditEntry{Identity: r.identityFor(req.Token), ClientID: req.ID, Action: "replay", Detail: name, Outcome: "ok"})
+	}
+
+	length := req.Length
+	if length <= 0 || length > protocol.MaxChunkSize {
+		length = protocol.MaxChunkSize
+	}
+	buf := make([]byte, length)
+	n, err := f.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	resp.Name = name
+	resp.Data = buf[:n]
+	resp.Size = info.Size()
+	resp.EOF = req.Offset+int64(n) >= info.Size()
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// castEvents returns the [kind, data] pairs of a recording
+func castEvents(t *testing.T, path string) [][2]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	var events [][2]string
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			t.Fatalf("malformed event %q", scanner.Text())
+		}
+		kind, _ := event[1].(string)
+		data, _ := event[2].(string)
+		events = append(events, [2]string{kind, data})
+	}
+	return events
+}
+
+func TestCastStreamKeepsSplitRunes(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := newRecorder(dir, "a", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := rec.stream("o")
+	text := []byte("héllo ✓\n")
+	for i := range text {
+		out.Write(text[i : i+1])
+	}
+	out.Write([]byte{0xe2, 0x9c}) // cut short by the command exiting
+	out.flush()
+	rec.close()
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "a-*.cast"))
+	var got strings.Builder
+	for _, e := range castEvents(t, paths[0]) {
+		got.WriteString(e[1])
+	}
+	// Only the bytes that never formed a character are lost, one U+FFFD each
+	if want := "héllo ✓\r\n\ufffd\ufffd"; got.String() != want {
+		t.Fatalf("recorded %q, want %q", got.String(), want)
+	}
+}
+
+func TestRecordingHasStdin(t *testing.T) {
+	r := newTestService(t)
+	r.recordDir = t.TempDir()
+	reg := register(t, r, "dev", "")
+	var reply string
+	r.SetEnv(protocol.EnvRequest{ID: "dev", Token: "tok", Secret: reg.Secret, Key: "PW", Value: "hunter22", Sensitive: true}, &reply)
+
+	var resp protocol.CommandResponse
+	r.Execute(protocol.CommandRequest{ID: "dev", Token: "tok", Secret: reg.Secret, Command: "wc -l", Stdin: []byte("first\npw hunter22\n")}, &resp)
+	if resp.ExitCode != 0 {
+		t.Fatalf("Execute: %+v", resp)
+	}
+	r.mu.Lock()
+	r.sessions["dev"].recorder.close()
+	r.mu.Unlock()
+
+	paths, _ := filepath.Glob(filepath.Join(r.recordDir, "dev-*.cast"))
+	var input string
+	for _, e := range castEvents(t, paths[0]) {
+		if e[0] == "i" {
+			input += e[1]
+		}
+	}
+	if want := "wc -l\nfirst\npw [REDACTED]\n"; input != want {
+		t.Fatalf("recorded input %q, want %q", input, want)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// redactedText replaces every secret the redactor finds
+const redactedText = "[REDACTED]"
+
+// minSecretLen is the shortest env value redacted as a secret; shorter ones
+// ("1", "on") would blank out unrelated output
+const minSecretLen = 4
+
+// maxRedactLine is how much output without a newline redactWriter holds
+// back before passing it on anyway
+const maxRedactLine = 64 * 1024
+
+// redactor hides secrets in command output, audit entries and recordings:
+// matches of the --redact-rules regexes everywhere, and the values of env
+// vars marked secret in the session they belong to. Secret values are only
+// hidden from their own session's output, so another session cannot learn
+// them by echoing guesses and watching for [REDACTED]. Its lock is separate
+// from r.mu so the audit log can use it while r.mu is held.
+type redactor struct {
+	rules      []*regexp.Regexp // A rule with a group hides only the group's matches
+	secretKeys []string         // Globs of env var names that are always secret (--secret-env)
+
+	mu     sync.RWMutex
+	values map[string]map[string]string // Secret values by session ID, then key
+	sorted map[string][]string          // Each session's secret values, longest first
+}
+
+// loadRedactRules reads one regex per line from path; blank lines and lines
+// starting with # are skipped
+func loadRedactRules(path string) ([]*regexp.Regexp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []*regexp.Regexp
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		rules = append(rules, re)
+	}
+	return rules, scanner.Err()
+}
+
+// isSecretKey reports whether --secret-env makes key secret
+func (d *redactor) isSecretKey(key string) bool {
+	for _, pattern := range d.secretKeys {
+		if ok, _ := path.Match(pattern, strings.ToUpper(key)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// setSecret hides value from now on as the secret key of session id
+func (d *redactor) setSecret(id, key, value string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.values == nil {
+		d.values = make(map[string]map[string]string)
+	}
+	if d.values[id] == nil {
+		d.values[id] = make(map[string]string)
+	}
+	d.values[id][key] = value
+	d.resort(id)
+}
+
+// secret reports whether key is a secret variable of session id
+func (d *redactor) secret(id, key string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, ok := d.values[id][key]
+	return ok
+}
+
+// unsetSecret stops hiding the value of key in session id
+func (d *redactor) unsetSecret(id, key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.values[id], key)
+	d.resort(id)
+}
+
+// dropSession forgets the secrets of a session that ended
+func (d *redactor) dropSession(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.values, id)
+	delete(d.sorted, id)
+}
+
+// resort rebuilds the sorted secret values of session id. Longer values go
+// first so a secret containing another is hidden whole. Caller must hold
+// d.mu.
+func (d *redactor) resort(id string) {
+	seen := make(map[string]struct{})
+	var sorted []string
+	for _, v := range d.values[id] {
+		if _, dup := seen[v]; !dup && len(v) >= minSecretLen {
+			seen[v] = struct{}{}
+			sorted = append(sorted, v)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	if d.sorted == nil {
+		d.sorted = make(map[string][]string)
+	}
+	if len(sorted) == 0 {
+		delete(d.sorted, id)
+	} else {
+		d.sorted[id] = sorted
+	}
+}
+
+// active reports whether there is anything to redact in session id's text
+func (d *redactor) active(id string) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.rules) > 0 || len(d.sorted[id]) > 0
+}
+
+// text returns s, which belongs to session id, with every secret replaced:
+// rule matches and that session's secret values. With id empty only the
+// rules apply. A nil *redactor returns s.
+func (d *redactor) text(id, s string) string {
+	if d == nil || s == "" {
+		return s
+	}
+	d.mu.RLock()
+	for _, v := range d.sorted[id] {
+		s = strings.ReplaceAll(s, v, redactedText)
+	}
+	d.mu.RUnlock()
+	for _, re := range d.rules {
+		if re.NumSubexp() == 0 {
+			s = re.ReplaceAllLiteralString(s, redactedText)
+			continue
+		}
+		var out strings.Builder
+		last := 0
+		for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+			if m[2] < 0 {
+				continue
+			}
+			out.WriteString(s[last:m[2]])
+			out.WriteString(redactedText)
+			last = m[3]
+		}
+		out.WriteString(s[last:])
+		s = out.String()
+	}
+	return s
+}
+
+// redactWriter redacts session id's output on its way to w. It passes
+// output on a line at a time so a secret split across two writes is still
+// found; flushRedacted passes on the rest.
+type redactWriter struct {
+	d   *redactor
+	id  string
+	w   io.Writer
+	buf []byte
+}
+
+// newRedactWriter returns w itself if there is nothing to redact, so
+// partial lines are not held back needlessly
+func newRedactWriter(d *redactor, id string, w io.Writer) io.Writer {
+	if !d.active(id) {
+		return w
+	}
+	return &redactWriter{d: d, id: id, w: w}
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	end := bytes.LastIndexAny(w.buf, "\r\n") + 1
+	if len(w.buf) > maxRedactLine {
+		end = len(w.buf)
+	}
+	if end > 0 {
+		io.WriteString(w.w, w.d.text(w.id, string(w.buf[:end])))
+		w.buf = append(w.buf[:0], w.buf[end:]...)
+	}
+	return len(p), nil
+}
+
+// flushRedacted passes on what a redactWriter still holds back
+func flushRedacted(w io.Writer) {
+	if rw, ok := w.(*redactWriter); ok && len(rw.buf) > 0 {
+		io.WriteString(rw.w, rw.d.text(rw.id, string(rw.buf)))
+		rw.buf = rw.buf[:0]
+	}
+}
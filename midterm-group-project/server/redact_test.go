@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"remote-shell-rpc/protocol"
+)
+
+func TestRedactWriterSplitWrites(t *testing.T) {
+	d := &redactor{}
+	d.setSecret("a", "PW", "hunter22")
+	var out bytes.Buffer
+	w := newRedactWriter(d, "a", &out)
+	for _, chunk := range []string{"pass=hun", "ter22\nnext hunt", "er22 tail"} {
+		w.Write([]byte(chunk))
+	}
+	flushRedacted(w)
+	if want := "pass=[REDACTED]\nnext [REDACTED] tail"; out.String() != want {
+		t.Fatalf("redacted output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestRedactSecretsStayInTheirSession(t *testing.T) {
+	d := &redactor{rules: []*regexp.Regexp{regexp.MustCompile(`token=(\S+)`)}}
+	d.setSecret("a", "PW", "hunter22")
+
+	if got := d.text("a", "hunter22 token=abc"); got != "[REDACTED] token=[REDACTED]" {
+		t.Fatalf("own session: %q", got)
+	}
+	// Another session echoing a guess must not learn whether it is a secret
+	if got := d.text("b", "hunter22 token=abc"); got != "hunter22 token=[REDACTED]" {
+		t.Fatalf("other session: %q", got)
+	}
+	if !d.active("b") {
+		t.Fatal("rules should apply to every session")
+	}
+
+	d.dropSession("a")
+	if got := d.text("a", "hunter22"); got != "hunter22" {
+		t.Fatalf("after the session ended: %q", got)
+	}
+}
+
+func TestRedactSpoolAndRecording(t *testing.T) {
+	r := newTestService(t)
+	r.maxOutput = 16
+	r.spoolDir = t.TempDir()
+	r.recordDir = t.TempDir()
+	reg := register(t, r, "a", "")
+	other := register(t, r, "b", "")
+
+	var reply string
+	r.SetEnv(protocol.EnvRequest{ID: "a", Token: "tok", Secret: reg.Secret, Key: "PW", Value: "hunter22", Sensitive: true}, &reply)
+	if err := protocol.ReplyError(reply); err != nil {
+		t.Fatalf("SetEnv: %v", err)
+	}
+
+	var resp protocol.CommandResponse
+	r.Execute(protocol.CommandRequest{ID: "a", Token: "tok", Secret: reg.Secret, Command: `for i in 1 2 3 4 5; do echo "line $i $PW"; done`}, &resp)
+	if resp.ExitCode != 0 || resp.OutputHandle == "" {
+		t.Fatalf("Execute = %+v, want output spooled", resp)
+	}
+	if strings.Contains(resp.Output, "hunter22") {
+		t.Fatalf("response leaks the secret: %q", resp.Output)
+	}
+
+	r.mu.RLock()
+	spool := r.sessions["a"].spoolPath(resp.OutputHandle)
+	r.mu.RUnlock()
+	data, err := os.ReadFile(spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter22") || !strings.Contains(string(data), "line 5 [REDACTED]") {
+		t.Fatalf("spool = %q, want the secret redacted", data)
+	}
+
+	casts, _ := filepath.Glob(filepath.Join(r.recordDir, "a-*.cast"))
+	if len(casts) != 1 {
+		t.Fatalf("recordings of a: %v", casts)
+	}
+	data, err = os.ReadFile(casts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter22") || !strings.Contains(string(data), "[REDACTED]") {
+		t.Fatalf("recording = %q, want the secret redacted", data)
+	}
+
+	// The same text is not a secret in session b
+	var echo protocol.CommandResponse
+	r.Execute(protocol.CommandRequest{ID: "b", Token: "tok", Secret: other.Secret, Command: "echo hunter22"}, &echo)
+	if echo.Output != "hunter22\n" {
+		t.Fatalf("session b output = %q", echo.Output)
+	}
+}
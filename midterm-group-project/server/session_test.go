@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+func newTestService(t *testing.T) *RemoteShellService {
+	t.Helper()
+	r := NewRemoteShellService("tok", nil, 0, time.Minute, 10*time.Second, 64*1024, false)
+	t.Cleanup(func() { close(r.stopCleanup) })
+	return r
+}
+
+func register(t *testing.T, r *RemoteShellService, id, secret string) protocol.RegisterResponse {
+	t.Helper()
+	var resp protocol.RegisterResponse
+	if err := r.Register(RegisterRequest{ID: id, Token: "tok", Secret: secret}, &resp); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	return resp
+}
+
+func TestRegisterRefusesTakeoverWithoutSecret(t *testing.T) {
+	r := newTestService(t)
+	first := register(t, r, "deploy", "")
+	if first.Error != "" || first.Secret == "" {
+		t.Fatalf("first Register = %+v, want a new session with a secret", first)
+	}
+
+	if resp := register(t, r, "deploy", ""); resp.Error == "" {
+		t.Fatalf("Register without the secret took over the session: %+v", resp)
+	}
+	if resp := register(t, r, "deploy", "not-the-secret"); resp.Error == "" {
+		t.Fatalf("Register with a wrong secret took over the session: %+v", resp)
+	}
+
+	var reply string
+	r.EndSession(protocol.EndSessionRequest{ID: "deploy", Token: "tok", Secret: "not-the-secret"}, &reply)
+	if protocol.ReplyError(reply) == nil {
+		t.Fatalf("EndSession with a wrong secret = %q, want an error", reply)
+	}
+
+	resumed := register(t, r, "deploy", first.Secret)
+	if resumed.Error != "" || !resumed.Resumed {
+		t.Fatalf("Register with the secret = %+v, want the session resumed", resumed)
+	}
+}
+
+func TestRegisterAfterEndSession(t *testing.T) {
+	r := newTestService(t)
+	first := register(t, r, "deploy", "")
+	if first.Error != "" {
+		t.Fatalf("first Register: %s", first.Error)
+	}
+	var reply string
+	r.SetEnv(protocol.EnvRequest{ID: "deploy", Token: "tok", Secret: first.Secret, Key: "STAGE", Value: "one"}, &reply)
+	if err := protocol.ReplyError(reply); err != nil {
+		t.Fatalf("SetEnv: %v", err)
+	}
+
+	r.EndSession(protocol.EndSessionRequest{ID: "deploy", Token: "tok", Secret: first.Secret}, &reply)
+	if err := protocol.ReplyError(reply); err != nil {
+		t.Fatalf("EndSession: %v", err)
+	}
+
+	// A second run of the same client, which has no secret, starts afresh
+	second := register(t, r, "deploy", "")
+	if second.Error != "" || second.Resumed {
+		t.Fatalf("Register after EndSession = %+v, want a new session", second)
+	}
+	if second.Secret == first.Secret {
+		t.Fatal("new session reuses the old secret")
+	}
+	var env protocol.EnvValueResponse
+	r.GetEnv(protocol.EnvKeyRequest{ID: "deploy", Token: "tok", Secret: second.Secret, Key: "STAGE"}, &env)
+	if env.Exists {
+		t.Fatalf("new session inherited STAGE=%q from the ended one", env.Value)
+	}
+
+	// The old secret no longer opens anything
+	r.Heartbeat(protocol.HeartbeatRequest{ID: "deploy", Token: "tok", Secret: first.Secret}, &reply)
+	if protocol.ReplyError(reply) == nil {
+		t.Fatalf("Heartbeat with the ended session's secret = %q, want an error", reply)
+	}
+}
+
+func TestKillSessionStopsRunningCommand(t *testing.T) {
+	r := newTestService(t)
+	reg := register(t, r, "deploy", "")
+	if reg.Error != "" {
+		t.Fatalf("Register: %s", reg.Error)
+	}
+
+	result := make(chan protocol.CommandResponse, 1)
+	go func() {
+		var resp protocol.CommandResponse
+		r.Execute(protocol.CommandRequest{ID: "deploy", Token: "tok", Secret: reg.Secret, Command: "sleep 30"}, &resp)
+		result <- resp
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		r.mu.RLock()
+		started := len(r.jobs) > 0
+		r.mu.RUnlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("command never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var reply string
+	r.KillSession(protocol.KillSessionRequest{ID: "deploy", Token: "tok"}, &reply)
+	r.mu.RLock()
+	jobs := len(r.jobs)
+	r.mu.RUnlock()
+	if jobs != 0 {
+		t.Fatalf("%d jobs left after KillSession (%s)", jobs, reply)
+	}
+
+	select {
+	case resp := <-result:
+		if resp.ExitCode != -1 || resp.Error != "Command killed: session ended" {
+			t.Fatalf("Execute = exit %d %q, want the command killed", resp.ExitCode, resp.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("command still running after KillSession")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// sessionInfo describes s. running counts commands per client ID. Caller
+// must hold r.mu.
+func (r *RemoteShellService) sessionInfo(s *Session, running map[string]int, now time.Time) protocol.SessionInfo {
+	info := protocol.SessionInfo{
+		Version:     protocol.SessionInfoVersion,
+		ID:          s.ID,
+		Owner:       s.Owner,
+		WorkDir:     s.WorkDir,
+		EnvCount:    len(s.Env),
+		ConnectedAt: s.ConnectedAt,
+		LastActive:  s.LastActive,
+		Active:      now.Sub(s.LastActive) < r.sessionTimeout,
+		Commands:    s.nextSeq,
+		Running:     running[s.ID],
+	}
+	for c := range s.conns {
+		info.Connections = append(info.Connections, protocol.ConnInfo{
+			RemoteAddr:  c.RemoteAddr().String(),
+			TLSIdentity: c.tlsIdentity(),
+			ConnectedAt: c.connectedAt,
+			BytesIn:     c.bytesIn.Load(),
+			BytesOut:    c.bytesOut.Load(),
+		})
+	}
+	sort.Slice(info.Connections, func(i, j int) bool {
+		return info.Connections[i].ConnectedAt.Before(info.Connections[j].ConnectedAt)
+	})
+	return info
+}
+
+// runningByClient counts running commands per client ID. Caller must hold r.mu.
+func (r *RemoteShellService) runningByClient() map[string]int {
+	out := make(map[string]int)
+	for _, j := range r.jobs {
+		out[j.ClientID]++
+	}
+	return out
+}
+
+// GetSessionInfoV2 describes one session to its owner or an admin. Without
+// --admin-token the admin token is the auth token every client holds, so
+// only a configured admin token opens other sessions.
+func (r *RemoteShellService) GetSessionInfoV2(req protocol.SessionInfoRequest, resp *protocol.SessionInfo) error {
+	admin := req.Secret == "" && r.adminToken != "" && r.adminTokenOK(req.Token)
+	if !admin && !r.validateToken(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	session, exists := r.sessions[req.ID]
+	if !exists {
+		return fmt.Errorf("not found: no session %s", req.ID)
+	}
+	if !admin && !r.authorizeSession(session, req.Token, req.Secret, "GetSessionInfo") {
+		return fmt.Errorf("invalid session secret")
+	}
+	*resp = r.sessionInfo(session, r.runningByClient(), time.Now())
+	return nil
+}
+
+// ListSessionsV2 describes every session, sorted by ID (admin only)
+func (r *RemoteShellService) ListSessionsV2(req protocol.ListSessionsRequest, resp *protocol.SessionList) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	*resp = protocol.SessionList{Version: protocol.SessionInfoVersion, Sessions: r.allSessionInfo()}
+	return nil
+}
+
+func (r *RemoteShellService) allSessionInfo() []protocol.SessionInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	running := r.runningByClient()
+	now := time.Now()
+	out := make([]protocol.SessionInfo, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		out = append(out, r.sessionInfo(s, running, now))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// legacySessionMap renders info in the map form ListSessions has always
+// returned, for admin binaries that predate SessionInfo
+func legacySessionMap(info protocol.SessionInfo, now time.Time) map[string]interface{} {
+	var addrs, identities []string
+	var bytesIn, bytesOut int64
+	var since time.Time
+	for _, c := range info.Connections {
+		addrs = append(addrs, c.RemoteAddr)
+		identities = append(identities, c.TLSIdentity)
+		bytesIn += c.BytesIn
+		bytesOut += c.BytesOut
+		if since.IsZero() || c.ConnectedAt.Before(since) {
+			since = c.ConnectedAt
+		}
+	}
+	m := map[string]interface{}{
+		"id":           info.ID,
+		"work_dir":     info.WorkDir,
+		"env_count":    info.EnvCount,
+		"connected_at": info.ConnectedAt.Format(time.RFC3339),
+		"last_active":  info.LastActive.Format(time.RFC3339),
+		"age":          now.Sub(info.ConnectedAt).String(),
+		"idle":         now.Sub(info.LastActive).String(),
+		"is_active":    info.Active,
+		"connected":    len(info.Connections) > 0,
+		"connections":  len(info.Connections),
+		"remote_addr":  strings.Join(addrs, ","),
+		"tls_identity": strings.Join(identities, ","),
+		"bytes_in":     bytesIn,
+		"bytes_out":    bytesOut,
+	}
+	if !since.IsZero() {
+		m["conn_since"] = since.Format(time.RFC3339)
+	}
+	return m
+}
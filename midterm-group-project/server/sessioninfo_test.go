@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"remote-shell-rpc/protocol"
+)
+
+func TestSessionInfoNeedsConfiguredAdminToken(t *testing.T) {
+	r := newTestService(t)
+	reg := register(t, r, "dev", "")
+
+	var info protocol.SessionInfo
+	if err := r.GetSessionInfoV2(protocol.SessionInfoRequest{ID: "dev", Token: "tok", Secret: reg.Secret}, &info); err != nil || info.ID != "dev" {
+		t.Fatalf("owner lookup = %+v, %v", info, err)
+	}
+	// Without --admin-token the auth token alone must not open the session
+	if err := r.GetSessionInfoV2(protocol.SessionInfoRequest{ID: "dev", Token: "tok"}, &info); err == nil {
+		t.Fatal("auth token without a secret read another session")
+	}
+
+	r.adminToken = "admin"
+	info = protocol.SessionInfo{}
+	if err := r.GetSessionInfoV2(protocol.SessionInfoRequest{ID: "dev", Token: "admin"}, &info); err != nil || info.ID != "dev" {
+		t.Fatalf("admin lookup = %+v, %v", info, err)
+	}
+	if err := r.GetSessionInfoV2(protocol.SessionInfoRequest{ID: "dev", Token: "tok"}, &info); err == nil {
+		t.Fatal("auth token without a secret read another session")
+	}
+}
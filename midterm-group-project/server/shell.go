@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// streamPollWait bounds each event poll behind a stream, so a stream whose
+// caller went away stops polling soon after
+const streamPollWait = 5 * time.Second
+
+// errShuttingDown ends streams when the server shuts down
+var errShuttingDown = errors.New("server shutting down")
+
+// shellStream is the sending side of an interactive session on a transport
+// that can push (gRPC, WebSocket). Calls are serialised by runShell.
+type shellStream interface {
+	sendOutput(p []byte) error
+	sendResult(resp protocol.CommandResponse) error
+	sendEvent(e protocol.Event) error
+}
+
+// lockedStream serialises sends from the command and the event forwarder
+type lockedStream struct {
+	mu sync.Mutex
+	s  shellStream
+}
+
+func (l *lockedStream) sendOutput(p []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.sendOutput(p)
+}
+
+func (l *lockedStream) sendResult(resp protocol.CommandResponse) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.sendResult(resp)
+}
+
+func (l *lockedStream) sendEvent(e protocol.Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.sendEvent(e)
+}
+
+// sendFunc adapts a stream's send to io.Writer for command output
+type sendFunc func(p []byte) error
+
+func (f sendFunc) Write(p []byte) (int, error) {
+	if err := f(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// runShell serves an interactive session: it runs each line recv returns
+// ("cd <dir>" changes directory) and forwards broadcasts and the end of the
+// session in between. It returns nil once recv returns io.EOF or the
+// session ends, errShuttingDown on shutdown, and the refusal if the
+// credentials do not open the session. ctx must be cancelled when the
+// transport goes away.
+func (r *RemoteShellService) runShell(ctx context.Context, transport, id, token, secret string, recv func() (string, error), out shellStream) error {
+	var reply string
+	r.Heartbeat(protocol.HeartbeatRequest{ID: id, Token: token, Secret: secret}, &reply)
+	if err := protocol.ReplyError(reply); err != nil {
+		return err
+	}
+	log.Printf("[Client %s] %s shell opened", id, transport)
+	defer log.Printf("[Client %s] %s shell closed", id, transport)
+
+	// The event forwarder is stopped and waited for before returning, so it
+	// never sends on a stream the transport has finished with
+	ctx, cancel := context.WithCancel(ctx)
+	var forwarder sync.WaitGroup
+	defer forwarder.Wait()
+	defer cancel()
+
+	out = &lockedStream{s: out}
+	ended := make(chan error, 1)
+	forwarder.Add(1)
+	go func() {
+		defer forwarder.Done()
+		ended <- r.forwardEvents(ctx, id, token, secret, out)
+	}()
+
+	lines := make(chan string)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			line, err := recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case line := <-lines:
+			if err := r.shellLine(ctx, id, token, secret, line, out); err != nil {
+				return err
+			}
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case err := <-ended:
+			return err
+		}
+	}
+}
+
+// shellLine runs one line of an interactive session and sends its output
+// and result
+func (r *RemoteShellService) shellLine(ctx context.Context, id, token, secret, line string, out shellStream) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	r.calls.begin()
+	defer r.calls.end()
+
+	var resp protocol.CommandResponse
+	if strings.HasPrefix(line, "cd ") {
+		var reply string
+		r.ChangeDir(protocol.DirRequest{ID: id, Token: token, Secret: secret, Dir: strings.TrimSpace(line[3:])}, &reply)
+		if err := protocol.ReplyError(reply); err != nil {
+			resp.ExitCode, resp.Error = 1, err.Error()
+		}
+	} else {
+		r.execute(ctx, protocol.CommandRequest{ID: id, Token: token, Secret: secret, Command: line}, sendFunc(out.sendOutput), &resp)
+	}
+	return out.sendResult(resp)
+}
+
+// forwardEvents sends a session's broadcasts and approval news to out for
+// runShell. It returns nil once it has sent the end of the session,
+// errShuttingDown when the server shuts down, and ctx's error as soon as
+// ctx is done, without sending anything more.
+func (r *RemoteShellService) forwardEvents(ctx context.Context, id, token, secret string, out shellStream) error {
+	after := int64(-1)
+	for ctx.Err() == nil {
+		var resp protocol.PollResponse
+		r.pollEvents(ctx, protocol.PollRequest{ID: id, Token: token, Secret: secret, After: after, Wait: streamPollWait}, &resp)
+		if resp.Error != "" {
+			return errors.New(resp.Error)
+		}
+		for _, e := range resp.Events {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := out.sendEvent(e); err != nil {
+				return err
+			}
+			if e.Kind == "session-end" {
+				return nil
+			}
+		}
+		if resp.Closed {
+			return errShuttingDown
+		}
+		after = resp.Next
+	}
+	return ctx.Err()
+}
+
+// watchStream pushes a session's events to an admin until the session ends
+// (nil), the server shuts down (errShuttingDown) or ctx is done
+func (r *RemoteShellService) watchStream(ctx context.Context, id, token string, send func(protocol.Event) error) error {
+	after := int64(-1)
+	for ctx.Err() == nil {
+		var resp protocol.PollResponse
+		if err := r.WatchSession(protocol.PollRequest{ID: id, Token: token, After: after, Wait: streamPollWait}, &resp); err != nil {
+			return err
+		}
+		for _, e := range resp.Events {
+			if err := send(e); err != nil {
+				return err
+			}
+			if e.Kind == "session-end" {
+				return nil
+			}
+		}
+		if resp.Closed {
+			return errShuttingDown
+		}
+		after = resp.Next
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// maxSpools is how many spooled outputs a session keeps; older ones are
+// deleted as new ones arrive
+const maxSpools = 8
+
+// outputSpool collects a command's output. The first head bytes stay in
+// memory for the response; once the output grows past them all of it goes
+// to a temporary file in dir, up to limit bytes (0 = unlimited), so the
+// client can fetch the rest with FetchOutput.
+type outputSpool struct {
+	dir   string
+	head  int
+	limit int64
+
+	buf    bytes.Buffer
+	f      *os.File
+	stored int64 // Bytes in f
+	size   int64 // Bytes the command wrote
+	failed bool  // The spool file could not be written; output is cut at head
+}
+
+func (s *outputSpool) Write(p []byte) (int, error) {
+	s.size += int64(len(p))
+	if s.f == nil && !s.failed && s.head > 0 && s.buf.Len()+len(p) > s.head {
+		s.spill()
+	}
+	if s.head <= 0 {
+		s.buf.Write(p)
+	} else if room := s.head - s.buf.Len(); room > 0 {
+		s.buf.Write(p[:min(len(p), room)])
+	}
+	if s.f == nil {
+		return len(p), nil
+	}
+	keep := p
+	if s.limit > 0 && s.stored+int64(len(keep)) > s.limit {
+		keep = keep[:max(0, s.limit-s.stored)]
+	}
+	if len(keep) > 0 {
+		n, err := s.f.Write(keep)
+		s.stored += int64(n)
+		if err != nil {
+			log.Printf("[Spool] Write to %s failed, rest of output dropped: %v", s.f.Name(), err)
+			s.limit = s.stored
+		}
+	}
+	return len(p), nil
+}
+
+// spill moves the output so far to a new spool file
+func (s *outputSpool) spill() {
+	f, err := os.CreateTemp(s.dir, "rshell-output-*")
+	if err != nil {
+		log.Printf("[Spool] Cannot spool output, cutting it at %d bytes: %v", s.head, err)
+		s.failed = true
+		return
+	}
+	n, err := f.Write(s.buf.Bytes())
+	if err != nil {
+		log.Printf("[Spool] Write to %s failed, cutting output at %d bytes: %v", f.Name(), s.head, err)
+		f.Close()
+		os.Remove(f.Name())
+		s.failed = true
+		return
+	}
+	s.f, s.stored = f, int64(n)
+}
+
+// finish closes the spool file and returns the output's head and the
+// file's path, which is empty if the output fit in the head
+func (s *outputSpool) finish() (string, string) {
+	if s.f == nil {
+		return s.buf.String(), ""
+	}
+	s.f.Close()
+	return s.buf.String(), s.f.Name()
+}
+
+// spooledOutput is a command's full output kept on disk for FetchOutput
+type spooledOutput struct {
+	handle string
+	path   string
+}
+
+// addSpool keeps path as the session's newest spooled output and returns
+// its handle. Caller must hold r.mu.
+func (s *Session) addSpool(path string) string {
+	s.nextSpool++
+	handle := strconv.Itoa(s.nextSpool)
+	s.spools = append(s.spools, spooledOutput{handle: handle, path: path})
+	if over := len(s.spools) - maxSpools; over > 0 {
+		for _, old := range s.spools[:over] {
+			os.Remove(old.path)
+		}
+		s.spools = append(s.spools[:0:0], s.spools[over:]...)
+	}
+	return handle
+}
+
+// spoolPath returns the file of a spooled output, or "" if it is gone.
+// Caller must hold r.mu.
+func (s *Session) spoolPath(handle string) string {
+	for _, sp := range s.spools {
+		if sp.handle == handle {
+			return sp.path
+		}
+	}
+	return ""
+}
+
+// dropSpools deletes a session's spooled outputs and unused stdin uploads.
+// Caller must hold r.mu.
+func (s *Session) dropSpools() {
+	for _, sp := range s.spools {
+		os.Remove(sp.path)
+	}
+	for _, path := range s.stdins {
+		os.Remove(path)
+	}
+	s.spools, s.stdins = nil, nil
+}
+
+// copySpool writes a session's spooled output from offset on to w, for
+// transports without FetchOutput
+func (r *RemoteShellService) copySpool(id, handle string, offset int64, w io.Writer) error {
+	var path string
+	r.mu.RLock()
+	if session, ok := r.sessions[id]; ok {
+		path = session.spoolPath(handle)
+	}
+	r.mu.RUnlock()
+	if path == "" {
+		return fmt.Errorf("output %q is gone", handle)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// FetchOutput returns a chunk of a command's full output, for commands
+// whose response was cut (see CommandResponse.OutputHandle)
+func (r *RemoteShellService) FetchOutput(req protocol.FetchOutputRequest, resp *protocol.OutputChunk) error {
+	if !r.validateToken(req.Token) {
+		resp.Error = "unauthorized"
+		return nil
+	}
+	if r.isBanned(req.ID) {
+		resp.Error = "banned"
+		return nil
+	}
+	// Only the first chunk counts against the rate limit, as for Download
+	if req.Offset == 0 && !r.consumeRate(req.ID) {
+		resp.Error = "rate limit exceeded"
+		return nil
+	}
+
+	r.mu.Lock()
+	session, exists := r.sessions[req.ID]
+	if !exists {
+		r.mu.Unlock()
+		resp.Error = "client not registered"
+		return nil
+	}
+	if !r.authorizeSession(session, req.Token, req.Secret, "FetchOutput") {
+		r.mu.Unlock()
+		resp.Error = "invalid session secret"
+		return nil
+	}
+	session.LastActive = time.Now()
+	path := session.spoolPath(req.Handle)
+	r.mu.Unlock()
+	if path == "" {
+		resp.Error = fmt.Sprintf("not found: no output %q (sessions keep the last %d)", req.Handle, maxSpools)
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		resp.Error = fmt.Sprintf("not found: output %q is gone", req.Handle)
+		return nil
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		resp.Error = err.Error()
+		return nil
+	}
+	length := req.Length
+	if length <= 0 || length > protocol.MaxChunkSize {
+		length = protocol.MaxChunkSize
+	}
+	buf := make([]byte, length)
+	n, err := f.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		resp.Error = err.Error()
+		return nil
+	}
+	resp.Data = buf[:n]
+	resp.Size = info.Size()
+	resp.EOF = req.Offset+int64(n) >= info.Size()
+	return nil
+}
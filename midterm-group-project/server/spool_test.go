@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"remote-shell-rpc/protocol"
+)
+
+func TestOutputSpoolCutsAtLimit(t *testing.T) {
+	s := &outputSpool{dir: t.TempDir(), head: 4, limit: 10}
+	s.Write([]byte("0123456"))
+	s.Write([]byte("789abcdef"))
+	head, path := s.finish()
+	if head != "0123" || s.size != 16 {
+		t.Fatalf("head %q, size %d, want %q and 16", head, s.size, "0123")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+	if string(data) != "0123456789" {
+		t.Fatalf("spool file = %q, want the first 10 bytes", data)
+	}
+
+	// Output that fits in the head never touches the disk
+	small := &outputSpool{dir: t.TempDir(), head: 4}
+	small.Write([]byte("ok\n"))
+	if head, path := small.finish(); head != "ok\n" || path != "" {
+		t.Fatalf("small output = %q in %q", head, path)
+	}
+}
+
+// newSpoolService returns a service that cuts responses at 16 bytes, with a
+// registered session "dev"
+func newSpoolService(t *testing.T) (*RemoteShellService, string) {
+	t.Helper()
+	r := newTestService(t)
+	r.maxOutput = 16
+	r.spoolDir = t.TempDir()
+	reg := register(t, r, "dev", "")
+	return r, reg.Secret
+}
+
+func fetch(r *RemoteShellService, secret, handle string, offset int64, length int) protocol.OutputChunk {
+	var chunk protocol.OutputChunk
+	r.FetchOutput(protocol.FetchOutputRequest{ID: "dev", Token: "tok", Secret: secret, Handle: handle, Offset: offset, Length: length}, &chunk)
+	return chunk
+}
+
+func TestFetchOutputChunks(t *testing.T) {
+	r, secret := newSpoolService(t)
+	var resp protocol.CommandResponse
+	r.Execute(protocol.CommandRequest{ID: "dev", Token: "tok", Secret: secret, Command: "seq 1 100"}, &resp)
+	var want strings.Builder
+	for i := 1; i <= 100; i++ {
+		fmt.Fprintln(&want, i)
+	}
+	if resp.Output != want.String()[:16] || resp.OutputSize != int64(want.Len()) || resp.OutputHandle == "" {
+		t.Fatalf("Execute = %q, size %d, handle %q", resp.Output, resp.OutputSize, resp.OutputHandle)
+	}
+
+	var got []byte
+	for offset := int64(0); ; {
+		chunk := fetch(r, secret, resp.OutputHandle, offset, 100)
+		if chunk.Error != "" {
+			t.Fatalf("FetchOutput at %d: %s", offset, chunk.Error)
+		}
+		if len(chunk.Data) > 100 || chunk.Size != resp.OutputSize {
+			t.Fatalf("chunk at %d: %d bytes, size %d", offset, len(chunk.Data), chunk.Size)
+		}
+		got = append(got, chunk.Data...)
+		offset += int64(len(chunk.Data))
+		if chunk.EOF {
+			break
+		}
+	}
+	if string(got) != want.String() {
+		t.Fatalf("fetched %q, want %q", got, want.String())
+	}
+
+	if chunk := fetch(r, secret, resp.OutputHandle, resp.OutputSize+10, 100); chunk.Error != "" || len(chunk.Data) != 0 || !chunk.EOF {
+		t.Fatalf("chunk past the end = %+v, want an empty EOF", chunk)
+	}
+	if chunk := fetch(r, secret, resp.OutputHandle, 0, 0); int64(len(chunk.Data)) != resp.OutputSize || !chunk.EOF {
+		t.Fatalf("chunk without a length = %d bytes, want all %d", len(chunk.Data), resp.OutputSize)
+	}
+	if chunk := fetch(r, "wrong", resp.OutputHandle, 0, 0); chunk.Error != "invalid session secret" {
+		t.Fatalf("FetchOutput with a wrong secret = %+v", chunk)
+	}
+	if chunk := fetch(r, secret, "nope", 0, 0); !strings.HasPrefix(chunk.Error, "not found") {
+		t.Fatalf("FetchOutput of an unknown handle = %+v", chunk)
+	}
+}
+
+func TestFetchOutputRespectsSpoolLimit(t *testing.T) {
+	r, secret := newSpoolService(t)
+	r.maxSpool = 50
+	var resp protocol.CommandResponse
+	r.Execute(protocol.CommandRequest{ID: "dev", Token: "tok", Secret: secret, Command: "seq 1 100"}, &resp)
+	chunk := fetch(r, secret, resp.OutputHandle, 0, 0)
+	if chunk.Size != 50 || len(chunk.Data) != 50 || resp.OutputSize <= chunk.Size {
+		t.Fatalf("kept %d of %d bytes (%d fetched), want 50", chunk.Size, resp.OutputSize, len(chunk.Data))
+	}
+}
+
+func TestSpoolsCleanedUp(t *testing.T) {
+	r, secret := newSpoolService(t)
+	var handles []string
+	for i := 0; i < maxSpools+2; i++ {
+		var resp protocol.CommandResponse
+		r.Execute(protocol.CommandRequest{ID: "dev", Token: "tok", Secret: secret, Command: "seq 1 20"}, &resp)
+		handles = append(handles, resp.OutputHandle)
+	}
+	if chunk := fetch(r, secret, handles[0], 0, 0); !strings.HasPrefix(chunk.Error, "not found") {
+		t.Fatalf("oldest output still readable: %+v", chunk)
+	}
+	if chunk := fetch(r, secret, handles[len(handles)-1], 0, 0); chunk.Error != "" {
+		t.Fatalf("newest output: %s", chunk.Error)
+	}
+	if files, _ := os.ReadDir(r.spoolDir); len(files) != maxSpools {
+		t.Fatalf("%d spool files, want %d", len(files), maxSpools)
+	}
+
+	// Ending the session deletes its spools, and the handles stop working
+	var reply string
+	r.EndSession(protocol.EndSessionRequest{ID: "dev", Token: "tok", Secret: secret}, &reply)
+	if files, _ := os.ReadDir(r.spoolDir); len(files) != 0 {
+		t.Fatalf("spool files left after the session ended: %v", files)
+	}
+	if chunk := fetch(r, secret, handles[len(handles)-1], 0, 0); chunk.Error != "client not registered" {
+		t.Fatalf("FetchOutput after the session ended = %+v", chunk)
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+
+	"remote-shell-rpc/protocol"
+)
+
+// sshServer is the SSH front end. Keys in an authorized_keys file map to
+// users (the comment after each key), the login name picks the session, and
+// exec and shell channels run through the same Execute path as the other
+// transports, under the identity "ssh:<user>".
+type sshServer struct {
+	svc    *RemoteShellService
+	config *ssh.ServerConfig
+	users  map[string]string // Key fingerprint -> user
+	tokens map[string]string // User -> token minted for them in this process
+
+	mu      sync.Mutex
+	secrets map[string]string // "<user>/<session>" -> session secret, to resume on reconnect
+}
+
+// SSH request payloads (RFC 4254)
+type ptyRequestMsg struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
+type windowChangeMsg struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+type envRequestMsg struct {
+	Name  string
+	Value string
+}
+
+type execRequestMsg struct {
+	Command string
+}
+
+type exitStatusMsg struct {
+	Status uint32
+}
+
+// startSSHServer serves the SSH front end on addr in the background. With no
+// host key file a temporary key is generated.
+func startSSHServer(addr, hostKeyPath, authorizedKeysPath string, service *RemoteShellService) (net.Listener, error) {
+	users, err := loadAuthorizedKeys(authorizedKeysPath)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := loadHostKey(hostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &sshServer{svc: service, users: users, tokens: make(map[string]string), secrets: make(map[string]string)}
+	for _, user := range users {
+		if _, ok := s.tokens[user]; ok {
+			continue
+		}
+		token, err := newSessionSecret()
+		if err != nil {
+			return nil, err
+		}
+		s.tokens[user] = token
+		service.principals[token] = "ssh:" + user
+	}
+	s.config = &ssh.ServerConfig{PublicKeyCallback: s.checkKey}
+	s.config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go s.serve(listener)
+	log.Printf("SSH available at %s (%d keys, host key %s)", addr, len(users), ssh.FingerprintSHA256(signer.PublicKey()))
+	return listener, nil
+}
+
+// loadAuthorizedKeys reads an authorized_keys file. The comment after each
+// key is the user it belongs to.
+func loadAuthorizedKeys(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, fmt.Errorf("--ssh-authorized-keys is required with --ssh-addr")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		fields := strings.Fields(comment)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("%s:%d: no user name after the key", path, n)
+		}
+		users[ssh.FingerprintSHA256(key)] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	return users, nil
+}
+
+func loadHostKey(path string) (ssh.Signer, error) {
+	if path == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("[SSH] No --ssh-host-key, using a temporary host key; clients will see a new key after every restart")
+		return ssh.NewSignerFromKey(key)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(data)
+}
+
+func (s *sshServer) checkKey(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	user, ok := s.users[ssh.FingerprintSHA256(key)]
+	if !ok {
+		log.Printf("[SSH] Unknown key %s offered for %s from %s", ssh.FingerprintSHA256(key), meta.User(), meta.RemoteAddr())
+		return nil, fmt.Errorf("unknown public key")
+	}
+	return &ssh.Permissions{Extensions: map[string]string{"user": user}}, nil
+}
+
+func (s *sshServer) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[SSH] Error accepting connection: %v", err)
+			continue
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn serves one SSH connection. It counts against --max-connections
+// and is closed when idle like an RPC connection.
+func (s *sshServer) handleConn(raw net.Conn) {
+	if !s.svc.connLimit.acquire() {
+		raw.Close()
+		s.svc.metrics.connRejected()
+		log.Printf("[SSH] Max connections reached, rejecting connection from %s", raw.RemoteAddr())
+		return
+	}
+	defer s.svc.connLimit.release()
+
+	conn := newIdleConn(raw, s.svc.idleTimeout)
+	handshake := time.AfterFunc(30*time.Second, func() { conn.closeWithReason("handshake timed out") })
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	handshake.Stop()
+	if err != nil {
+		log.Printf("[SSH] Handshake with %s failed: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	user := sconn.Permissions.Extensions["user"]
+	log.Printf("[SSH] %s connected as %s to session %s", conn.RemoteAddr(), user, sconn.User())
+	s.svc.metrics.connOpened()
+	defer s.svc.metrics.connClosed()
+
+	c := &sshConn{srv: s, conn: conn, user: user, id: sconn.User(), token: s.tokens[user]}
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go c.serveChannel(ch, requests)
+	}
+	log.Printf("[SSH] %s disconnected (%s, session %s, %s)", conn.RemoteAddr(), user, c.id, conn.disconnectReason())
+}
+
+// sshConn is one SSH connection. Its channels share one session, registered
+// when the first of them needs it.
+type sshConn struct {
+	srv   *sshServer
+	conn  *idleConn
+	user  string
+	id    string
+	token string
+
+	mu     sync.Mutex
+	secret string
+}
+
+// register opens the connection's session, resuming the one this user
+// last had under the same name
+func (c *sshConn) register() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.secret != "" {
+		return nil
+	}
+	key := c.user + "/" + c.id
+	c.srv.mu.Lock()
+	secret := c.srv.secrets[key]
+	c.srv.mu.Unlock()
+
+	var resp protocol.RegisterResponse
+	if err := c.srv.svc.Register(RegisterRequest{ID: c.id, Token: c.token, Secret: secret}, &resp); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	c.srv.mu.Lock()
+	c.srv.secrets[key] = resp.Secret
+	c.srv.mu.Unlock()
+	c.secret = resp.Secret
+	return nil
+}
+
+func (c *sshConn) setEnv(key, value string) error {
+	var reply string
+	c.srv.svc.SetEnv(protocol.EnvRequest{ID: c.id, Token: c.token, Secret: c.secret, Key: key, Value: value}, &reply)
+	return protocol.ReplyError(reply)
+}
+
+// sshChannel tracks the terminal size a client reported for one channel
+type sshChannel struct {
+	mu          sync.Mutex
+	pty         bool
+	cols, rows  uint32
+	sizeChanged bool
+	terminal    *term.Terminal // Line editor of a shell with a pty
+}
+
+func (t *sshChannel) resize(cols, rows uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cols, t.rows, t.sizeChanged = cols, rows, true
+	if t.terminal != nil {
+		t.terminal.SetSize(int(cols), int(rows))
+	}
+}
+
+// applySize exports a changed terminal size to the session as COLUMNS and
+// LINES, for the next command
+func (t *sshChannel) applySize(c *sshConn) {
+	t.mu.Lock()
+	cols, rows, changed := t.cols, t.rows, t.sizeChanged
+	t.sizeChanged = false
+	t.mu.Unlock()
+	if changed && cols > 0 && rows > 0 {
+		c.setEnv("COLUMNS", strconv.Itoa(int(cols)))
+		c.setEnv("LINES", strconv.Itoa(int(rows)))
+	}
+}
+
+// serveChannel answers a session channel's requests. One exec or shell runs
+// per channel; closing the channel kills its command.
+func (c *sshConn) serveChannel(ch ssh.Channel, requests <-chan *ssh.Request) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	t := &sshChannel{}
+	started := false
+	for req := range requests {
+		ok := false
+		switch req.Type {
+		case "pty-req":
+			var msg ptyRequestMsg
+			if ok = ssh.Unmarshal(req.Payload, &msg) == nil; ok {
+				t.pty = true
+				t.resize(msg.Columns, msg.Rows)
+			}
+		case "window-change":
+			var msg windowChangeMsg
+			if ok = ssh.Unmarshal(req.Payload, &msg) == nil; ok {
+				t.resize(msg.Columns, msg.Rows)
+			}
+		case "env":
+			var msg envRequestMsg
+			if ssh.Unmarshal(req.Payload, &msg) == nil && c.register() == nil {
+				ok = c.setEnv(msg.Name, msg.Value) == nil
+			}
+		case "exec":
+			var msg execRequestMsg
+			if ok = !started && ssh.Unmarshal(req.Payload, &msg) == nil; ok {
+				started = true
+				go c.finish(ch, func() uint32 { return c.exec(ctx, ch, t, msg.Command) })
+			}
+		case "shell":
+			if ok = !started; ok {
+				started = true
+				go c.finish(ch, func() uint32 { return c.shell(ctx, ch, t) })
+			}
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+}
+
+// finish runs an exec or shell and closes the channel with its exit status
+func (c *sshConn) finish(ch ssh.Channel, run func() uint32) {
+	status := run()
+	ch.SendRequest("exit-status", false, ssh.Marshal(exitStatusMsg{Status: status}))
+	ch.Close()
+}
+
+// exec runs one command, like ssh host 'command'
+func (c *sshConn) exec(ctx context.Context, ch ssh.Channel, t *sshChannel, command string) uint32 {
+	if err := c.register(); err != nil {
+		fmt.Fprintf(ch.Stderr(), "Error: %v\n", err)
+		return 1
+	}
+	t.applySize(c)
+	c.srv.svc.calls.begin()
+	defer c.srv.svc.calls.end()
+	// A command that prints nothing for a while must not look idle
+	c.conn.calls.begin()
+	defer c.conn.calls.end()
+
+	var resp protocol.CommandResponse
+	req := protocol.CommandRequest{ID: c.id, Token: c.token, Secret: c.secret, Command: command}
+	if !c.srv.svc.execute(ctx, req, ch, &resp) && !resp.Replayed {
+		fmt.Fprintf(ch.Stderr(), "Error: %s\n", resp.Error)
+		return 1
+	}
+	// The stream stops at the output limit and ssh has no FetchOutput, so
+	// the rest is sent from the spool
+	if resp.OutputHandle != "" {
+		if err := c.srv.svc.copySpool(c.id, resp.OutputHandle, int64(len(resp.Output)), ch); err != nil {
+			fmt.Fprintf(ch.Stderr(), "Error: output cut at %d of %d bytes: %v\n", len(resp.Output), resp.OutputSize, err)
+		}
+	}
+	if resp.ExitCode < 0 {
+		fmt.Fprintf(ch.Stderr(), "%s\n", resp.Error)
+		return 255
+	}
+	return uint32(resp.ExitCode)
+}
+
+// shell is an interactive session; see runShell. With a pty the server does
+// the line editing, otherwise lines are read as sent.
+func (c *sshConn) shell(ctx context.Context, ch ssh.Channel, t *sshChannel) uint32 {
+	if err := c.register(); err != nil {
+		fmt.Fprintf(ch.Stderr(), "Error: %v\n", err)
+		return 1
+	}
+	var out io.Writer = ch
+	var readLine func() (string, error)
+	if t.pty {
+		terminal := term.NewTerminal(ch, fmt.Sprintf("[%s@remote]$ ", c.id))
+		t.mu.Lock()
+		t.terminal = terminal
+		if t.cols > 0 && t.rows > 0 {
+			terminal.SetSize(int(t.cols), int(t.rows))
+		}
+		t.mu.Unlock()
+		out, readLine = terminal, terminal.ReadLine
+	} else {
+		lines := bufio.NewReader(ch)
+		readLine = func() (string, error) {
+			line, err := lines.ReadString('\n')
+			if err == io.EOF && line != "" {
+				err = nil
+			}
+			return line, err
+		}
+	}
+	// The connection only counts as idle while the shell waits for a line,
+	// not while one of its commands runs
+	c.conn.calls.begin()
+	defer c.conn.calls.end()
+	recv := func() (string, error) {
+		c.conn.calls.end()
+		line, err := readLine()
+		c.conn.calls.begin()
+		if err == nil {
+			t.applySize(c)
+		}
+		return line, err
+	}
+
+	err := c.srv.svc.runShell(ctx, "SSH", c.id, c.token, c.secret, recv, sshShellStream{out})
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errShuttingDown):
+		fmt.Fprintln(out, "[server] server shutting down")
+	default:
+		fmt.Fprintf(out, "Error: %v\n", err)
+	}
+	return 1
+}
+
+// sshShellStream writes an interactive session's replies to the terminal,
+// the way the interactive client prints them
+type sshShellStream struct {
+	w io.Writer
+}
+
+func (s sshShellStream) sendOutput(p []byte) error {
+	_, err := s.w.Write(p)
+	return err
+}
+
+func (s sshShellStream) sendResult(resp protocol.CommandResponse) error {
+	var msg string
+	if resp.OutputHandle != "" {
+		msg = fmt.Sprintf("(output cut at %d of %d bytes; ssh host 'command' sends all of it)\n", len(resp.Output), resp.OutputSize)
+	}
+	if resp.ExitCode != 0 {
+		msg += fmt.Sprintf("Exit code: %d\n", resp.ExitCode)
+		if resp.Error != "" {
+			msg += resp.Error + "\n"
+		}
+	}
+	if msg == "" {
+		return nil
+	}
+	_, err := io.WriteString(s.w, msg)
+	return err
+}
+
+func (s sshShellStream) sendEvent(e protocol.Event) error {
+	var msg string
+	switch e.Kind {
+	case "broadcast":
+		msg = fmt.Sprintf("[broadcast %s] %s\n", e.Time.Format("15:04:05"), e.Message)
+	case "approval":
+		msg = fmt.Sprintf("[approval] %s\n", e.Message)
+	case "session-end":
+		msg = fmt.Sprintf("[server] session ended: %s\n", e.Message)
+	default:
+		return nil
+	}
+	_, err := io.WriteString(s.w, msg)
+	return err
+}
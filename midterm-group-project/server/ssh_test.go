@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestKey(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// startTestSSH serves the SSH front end on a loopback port for a service
+// that knows key as user alice, and returns its address
+func startTestSSH(t *testing.T, r *RemoteShellService, key ssh.Signer) string {
+	t.Helper()
+	authorized := filepath.Join(t.TempDir(), "authorized_keys")
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key.PublicKey()))) + " alice\n"
+	if err := os.WriteFile(authorized, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := startSSHServer("127.0.0.1:0", "", authorized, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+func dialTestSSH(addr, session string, key ssh.Signer) (*ssh.Client, error) {
+	return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            session,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(key)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+func TestSSHExecAndShell(t *testing.T) {
+	r := newTestService(t)
+	key := newTestKey(t)
+	addr := startTestSSH(t, r, key)
+
+	client, err := dialTestSSH(addr, "web", key)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	exec, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Output("echo exec-ok")
+	exec.Close()
+	if err != nil || string(out) != "exec-ok\n" {
+		t.Fatalf("exec = %q, %v", out, err)
+	}
+
+	shell, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := shell.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	shell.Stdout = &stdout
+	if err := shell.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	// Lines share the session, so the cd applies to the next command
+	io.WriteString(stdin, "cd /\n")
+	io.WriteString(stdin, "pwd\n")
+	stdin.Close()
+	if err := shell.Wait(); err != nil {
+		t.Fatalf("shell: %v (output %q)", err, stdout.String())
+	}
+	if stdout.String() != "/\n" {
+		t.Fatalf("shell output %q does not show the command's output", stdout.String())
+	}
+
+	r.mu.RLock()
+	session := r.sessions["web"]
+	r.mu.RUnlock()
+	if session == nil || session.Owner != "ssh:alice" {
+		t.Fatalf("session web = %+v, want one owned by ssh:alice", session)
+	}
+}
+
+func TestSSHRejectsUnknownKey(t *testing.T) {
+	r := newTestService(t)
+	addr := startTestSSH(t, r, newTestKey(t))
+
+	if client, err := dialTestSSH(addr, "web", newTestKey(t)); err == nil {
+		client.Close()
+		t.Fatal("unknown key was accepted")
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.sessions) != 0 {
+		t.Fatalf("unknown key opened sessions: %v", r.sessions)
+	}
+}
+
+func TestSSHCountsAgainstMaxConnections(t *testing.T) {
+	r := newTestService(t)
+	r.connLimit = newConnLimit(1)
+	key := newTestKey(t)
+	addr := startTestSSH(t, r, key)
+
+	first, err := dialTestSSH(addr, "web", key)
+	if err != nil {
+		t.Fatalf("first dial: %v", err)
+	}
+	if second, err := dialTestSSH(addr, "web", key); err == nil {
+		second.Close()
+		t.Fatal("second connection accepted over --max-connections 1")
+	}
+	r.metrics.mu.Lock()
+	rejected := r.metrics.connectionsRejected
+	r.metrics.mu.Unlock()
+	if rejected != 1 {
+		t.Fatalf("connectionsRejected = %d, want 1", rejected)
+	}
+
+	// The slot is free again once the first connection is gone
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		third, err := dialTestSSH(addr, "web", key)
+		if err == nil {
+			third.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("slot not released after close: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSSHClosesIdleConnection(t *testing.T) {
+	r := newTestService(t)
+	r.idleTimeout = 200 * time.Millisecond
+	key := newTestKey(t)
+	addr := startTestSSH(t, r, key)
+
+	client, err := dialTestSSH(addr, "web", key)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	// A command running longer than the idle timeout keeps the connection
+	exec, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Output("sleep 0.5; echo done")
+	exec.Close()
+	if err != nil || string(out) != "done\n" {
+		t.Fatalf("long command = %q, %v", out, err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- client.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle SSH connection was not closed")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// maxStdinUploads is how many uploaded stdins a session may hold before
+// Execute uses them
+const maxStdinUploads = 8
+
+// UploadStdin stores one chunk of a command's stdin in a temporary file.
+// The first chunk starts the upload and returns the handle Execute takes as
+// StdinHandle; the file is deleted once a command has read it.
+func (r *RemoteShellService) UploadStdin(req protocol.StdinChunk, resp *protocol.StdinUploadResponse) error {
+	if !r.validateToken(req.Token) {
+		resp.Error = "unauthorized"
+		return nil
+	}
+	if r.isBanned(req.ID) {
+		resp.Error = "banned"
+		return nil
+	}
+	// Only the first chunk counts against the rate limit, as for Upload
+	if req.Handle == "" && !r.consumeRate(req.ID) {
+		resp.Error = "rate limit exceeded"
+		return nil
+	}
+	if len(req.Data) > protocol.MaxChunkSize {
+		resp.Error = fmt.Sprintf("chunk too large (max %d bytes)", protocol.MaxChunkSize)
+		return nil
+	}
+	if r.maxTransfer > 0 && req.Offset+int64(len(req.Data)) > r.maxTransfer {
+		resp.Error = fmt.Sprintf("stdin exceeds transfer limit of %d bytes", r.maxTransfer)
+		return nil
+	}
+	if r.isDraining() {
+		resp.Error = "server shutting down"
+		return nil
+	}
+
+	r.mu.Lock()
+	session, exists := r.sessions[req.ID]
+	if !exists {
+		r.mu.Unlock()
+		resp.Error = "client not registered"
+		return nil
+	}
+	if !r.authorizeSession(session, req.Token, req.Secret, "UploadStdin") {
+		r.mu.Unlock()
+		resp.Error = "invalid session secret"
+		return nil
+	}
+	session.LastActive = time.Now()
+	handle, path := req.Handle, session.stdins[req.Handle]
+	if handle == "" {
+		if len(session.stdins) >= maxStdinUploads {
+			r.mu.Unlock()
+			resp.Error = fmt.Sprintf("too many unused stdin uploads (max %d)", maxStdinUploads)
+			return nil
+		}
+		f, err := os.CreateTemp(r.spoolDir, "rshell-stdin-*")
+		if err != nil {
+			r.mu.Unlock()
+			resp.Error = err.Error()
+			return nil
+		}
+		f.Close()
+		path = f.Name()
+		handle = session.addStdin(path)
+	}
+	r.mu.Unlock()
+	if path == "" {
+		resp.Error = fmt.Sprintf("not found: no stdin upload %q", req.Handle)
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		resp.Error = fmt.Sprintf("not found: stdin upload %q is gone", handle)
+		return nil
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() != req.Offset {
+		err = fmt.Errorf("offset mismatch: have %d bytes, chunk starts at %d", info.Size(), req.Offset)
+	}
+	if err == nil {
+		_, err = f.Write(req.Data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		return nil
+	}
+	resp.Handle = handle
+	resp.Written = req.Offset + int64(len(req.Data))
+	return nil
+}
+
+// addStdin keeps path as an uploaded stdin and returns its handle. Caller
+// must hold r.mu.
+func (s *Session) addStdin(path string) string {
+	if s.stdins == nil {
+		s.stdins = make(map[string]string)
+	}
+	s.nextSpool++
+	handle := "stdin-" + strconv.Itoa(s.nextSpool)
+	s.stdins[handle] = path
+	return handle
+}
+
+// takeStdin removes an uploaded stdin from the session and returns its
+// file, or "" if there is no such upload. Caller must hold r.mu.
+func (s *Session) takeStdin(handle string) string {
+	path := s.stdins[handle]
+	delete(s.stdins, handle)
+	return path
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"remote-shell-rpc/protocol"
+)
+
+func TestExecuteInlineStdin(t *testing.T) {
+	r := newTestService(t)
+	reg := register(t, r, "dev", "")
+	req := protocol.CommandRequest{ID: "dev", Token: "tok", Secret: reg.Secret, Command: "cat", Stdin: []byte("hello\nworld\n")}
+
+	var resp protocol.CommandResponse
+	r.Execute(req, &resp)
+	if resp.ExitCode != 0 || resp.Output != "hello\nworld\n" {
+		t.Fatalf("cat with stdin = exit %d %q", resp.ExitCode, resp.Output)
+	}
+
+	// Without stdin a command reads an empty input instead of waiting
+	req.Stdin = nil
+	r.Execute(req, &resp)
+	if resp.ExitCode != 0 || resp.Output != "" {
+		t.Fatalf("cat without stdin = exit %d %q %s", resp.ExitCode, resp.Output, resp.Error)
+	}
+
+	req.Stdin = bytes.Repeat([]byte("x"), protocol.MaxInlineStdin+1)
+	resp = protocol.CommandResponse{}
+	r.Execute(req, &resp)
+	if resp.ExitCode != -1 || !strings.Contains(resp.Error, "UploadStdin") {
+		t.Fatalf("oversized inline stdin = exit %d %q, want it refused", resp.ExitCode, resp.Error)
+	}
+}
+
+func TestExecuteUploadedStdin(t *testing.T) {
+	r := newTestService(t)
+	r.spoolDir = t.TempDir()
+	reg := register(t, r, "dev", "")
+	chunk := bytes.Repeat([]byte("y"), protocol.MaxChunkSize)
+
+	var up protocol.StdinUploadResponse
+	r.UploadStdin(protocol.StdinChunk{ID: "dev", Token: "tok", Secret: reg.Secret, Data: chunk}, &up)
+	if up.Error != "" || up.Handle == "" {
+		t.Fatalf("first chunk: %+v", up)
+	}
+	handle := up.Handle
+	r.UploadStdin(protocol.StdinChunk{ID: "dev", Token: "tok", Secret: reg.Secret, Handle: handle, Offset: 1, Data: chunk}, &up)
+	if !strings.Contains(up.Error, "offset mismatch") {
+		t.Fatalf("chunk at the wrong offset = %q", up.Error)
+	}
+	up = protocol.StdinUploadResponse{}
+	r.UploadStdin(protocol.StdinChunk{ID: "dev", Token: "tok", Secret: reg.Secret, Handle: handle, Offset: int64(len(chunk)), Data: []byte("z\n")}, &up)
+	if up.Error != "" || up.Written != int64(len(chunk))+2 {
+		t.Fatalf("second chunk: %+v", up)
+	}
+
+	req := protocol.CommandRequest{ID: "dev", Token: "tok", Secret: reg.Secret, Command: "wc -c", StdinHandle: handle}
+	var resp protocol.CommandResponse
+	r.Execute(req, &resp)
+	if want := strconv.Itoa(len(chunk) + 2); resp.ExitCode != 0 || strings.TrimSpace(resp.Output) != want {
+		t.Fatalf("wc -c of the upload = exit %d %q, want %s", resp.ExitCode, resp.Output, want)
+	}
+
+	// The upload is used once and deleted
+	resp = protocol.CommandResponse{}
+	r.Execute(req, &resp)
+	if resp.ExitCode != -1 || !strings.HasPrefix(resp.Error, "not found") {
+		t.Fatalf("second use of the upload = exit %d %q", resp.ExitCode, resp.Error)
+	}
+	if files, _ := os.ReadDir(r.spoolDir); len(files) != 0 {
+		t.Fatalf("stdin files left: %v", files)
+	}
+}
+
+func TestUploadStdinLimits(t *testing.T) {
+	r := newTestService(t)
+	r.spoolDir = t.TempDir()
+	r.maxTransfer = 10
+	reg := register(t, r, "dev", "")
+
+	var up protocol.StdinUploadResponse
+	r.UploadStdin(protocol.StdinChunk{ID: "dev", Token: "tok", Secret: reg.Secret, Data: []byte("0123456789a")}, &up)
+	if !strings.Contains(up.Error, "transfer limit") {
+		t.Fatalf("stdin over --max-transfer-mb = %+v, want it refused", up)
+	}
+
+	up = protocol.StdinUploadResponse{}
+	r.UploadStdin(protocol.StdinChunk{ID: "dev", Token: "tok", Secret: reg.Secret, Data: []byte("01234")}, &up)
+	if up.Error != "" {
+		t.Fatalf("first chunk: %s", up.Error)
+	}
+	handle := up.Handle
+	up = protocol.StdinUploadResponse{}
+	r.UploadStdin(protocol.StdinChunk{ID: "dev", Token: "tok", Secret: reg.Secret, Handle: handle, Offset: 5, Data: []byte("567890")}, &up)
+	if !strings.Contains(up.Error, "transfer limit") {
+		t.Fatalf("chunk crossing the limit = %+v, want it refused", up)
+	}
+
+	r.maxTransfer = 0
+	up = protocol.StdinUploadResponse{}
+	r.UploadStdin(protocol.StdinChunk{ID: "dev", Token: "tok", Secret: reg.Secret, Data: make([]byte, protocol.MaxChunkSize+1)}, &up)
+	if !strings.Contains(up.Error, "chunk too large") {
+		t.Fatalf("oversized chunk = %+v, want it refused", up)
+	}
+
+	for i := 1; i < maxStdinUploads; i++ {
+		up = protocol.StdinUploadResponse{}
+		r.UploadStdin(protocol.StdinChunk{ID: "dev", Token: "tok", Secret: reg.Secret, Data: []byte("x")}, &up)
+		if up.Error != "" {
+			t.Fatalf("upload %d: %s", i, up.Error)
+		}
+	}
+	up = protocol.StdinUploadResponse{}
+	r.UploadStdin(protocol.StdinChunk{ID: "dev", Token: "tok", Secret: reg.Secret, Data: []byte("x")}, &up)
+	if !strings.Contains(up.Error, "too many") {
+		t.Fatalf("upload past the per-session cap = %+v, want it refused", up)
+	}
+}
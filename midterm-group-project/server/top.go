@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// requestMeter counts requests over the last minute in one-second buckets
+type requestMeter struct {
+	counts [60]int
+	stamps [60]int64 // Unix second each bucket was last reset for
+}
+
+func (m *requestMeter) mark(now time.Time) {
+	sec := now.Unix()
+	i := sec % 60
+	if m.stamps[i] != sec {
+		m.stamps[i], m.counts[i] = sec, 0
+	}
+	m.counts[i]++
+}
+
+func (m *requestMeter) lastMinute(now time.Time) int {
+	sec := now.Unix()
+	total := 0
+	for i, stamp := range m.stamps {
+		if sec-stamp < 60 {
+			total += m.counts[i]
+		}
+	}
+	return total
+}
+
+// markRequest counts a request from client id for admin top
+func (r *RemoteShellService) markRequest(id string) {
+	r.rateMu.Lock()
+	defer r.rateMu.Unlock()
+	if r.requestMeters == nil {
+		r.requestMeters = make(map[string]*requestMeter)
+	}
+	m, ok := r.requestMeters[id]
+	if !ok {
+		m = &requestMeter{}
+		r.requestMeters[id] = m
+	}
+	m.mark(time.Now())
+}
+
+// requestRates returns requests in the last minute per client, forgetting
+// clients that have been quiet for a whole minute
+func (r *RemoteShellService) requestRates() map[string]int {
+	r.rateMu.Lock()
+	defer r.rateMu.Unlock()
+	now := time.Now()
+	out := make(map[string]int, len(r.requestMeters))
+	for id, m := range r.requestMeters {
+		n := m.lastMinute(now)
+		if n == 0 {
+			delete(r.requestMeters, id)
+			continue
+		}
+		out[id] = n
+	}
+	return out
+}
+
+// Top returns a snapshot of sessions, running commands, request rates and
+// recent denials (admin only)
+func (r *RemoteShellService) Top(req protocol.ListRequest, resp *protocol.TopSnapshot) error {
+	if !r.validateAdmin(req.Token) {
+		return fmt.Errorf("unauthorized")
+	}
+	rates := r.requestRates()
+
+	snap := protocol.TopSnapshot{Time: time.Now(), DenialTotal: r.metrics.denialCounts()}
+	clients := make(map[string]*protocol.ClientActivity)
+	client := func(id string) *protocol.ClientActivity {
+		if clients[id] == nil {
+			clients[id] = &protocol.ClientActivity{ClientID: id}
+		}
+		return clients[id]
+	}
+
+	r.mu.RLock()
+	snap.Sessions = len(r.sessions)
+	for id, s := range r.sessions {
+		c := client(id)
+		c.LastActive = s.LastActive
+		c.Connected = len(s.conns) > 0
+		var addrs []string
+		for conn := range s.conns {
+			addrs = append(addrs, conn.RemoteAddr().String())
+		}
+		c.RemoteAddr = strings.Join(addrs, ",")
+		snap.Connections += len(s.conns)
+	}
+	for _, j := range r.jobs {
+		snap.Jobs = append(snap.Jobs, j)
+		client(j.ClientID).Running++
+	}
+	r.mu.RUnlock()
+
+	for id, n := range rates {
+		client(id).RequestsLastMin = n
+	}
+	for _, c := range clients {
+		snap.Clients = append(snap.Clients, *c)
+	}
+	sort.Slice(snap.Jobs, func(i, j int) bool { return snap.Jobs[i].JobID < snap.Jobs[j].JobID })
+	sort.Slice(snap.Clients, func(i, j int) bool {
+		a, b := snap.Clients[i], snap.Clients[j]
+		if a.RequestsLastMin != b.RequestsLastMin {
+			return a.RequestsLastMin > b.RequestsLastMin
+		}
+		return a.ClientID < b.ClientID
+	})
+
+	for _, e := range r.audit.recent("", 0) {
+		if strings.HasPrefix(e.Outcome, "rejected") {
+			snap.Denials = append(snap.Denials, e)
+		}
+	}
+	if len(snap.Denials) > 10 {
+		snap.Denials = snap.Denials[len(snap.Denials)-10:]
+	}
+	*resp = snap
+	return nil
+}
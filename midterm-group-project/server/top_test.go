@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPruneRatesForgetsQuietClients(t *testing.T) {
+	r := NewRemoteShellService("tok", nil, 10, time.Minute, 10*time.Second, 64*1024, false)
+	defer close(r.stopCleanup)
+	for i := 0; i < 100; i++ {
+		r.consumeRate(fmt.Sprintf("probe-%d", i))
+	}
+	r.consumeRate("busy")
+
+	// Age every client but busy past its window and the meters' minute
+	r.rateMu.Lock()
+	for id, info := range r.rateCounters {
+		if id != "busy" {
+			info.windowFrom = info.windowFrom.Add(-2 * time.Minute)
+			r.requestMeters[id].stamps = [60]int64{}
+		}
+	}
+	r.rateMu.Unlock()
+
+	r.pruneRates()
+	r.rateMu.Lock()
+	defer r.rateMu.Unlock()
+	if len(r.rateCounters) != 1 || len(r.requestMeters) != 1 || r.requestMeters["busy"] == nil {
+		t.Fatalf("after pruning: %d rate windows, %d meters, want only busy's", len(r.rateCounters), len(r.requestMeters))
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"remote-shell-rpc/protocol"
+)
+
+// Upload stores one chunk of a file relative to the session working directory
+func (r *RemoteShellService) Upload(req protocol.UploadRequest, resp *protocol.UploadResponse) error {
+	if !r.validateToken(req.Token) {
+		resp.Error = "unauthorized"
+		return nil
+	}
+	if r.isBanned(req.ID) {
+		resp.Error = "banned"
+		return nil
+	}
+	// Only the first chunk counts against the rate limit so large files are not throttled
+	if req.Offset == 0 && !r.consumeRate(req.ID) {
+		resp.Error = "rate limit exceeded"
+		return nil
+	}
+	if len(req.Data) > protocol.MaxChunkSize {
+		resp.Error = fmt.Sprintf("chunk too large (max %d bytes)", protocol.MaxChunkSize)
+		return nil
+	}
+	if r.isDraining() {
+		resp.Error = "server shutting down"
+		return nil
+	}
+
+	dest, err := r.resolveTransferPath(req.ID, req.Token, req.Secret, "Upload", req.Path)
+	if err != nil {
+		resp.Error = err.Error()
+		return nil
+	}
+	tmp := partialUploadPath(dest, req.ID)
+
+	if r.maxTransfer > 0 && req.Offset+int64(len(req.Data)) > r.maxTransfer {
+		os.Remove(tmp)
+		resp.Error = fmt.Sprintf("file exceeds transfer limit of %d bytes", r.maxTransfer)
+		return nil
+	}
+
+	f, err := openPartialUpload(tmp, req.Offset == 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			resp.Error = "no upload in progress (restart from offset 0)"
+		} else {
+			resp.Error = err.Error()
+		}
+		return nil
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() != req.Offset {
+		err = fmt.Errorf("offset mismatch: have %d bytes, chunk starts at %d", info.Size(), req.Offset)
+	}
+	if err == nil {
+		_, err = f.Write(req.Data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		return nil
+	}
+	resp.Written = req.Offset + int64(len(req.Data))
+	r.touchSession(req.ID)
+
+	if !req.Final {
+		return nil
+	}
+
+	sum, err := fileChecksum(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		resp.Error = err.Error()
+		return nil
+	}
+	if req.Checksum == "" || !strings.EqualFold(sum, req.Checksum) {
+		os.Remove(tmp)
+		resp.Error = fmt.Sprintf("checksum mismatch: got %s, expected %s", sum, req.Checksum)
+		log.Printf("[Client %s] Upload %s failed: checksum mismatch", req.ID, dest)
+		return nil
+	}
+	mode := req.Mode.Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		log.Printf("[Client %s] Upload %s: chmod failed: %v", req.ID, dest, err)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		resp.Error = err.Error()
+		return nil
+	}
+	resp.Path = dest
+	log.Printf("[Client %s] Uploaded %s (%d bytes)", req.ID, dest, resp.Written)
+	return nil
+}
+
+// Download returns one chunk of a file relative to the session working directory
+func (r *RemoteShellService) Download(req protocol.DownloadRequest, resp *protocol.DownloadResponse) error {
+	if !r.validateToken(req.Token) {
+		resp.Error = "unauthorized"
+		return nil
+	}
+	if r.isBanned(req.ID) {
+		resp.Error = "banned"
+		return nil
+	}
+	if req.Offset == 0 && !r.consumeRate(req.ID) {
+		resp.Error = "rate limit exceeded"
+		return nil
+	}
+
+	src, err := r.resolveTransferPath(req.ID, req.Token, req.Secret, "Download", req.Path)
+	if err != nil {
+		resp.Error = err.Error()
+		return nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		resp.Error = err.Error()
+		return nil
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		resp.Error = err.Error()
+		return nil
+	}
+	if info.IsDir() {
+		resp.Error = fmt.Sprintf("%s is a directory", req.Path)
+		return nil
+	}
+	if r.maxTransfer > 0 && info.Size() > r.maxTransfer {
+		resp.Error = fmt.Sprintf("file exceeds transfer limit of %d bytes", r.maxTransfer)
+		return nil
+	}
+	resp.Size = info.Size()
+	resp.Mode = info.Mode().Perm()
+
+	if req.Offset == 0 {
+		sum, err := fileChecksum(src)
+		if err != nil {
+			resp.Error = err.Error()
+			return nil
+		}
+		resp.Checksum = sum
+		log.Printf("[Client %s] Download started: %s (%d bytes)", req.ID, src, info.Size())
+	}
+
+	length := req.Length
+	if length <= 0 || length > protocol.MaxChunkSize {
+		length = protocol.MaxChunkSize
+	}
+	buf := make([]byte, length)
+	n, err := f.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		resp.Error = err.Error()
+		return nil
+	}
+	resp.Data = buf[:n]
+	resp.EOF = req.Offset+int64(n) >= info.Size()
+	r.touchSession(req.ID)
+	return nil
+}
+
+// resolveTransferPath maps a client supplied path onto the filesystem,
+// relative to the session working directory and confined to transferRoot,
+// or to the working directory when no root is configured. With a command
+// whitelist but no root, transfers are refused: the whitelist does not
+// limit cd, so the working directory confines nothing.
+func (r *RemoteShellService) resolveTransferPath(clientID, token, secret, op, p string) (string, error) {
+	if strings.TrimSpace(p) == "" {
+		return "", fmt.Errorf("path required")
+	}
+
+	r.mu.RLock()
+	session, exists := r.sessions[clientID]
+	workDir, authorized := "", false
+	if exists {
+		workDir = session.WorkDir
+		authorized = r.authorizeSession(session, token, secret, op)
+	}
+	whitelisted := len(r.allowedCmds) > 0
+	r.mu.RUnlock()
+	if !exists {
+		return "", fmt.Errorf("client not registered")
+	}
+	if !authorized {
+		return "", fmt.Errorf("invalid session secret")
+	}
+
+	// root is checked before symlinks in the path are resolved, realRoot after
+	root, realRoot := r.transferRoot, r.transferRoot
+	if root == "" {
+		if whitelisted {
+			return "", fmt.Errorf("file transfer disabled: the command whitelist needs --transfer-root")
+		}
+		root = workDir
+		var err error
+		if realRoot, err = filepath.EvalSymlinks(workDir); err != nil {
+			return "", fmt.Errorf("working directory %s does not exist", workDir)
+		}
+	}
+
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(workDir, p)
+	}
+	p = filepath.Clean(p)
+
+	if !withinDir(root, p) {
+		log.Printf("[Client %s] Transfer outside root denied: %s", clientID, p)
+		return "", fmt.Errorf("path outside transfer root %s", root)
+	}
+	// Resolve symlinks in the parent so a link cannot point outside the jail
+	dir, err := filepath.EvalSymlinks(filepath.Dir(p))
+	if err != nil {
+		return "", fmt.Errorf("directory %s does not exist", filepath.Dir(p))
+	}
+	p = filepath.Join(dir, filepath.Base(p))
+	if target, err := filepath.EvalSymlinks(p); err == nil {
+		p = target
+	}
+	if !withinDir(realRoot, p) {
+		log.Printf("[Client %s] Transfer outside root denied (symlink): %s", clientID, p)
+		return "", fmt.Errorf("path outside transfer root %s", root)
+	}
+	return p, nil
+}
+
+// touchSession updates LastActive for a session if it exists
+func (r *RemoteShellService) touchSession(clientID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if session, ok := r.sessions[clientID]; ok {
+		session.LastActive = time.Now()
+	}
+}
+
+// withinDir reports whether p is root or lies below it
+func withinDir(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// partialUploadPath is where chunks are collected before the final rename
+func partialUploadPath(dest, clientID string) string {
+	return filepath.Join(filepath.Dir(dest), fmt.Sprintf(".%s.upload-%s.part", filepath.Base(dest), sanitizeName(clientID)))
+}
+
+// openPartialUpload opens the partial file for the next chunk. Its name is
+// predictable, so it must never follow a symlink someone planted there out
+// of the transfer root: the first chunk replaces whatever is at tmp with a
+// new file (O_EXCL fails on a symlink), and later chunks only append to a
+// regular file that is still the one checked.
+func openPartialUpload(tmp string, first bool) (*os.File, error) {
+	if first {
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	}
+	before, err := os.Lstat(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if !before.Mode().IsRegular() {
+		return nil, fmt.Errorf("partial upload %s is not a regular file", filepath.Base(tmp))
+	}
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if after, err := f.Stat(); err != nil || !os.SameFile(before, after) {
+		f.Close()
+		return nil, fmt.Errorf("partial upload %s changed while opening it", filepath.Base(tmp))
+	}
+	return f, nil
+}
+
+func sanitizeName(s string) string {
+	return strings.Map(func(c rune) rune {
+		if c == '-' || c == '_' || c == '.' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			return c
+		}
+		return '_'
+	}, s)
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"remote-shell-rpc/protocol"
+)
+
+func TestOpenPartialUploadIgnoresPlantedSymlink(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	target := filepath.Join(outside, "victim")
+	if err := os.WriteFile(target, []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tmp := partialUploadPath(filepath.Join(root, "file.txt"), "c1")
+
+	// A link planted before the upload is replaced, not followed
+	if err := os.Symlink(target, tmp); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	f, err := openPartialUpload(tmp, true)
+	if err != nil {
+		t.Fatalf("first chunk: %v", err)
+	}
+	f.WriteString("data")
+	f.Close()
+	if info, err := os.Lstat(tmp); err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("partial file is not a regular file: %v %v", info, err)
+	}
+
+	// A link swapped in between chunks is refused
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := openPartialUpload(tmp, false); err == nil {
+		f.Close()
+		t.Fatal("appended through a symlink")
+	}
+
+	if data, _ := os.ReadFile(target); string(data) != "keep" {
+		t.Fatalf("file outside the root changed to %q", data)
+	}
+}
+
+// uploadFile sends data to path in one chunk and returns the error reply
+func uploadFile(r *RemoteShellService, secret, path, data string) string {
+	sum := sha256.Sum256([]byte(data))
+	var resp protocol.UploadResponse
+	r.Upload(protocol.UploadRequest{ID: "dev", Token: "tok", Secret: secret, Path: path, Data: []byte(data), Final: true, Checksum: hex.EncodeToString(sum[:])}, &resp)
+	return resp.Error
+}
+
+func TestTransfersConfinedToWorkDir(t *testing.T) {
+	r := newTestService(t)
+	reg := register(t, r, "dev", "")
+	work, outside := t.TempDir(), t.TempDir()
+	r.mu.Lock()
+	r.sessions["dev"].WorkDir = work
+	r.mu.Unlock()
+
+	if e := uploadFile(r, reg.Secret, "in.txt", "ok"); e != "" {
+		t.Fatalf("upload inside the working directory: %s", e)
+	}
+	if data, _ := os.ReadFile(filepath.Join(work, "in.txt")); string(data) != "ok" {
+		t.Fatalf("uploaded file = %q", data)
+	}
+	for _, p := range []string{filepath.Join(outside, "abs.txt"), "../escape.txt"} {
+		if e := uploadFile(r, reg.Secret, p, "no"); e == "" {
+			t.Errorf("upload to %s was accepted", p)
+		}
+	}
+	var down protocol.DownloadResponse
+	r.Download(protocol.DownloadRequest{ID: "dev", Token: "tok", Secret: reg.Secret, Path: "/etc/hostname"}, &down)
+	if down.Error == "" {
+		t.Error("download outside the working directory was accepted")
+	}
+
+	// A link in the working directory cannot lead out of it
+	if err := os.Symlink(outside, filepath.Join(work, "link")); err == nil {
+		if e := uploadFile(r, reg.Secret, "link/x.txt", "no"); e == "" {
+			t.Error("upload through a symlink was accepted")
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Fatalf("files written outside the working directory: %v", entries)
+	}
+}
+
+func TestTransfersRefusedWithWhitelistWithoutRoot(t *testing.T) {
+	r := newTestService(t)
+	r.allowedCmds = map[string]struct{}{"ls": {}}
+	reg := register(t, r, "dev", "")
+	if e := uploadFile(r, reg.Secret, "x.txt", "no"); !strings.Contains(e, "--transfer-root") {
+		t.Fatalf("upload with a whitelist and no root = %q, want it refused", e)
+	}
+
+	r.transferRoot = t.TempDir()
+	if e := uploadFile(r, reg.Secret, filepath.Join(r.transferRoot, "x.txt"), "ok"); e != "" {
+		t.Fatalf("upload below --transfer-root: %s", e)
+	}
+}
+
+func TestUploadRefusedWhileDraining(t *testing.T) {
+	r := newTestService(t)
+	reg := register(t, r, "dev", "")
+	r.mu.Lock()
+	r.sessions["dev"].WorkDir = t.TempDir()
+	r.draining = true
+	r.mu.Unlock()
+	if e := uploadFile(r, reg.Secret, "x.txt", "no"); e != "server shutting down" {
+		t.Fatalf("upload while draining = %q", e)
+	}
+}